@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"fmt"
 	"github.com/kic/users/pkg/database"
 	"google.golang.org/grpc"
 	"gorm.io/gorm"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"time"
 
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	"go.uber.org/zap"
@@ -16,6 +22,8 @@ import (
 
 	"github.com/kic/users/internal/server"
 	"github.com/kic/users/pkg/logging"
+	"github.com/kic/users/pkg/mail"
+	"github.com/kic/users/pkg/password"
 	pbusers "github.com/kic/users/pkg/proto/users"
 )
 
@@ -39,15 +47,24 @@ func main() {
 		logger.Fatalf("Unable to listen on %v: %v", ListenAddress, err)
 	}
 
-	grpcServer := grpc.NewServer()
-
 	db, err := gorm.Open(mysql.Open(dbConnString), &gorm.Config{})
 
 	if err != nil {
 		logger.Fatalf("Unable connect to db %v", err)
 	}
 
-	err = db.AutoMigrate(&database.UserModel{})
+	err = db.AutoMigrate(
+		&database.UserModel{},
+		&database.RefreshTokenModel{},
+		&database.RevokedTokenModel{},
+		&database.UserRevocationModel{},
+		&database.LoginAttemptModel{},
+		&database.MFAModel{},
+		&database.RecoveryCodeModel{},
+		&database.OneTimeTokenModel{},
+		&database.SessionModel{},
+		&database.ServiceAccountModel{},
+	)
 
 	if err != nil {
 		logger.Fatalf("Unable migrate tables to db %v", err)
@@ -55,7 +72,194 @@ func main() {
 
 	repo := database.NewSQLRepository(db, logger)
 
-	serv := server.NewUsersService(repo, logger)
+	refreshTokens := database.NewSQLRefreshTokenRepository(db, logger)
+	revocations := database.NewSQLRevocationRepository(db, logger)
+	loginAttempts := database.NewSQLLoginAttemptRepository(db, logger)
+	mfa := database.NewSQLMFARepository(db, logger)
+	oneTimeTokens := database.NewSQLOneTimeTokenRepository(db, logger)
+	sessions := database.NewSQLSessionRepository(db, logger)
+	serviceAccounts := database.NewSQLServiceAccountRepository(db, logger)
+
+	opts := []server.ServiceOption{
+		server.WithRefreshTokenRepository(refreshTokens),
+		server.WithRevocationRepository(revocations),
+		server.WithLoginAttemptRepository(loginAttempts),
+		server.WithMFARepository(mfa),
+		server.WithOneTimeTokenRepository(oneTimeTokens),
+		server.WithSessionRepository(sessions),
+		server.WithServiceAccountRepository(serviceAccounts),
+	}
+
+	lockout := server.DefaultLockoutPolicy
+
+	if v := os.Getenv("LOGIN_MAX_FAILURES"); v != "" {
+		lockout.UserMaxFailures, err = strconv.Atoi(v)
+
+		if err != nil {
+			logger.Fatalf("Invalid LOGIN_MAX_FAILURES %v: %v", v, err)
+		}
+	}
+
+	if v := os.Getenv("LOGIN_LOCKOUT_DURATION"); v != "" {
+		lockout.LockoutDuration, err = time.ParseDuration(v)
+
+		if err != nil {
+			logger.Fatalf("Invalid LOGIN_LOCKOUT_DURATION %v: %v", v, err)
+		}
+	}
+
+	opts = append(opts, server.WithLockoutPolicy(lockout))
+
+	// JWT_KEYS_DIR holds rotating keys, JWT_PRIVATE_KEY_FILE a single key that can still be replaced in place
+	keySource := os.Getenv("JWT_KEYS_DIR")
+	if keySource == "" {
+		keySource = os.Getenv("JWT_PRIVATE_KEY_FILE")
+	}
+
+	if keySource != "" {
+		grace := server.DefaultKeyGracePeriod
+
+		if v := os.Getenv("JWT_KEY_GRACE_PERIOD"); v != "" {
+			grace, err = time.ParseDuration(v)
+
+			if err != nil {
+				logger.Fatalf("Invalid JWT_KEY_GRACE_PERIOD %v: %v", v, err)
+			}
+		}
+
+		keys, err := server.LoadKeyRing(keySource, os.Getenv("JWT_ACTIVE_KEY_ID"), grace)
+
+		if err != nil {
+			logger.Fatalf("Unable to load signing keys: %v", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go keys.Watch(ctx, 30*time.Second, logger)
+
+		opts = append(opts, server.WithKeyRing(keys))
+	}
+
+	if rulesFile := os.Getenv("AUTHZ_RULES_FILE"); rulesFile != "" {
+		rules, err := server.LoadAuthzRules(rulesFile)
+
+		if err != nil {
+			logger.Fatalf("Unable to load authorization rules: %v", err)
+		}
+
+		opts = append(opts, server.WithAuthzRules(rules))
+	}
+
+	cacheSize, cacheTTL := server.DefaultDecisionCacheSize, server.DefaultDecisionCacheTTL
+
+	if v := os.Getenv("AUTHZ_CACHE_SIZE"); v != "" {
+		cacheSize, err = strconv.Atoi(v)
+
+		if err != nil {
+			logger.Fatalf("Invalid AUTHZ_CACHE_SIZE %v: %v", v, err)
+		}
+	}
+
+	if v := os.Getenv("AUTHZ_CACHE_TTL"); v != "" {
+		cacheTTL, err = time.ParseDuration(v)
+
+		if err != nil {
+			logger.Fatalf("Invalid AUTHZ_CACHE_TTL %v: %v", v, err)
+		}
+	}
+
+	opts = append(opts, server.WithDecisionCache(cacheSize, cacheTTL))
+
+	// AUTHZ_SHADOW_MODE logs what the rules would deny without denying it, for rolling out rule changes
+	if os.Getenv("AUTHZ_SHADOW_MODE") != "" {
+		logger.Warnf("Authorization rules run in shadow mode, Check allows every request")
+		opts = append(opts, server.WithShadowMode(true))
+	}
+
+	// AUTHZ_NGINX_AUTH_REQUEST decides requests on AUTHZ_HTTP_PORT by the x-original-method and x-original-uri headers
+	// of nginx auth_request subrequests, only set it when nginx is the only client of that port
+	if os.Getenv("AUTHZ_NGINX_AUTH_REQUEST") != "" {
+		opts = append(opts, server.WithNginxAuthRequest(true))
+	}
+
+	// PUBLIC_METHODS replaces the methods that can be called without a token, as a comma separated list
+	if publicMethods := os.Getenv("PUBLIC_METHODS"); publicMethods != "" {
+		opts = append(opts, server.WithPublicMethods(strings.Split(publicMethods, ",")...))
+	}
+
+	// SMTP_ADDR (host:port) is the relay account emails are sent through, without it they are kept in memory
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mailer, err := mail.NewSMTPMailer(smtpAddr, os.Getenv("MAIL_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+
+		if err != nil {
+			logger.Fatalf("Unable to set up mailer: %v", err)
+		}
+
+		opts = append(opts, server.WithMailer(mailer))
+	} else {
+		logger.Warnf("SMTP_ADDR is not set, account emails like password resets will not be delivered")
+	}
+
+	// APP_URL is the web app the links in account emails point to
+	if appURL := os.Getenv("APP_URL"); appURL != "" {
+		opts = append(opts, server.WithAppURL(appURL))
+	}
+
+	// EMAIL_VERIFICATION is optional (the default) or required, whether users need a verified email to log in
+	if v := os.Getenv("EMAIL_VERIFICATION"); v != "" {
+		policy, err := server.ParseEmailVerificationPolicy(v)
+
+		if err != nil {
+			logger.Fatalf("Invalid EMAIL_VERIFICATION: %v", err)
+		}
+
+		opts = append(opts, server.WithEmailVerificationPolicy(policy))
+	}
+
+	passwordPolicy := password.DefaultPolicy
+
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		passwordPolicy.MinLength, err = strconv.Atoi(v)
+
+		if err != nil {
+			logger.Fatalf("Invalid PASSWORD_MIN_LENGTH %v: %v", v, err)
+		}
+	}
+
+	// PASSWORD_MIN_SCORE is the lowest strength score from 0 to 4 new passwords need
+	if v := os.Getenv("PASSWORD_MIN_SCORE"); v != "" {
+		passwordPolicy.MinScore, err = strconv.Atoi(v)
+
+		if err != nil || passwordPolicy.MinScore < password.ScoreTooGuessable || passwordPolicy.MinScore > password.ScoreVerySecure {
+			logger.Fatalf("Invalid PASSWORD_MIN_SCORE %v, must be 0 to 4", v)
+		}
+	}
+
+	// BREACHED_PASSWORDS_FILE lists the SHA-1 hashes of breached passwords sorted by hash, like the "ordered by hash"
+	// Pwned Passwords download. It is searched on disk, so its size costs startup time to check it but no memory.
+	if breachesFile := os.Getenv("BREACHED_PASSWORDS_FILE"); breachesFile != "" {
+		passwordPolicy.Breaches, err = password.LoadBreachCorpus(breachesFile)
+
+		if err != nil {
+			logger.Fatalf("Unable to load breached passwords: %v", err)
+		}
+
+		logger.Infof("Loaded %v breached password hashes", passwordPolicy.Breaches.Len())
+	}
+
+	opts = append(opts, server.WithPasswordPolicy(passwordPolicy))
+
+	serv, err := server.NewUsersService(repo, logger, opts...)
+
+	if err != nil {
+		logger.Fatalf("Unable to create users service: %v", err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(serv.UnaryAuthenticationInterceptor, serv.UnaryAuthorizationInterceptor),
+		grpc.ChainStreamInterceptor(serv.StreamAuthenticationInterceptor),
+	)
 
 	pbusers.RegisterUsersServer(grpcServer, serv)
 	authv3.RegisterAuthorizationServer(grpcServer, serv)
@@ -69,8 +273,62 @@ func main() {
 
 	defer grpcServer.Stop()
 
+	if httpPort := os.Getenv("HTTP_PORT"); httpPort != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(server.JWKSPath, serv.ServeJWKS)
+
+		httpServer := &http.Server{
+			Addr:    ":" + httpPort,
+			Handler: mux,
+		}
+
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("Failed to serve HTTP: %v", err)
+			}
+		}()
+
+		defer httpServer.Close()
+	}
+
+	// the HTTP flavour of ext_authz gets its own port, every path on it is an authorization check
+	if authzPort := os.Getenv("AUTHZ_HTTP_PORT"); authzPort != "" {
+		authzServer := &http.Server{
+			Addr:    ":" + authzPort,
+			Handler: http.HandlerFunc(serv.ServeAuthz),
+		}
+
+		go func() {
+			if err := authzServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("Failed to serve HTTP authorization: %v", err)
+			}
+		}()
+
+		defer authzServer.Close()
+	}
+
+	// expvar shows the command line, memory stats and cache counters, so it is kept off the public ports and is
+	// only served when ADMIN_HTTP_PORT is set, on a port that must not be exposed outside the cluster
+	if adminPort := os.Getenv("ADMIN_HTTP_PORT"); adminPort != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/debug/vars", expvar.Handler())
+
+		adminServer := &http.Server{
+			Addr:    ":" + adminPort,
+			Handler: adminMux,
+		}
+
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("Failed to serve admin HTTP: %v", err)
+			}
+		}()
+
+		defer adminServer.Close()
+	}
+
 	// the server is listening in a goroutine so hang until we get an interrupt signal
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
 }
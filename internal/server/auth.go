@@ -2,84 +2,235 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
 	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/gogo/googleapis/google/rpc"
-	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/lestrrat-go/jwx/jwt"
-	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/genproto/googleapis/rpc/status"
 
+	"github.com/kic/users/pkg/auth"
 	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/password"
 )
 
 const (
 	authHeader    = "authorization"
-	denyBody      = "Bad credentials"
 	resultHeader  = "x-ext-authz-check-result"
 	resultAllowed = "allowed"
+	reasonHeader  = "x-ext-authz-reason"
+
+	// identity of the verified caller, forwarded to upstream services by Check
+	userIDHeader   = "x-kic-user-id"
+	usernameHeader = "x-kic-username"
+	rolesHeader    = "x-kic-roles"
+	// name of the service account making the request, set instead of the user ID and username
+	serviceAccountHeader = "x-kic-service-account"
 )
 
+// dummyPasswordHash - compared against when the user does not exist, hashed by the same hasher as new passwords
+// so the check takes as long. Created on first use since hashing is deliberately slow.
+type dummyPasswordHash struct {
+	once sync.Once
+	hash string
+}
+
+func (d *dummyPasswordHash) get(hasher password.Hasher) string {
+	d.once.Do(func() {
+		hash, err := hasher.Hash("not the password of anyone")
+		if err == nil {
+			d.hash = hash
+		}
+	})
+	return d.hash
+}
+
+// Errors Check tells clients about in the error field of its deny body
+const (
+	denyTokenMissing = "token_missing"
+	denyTokenInvalid = "token_invalid"
+	denyTokenExpired = "token_expired"
+	denyForbidden    = "forbidden"
+)
+
+var (
+	errTokenRevoked = errors.New("token has been revoked")
+	errTokenExpired = errors.New("token has expired")
+	errTokenMissing = errors.New("no token sent")
+)
+
+// DecodeJWT - verify a JWT issued to a user, service account JWTs are rejected since they have no user
 func (s *UsersService) DecodeJWT(payload string) (jwt.Token, error) {
+	token, err := s.verifyJWT(payload)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if isServiceAccountToken(token) {
+		return nil, errors.New("token was issued to a service account")
+	}
+
+	if err := s.checkRevocation(context.TODO(), token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// verifyJWT - check the signature and registered claims of a JWT, but not whether it was revoked
+func (s *UsersService) verifyJWT(payload string) (jwt.Token, error) {
+	key, err := s.keys.verifierFor([]byte(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
 	token, err := jwt.Parse(
 		[]byte(payload),
-		jwt.WithKeySet(s.keyset),
-		jwt.UseDefaultKey(true),
+		jwt.WithVerify(key.alg, key.verify),
 	)
 
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.validateClaims(token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *UsersService) GenerateJWT(userID int64, username string, roles []string) (string, error) {
+	token, _, err := s.generateSessionJWT(context.Background(), userID, username, roles, "", time.Now())
 	return token, err
 }
 
-func (s *UsersService) GenerateJWT(userID int64) (string, error) {
+// generateSessionJWT - a JWT for a session of the user and its jti, authTime is when the user last logged in to
+// the session
+func (s *UsersService) generateSessionJWT(ctx context.Context, userID int64, username string, roles []string, sessionID string, authTime time.Time) (string, string, error) {
+	tokenID, err := newOpaqueToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	issuedAt, err := s.issueTime(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+
 	t := jwt.New()
-	err := t.Set(jwt.ExpirationKey, time.Now().Add(time.Hour))
+	err = s.setStandardClaims(t, userID, tokenID, issuedAt)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	err = t.Set("uid", strconv.FormatInt(userID, 10))
+	err = t.Set(usernameClaim, username)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	key, _ := s.keyset.Get(0)
+	err = t.Set(rolesClaim, roles)
+	if err != nil {
+		return "", "", err
+	}
 
-	signed, err := jwt.Sign(t, jwa.HS256, key)
+	err = setSessionClaims(t, sessionID, authTime)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := s.keys.signer()
+
+	signed, err := jwt.Sign(t, key.alg, key.private)
 
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return string(signed), nil
+	return string(signed), tokenID, nil
 }
 
-func (s *UsersService) ValidateUser(username, password string) (bool, error) {
+func (s *UsersService) ValidateUser(username, pass string) (bool, error) {
 	res, err := s.db.GetUser(context.TODO(), &database.UserModel{
 		Username: username,
 	})
 
 	if err != nil {
 		s.logger.Debugf("Failed to get user from db to validate: %v", err)
+		// spend as long as checking a real password, so the time taken does not tell which usernames exist
+		s.hasher.Verify(pass, s.dummyHash.get(s.hasher))
 		return false, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(res.Password), []byte(password))
+	ok, needsRehash, err := s.hasher.Verify(pass, res.Password)
 
-	if err != nil {
+	if err != nil || !ok {
 		s.logger.Debugf("Failed to compare passwords: %v", err)
 		return false, nil
 	}
+
+	if needsRehash {
+		s.rehashPassword(res, pass)
+	}
+
 	s.logger.Debugf("User is valid, returning")
 	return true, nil
 }
 
+// rehashPassword - replace the stored hash of a user that was made with an outdated algorithm or cost, while the
+// password is known after a successful login. The login goes ahead if this fails, the next one tries again.
+func (s *UsersService) rehashPassword(usr *database.UserModel, pass string) {
+	hash, err := s.hasher.Hash(pass)
+
+	if err != nil {
+		s.logger.Errorf("Failed to rehash password of user %v: %v", usr.ID, err)
+		return
+	}
+
+	model := &database.UserModel{Password: hash}
+	model.ID = usr.ID
+
+	err = s.db.UpdateUserInfo(context.TODO(), model)
+
+	if err != nil {
+		s.logger.Errorf("Failed to save rehashed password of user %v: %v", usr.ID, err)
+		return
+	}
+
+	s.logger.Infof("Upgraded the password hash of user %v", usr.ID)
+}
+
+// userIDFromToken - get the ID of the user a token was issued to
+func userIDFromToken(tok jwt.Token) (int64, error) {
+	if sub := tok.Subject(); sub != "" {
+		return strconv.ParseInt(sub, 10, 64)
+	}
+
+	// legacy tokens only have the uid claim
+	strID, ok := tok.Get("uid")
+	if !ok {
+		return 0, errors.New("token has no uid claim")
+	}
+
+	str, ok := strID.(string)
+	if !ok {
+		return 0, errors.New("token uid claim is not a string")
+	}
+
+	return strconv.ParseInt(str, 10, 64)
+}
+
 func parseCredentialsFromHeader(header string) (string, error) {
 	splitToken := strings.Split(header, "Bearer")
 	if len(splitToken) != 2 {
@@ -91,58 +242,278 @@ func parseCredentialsFromHeader(header string) (string, error) {
 	return reqToken, nil
 }
 
+// replaceHeader - set a header on the upstream request, replacing any value sent by the client
+func replaceHeader(key, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header: &corev3.HeaderValue{
+			Key:   key,
+			Value: value,
+		},
+		Append: &wrappers.BoolValue{Value: false},
+	}
+}
+
+// identityResponse - forward the verified caller upstream so services do not have to decode the token again.
+// Every identity header is either replaced or removed, clients cannot pass their own values through.
+func identityResponse(caller *auth.Principal) *authv3.OkHttpResponse {
+	if caller == nil {
+		// anonymous request to a public path
+		return &authv3.OkHttpResponse{
+			Headers: []*corev3.HeaderValueOption{
+				replaceHeader(resultHeader, resultAllowed),
+			},
+			HeadersToRemove: []string{userIDHeader, usernameHeader, rolesHeader, serviceAccountHeader},
+		}
+	}
+
+	if caller.IsServiceAccount() {
+		return &authv3.OkHttpResponse{
+			Headers: []*corev3.HeaderValueOption{
+				replaceHeader(resultHeader, resultAllowed),
+				replaceHeader(serviceAccountHeader, caller.Username),
+				replaceHeader(rolesHeader, strings.Join(caller.Roles, ",")),
+			},
+			HeadersToRemove: []string{userIDHeader, usernameHeader},
+		}
+	}
+
+	resp := &authv3.OkHttpResponse{
+		Headers: []*corev3.HeaderValueOption{
+			replaceHeader(resultHeader, resultAllowed),
+			replaceHeader(userIDHeader, strconv.FormatInt(caller.UserID, 10)),
+			replaceHeader(rolesHeader, strings.Join(caller.Roles, ",")),
+		},
+		HeadersToRemove: []string{serviceAccountHeader},
+	}
+
+	// legacy tokens do not carry a username
+	if caller.Username != "" {
+		resp.Headers = append(resp.Headers, replaceHeader(usernameHeader, caller.Username))
+	} else {
+		resp.HeadersToRemove = append(resp.HeadersToRemove, usernameHeader)
+	}
+
+	return resp
+}
+
+// checkDecision - whether Check allows a request, why, and who made it if they sent a valid token
+type checkDecision struct {
+	allowed bool
+	reason  string
+	caller  *auth.Principal
+	// one of the deny constants when the request is denied
+	denial string
+}
+
+// denialFor - the deny error for a request whose token could not be verified
+func denialFor(err error) string {
+	switch {
+	case errors.Is(err, errTokenMissing):
+		return denyTokenMissing
+	case errors.Is(err, errTokenExpired):
+		return denyTokenExpired
+	}
+	return denyTokenInvalid
+}
+
+// callerFromHeader - the caller identified by the token in an authorization header
+func (s *UsersService) callerFromHeader(header string) (*auth.Principal, error) {
+	if header == "" {
+		return nil, errTokenMissing
+	}
+
+	credential, err := parseCredentialsFromHeader(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.principalFromCredential(context.TODO(), credential)
+}
+
+// decisionKey - decisions depend on the token, the rule and the path parameters the rule looks at
+func decisionKey(header string, rule *AuthzRule, params map[string]string) string {
+	if rule == nil {
+		return hashToken(header)
+	}
+
+	key := fmt.Sprintf("%v|%v", hashToken(header), rule.index)
+	if rule.Require == RequireOwner {
+		key += "|" + params[rule.Param]
+	}
+	return key
+}
+
+// decide - check a request against the first matching authorization rule, requests no rule matches need any
+// valid token. Decisions about verified tokens are cached, but whether the token was revoked since is checked on
+// every request.
+func (s *UsersService) decide(host, method, path, header string) checkDecision {
+	rule, params := s.authzRules.find(host, method, path)
+
+	key := decisionKey(header, rule, params)
+
+	decision, ok := s.decisions.get(key)
+
+	// the revocation may have gone through another replica, whose purge did not reach this cache
+	if ok && decision.caller != nil {
+		if err := s.checkCallerRevocation(context.TODO(), decision.caller); err != nil {
+			s.decisions.forget(key)
+			ok = false
+		}
+	}
+
+	if !ok {
+		decision = s.evaluate(rule, params, header)
+
+		s.decisions.add(key, decision)
+	}
+
+	// decisions are shared by every path a rule matches, while scopes name single methods
+	return scopeDecision(decision, rule, path)
+}
+
+// scopeDecision - deny requests of service accounts to paths outside of their scopes, unless the path is public
+func scopeDecision(decision checkDecision, rule *AuthzRule, path string) checkDecision {
+	if !decision.allowed || decision.caller == nil || !decision.caller.IsServiceAccount() {
+		return decision
+	}
+
+	if rule != nil && rule.Require == RequirePublic {
+		return decision
+	}
+
+	if i := strings.IndexAny(path, "?#"); i != -1 {
+		path = path[:i]
+	}
+
+	if decision.caller.HasScope(path) {
+		return decision
+	}
+
+	return checkDecision{
+		allowed: false,
+		reason:  fmt.Sprintf("service account %v has no scope for %v", decision.caller.Username, path),
+		caller:  decision.caller,
+		denial:  denyForbidden,
+	}
+}
+
+// evaluate - verify the token and check the caller against the rule
+func (s *UsersService) evaluate(rule *AuthzRule, params map[string]string, header string) checkDecision {
+	caller, err := s.callerFromHeader(header)
+
+	if rule != nil && rule.Require == RequirePublic {
+		// identify callers that sent a valid token anyway
+		return checkDecision{allowed: true, reason: fmt.Sprintf("rule %v: public", rule.Path), caller: caller}
+	}
+
+	if err != nil {
+		return checkDecision{allowed: false, reason: fmt.Sprintf("invalid credentials: %v", err), denial: denialFor(err)}
+	}
+
+	if rule == nil {
+		return checkDecision{allowed: true, reason: "authenticated", caller: caller}
+	}
+
+	allowed, reason := rule.allows(caller, params)
+
+	decision := checkDecision{allowed: allowed, reason: fmt.Sprintf("rule %v: %v", rule.Path, reason), caller: caller}
+	if !allowed {
+		decision.denial = denyForbidden
+	}
+
+	return decision
+}
+
+// denyMessages - what the deny body tells clients, the reason of a denial is only logged since it can tell why a
+// forged token failed verification or which rule a request broke
+var denyMessages = map[string]string{
+	denyTokenMissing: "Send a token along with the request",
+	denyTokenInvalid: "The token is invalid",
+	denyTokenExpired: "The token has expired",
+	denyForbidden:    "The request is not allowed",
+}
+
+// denyError - the JSON body of denied requests, the web frontend refreshes expired tokens and logs out on invalid ones
+type denyError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// deniedResponse - 401 with a WWW-Authenticate challenge when the token is missing or bad, 403 when the caller
+// is known but not allowed
+func deniedResponse(decision checkDecision) *authv3.DeniedHttpResponse {
+	message, ok := denyMessages[decision.denial]
+	if !ok {
+		message = "The request was denied"
+	}
+
+	body, _ := json.Marshal(denyError{Error: decision.denial, Message: message})
+
+	resp := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode_Unauthorized},
+		Body:   string(body),
+		Headers: []*corev3.HeaderValueOption{
+			replaceHeader("content-type", "application/json"),
+			replaceHeader(reasonHeader, decision.denial),
+		},
+	}
+
+	switch decision.denial {
+	case denyForbidden:
+		resp.Status.Code = typev3.StatusCode_Forbidden
+	case denyTokenMissing:
+		// RFC 6750 leaves out the error when no credentials were sent
+		resp.Headers = append(resp.Headers, replaceHeader("www-authenticate", `Bearer realm="kic"`))
+	default:
+		resp.Headers = append(resp.Headers, replaceHeader("www-authenticate",
+			fmt.Sprintf(`Bearer realm="kic", error="invalid_token", error_description="%v"`, message)))
+	}
+
+	return resp
+}
+
 // Check implements gRPC v3 check request.
 func (s *UsersService) Check(ctx context.Context, request *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := request.GetAttributes().GetRequest().GetHttp()
+
 	l := fmt.Sprintf("%s%s, attributes: %v\n",
-		request.GetAttributes().GetRequest().GetHttp().GetHost(),
-		request.GetAttributes().GetRequest().GetHttp().GetPath(),
+		httpReq.GetHost(),
+		httpReq.GetPath(),
 		request.GetAttributes())
 
-	header := request.GetAttributes().GetRequest().GetHttp().GetHeaders()[authHeader]
+	decision := s.decide(httpReq.GetHost(), httpReq.GetMethod(), httpReq.GetPath(), httpReq.GetHeaders()[authHeader])
 
-	approve := true
+	if !decision.allowed && s.shadowMode {
+		s.logger.Infof("[gRPCv3][shadow] would deny %v: %s", decision.reason, l)
+		decision = checkDecision{allowed: true, reason: "shadow mode, would deny: " + decision.reason, caller: decision.caller}
+	}
 
-	tok, err := parseCredentialsFromHeader(header)
+	if decision.allowed {
+		s.logger.Infof("[gRPCv3][allowed] %v: %s", decision.reason, l)
 
-	if err != nil {
-		approve = false
-	} else {
-		_, err := s.DecodeJWT(tok)
-		if err != nil {
-			approve = false
-		}
-	}
+		resp := identityResponse(decision.caller)
+		resp.Headers = append(resp.Headers, replaceHeader(reasonHeader, decision.reason))
 
-	if approve {
-		s.logger.Infof("[gRPCv3][allowed]: %s", l)
 		return &authv3.CheckResponse{
 			HttpResponse: &authv3.CheckResponse_OkResponse{
-				OkResponse: &authv3.OkHttpResponse{
-					Headers: []*corev3.HeaderValueOption{
-						{
-							Header: &corev3.HeaderValue{
-								Key:   resultHeader,
-								Value: resultAllowed,
-							},
-						},
-					},
-				},
+				OkResponse: resp,
 			},
-			Status: &status.Status{Code: int32(rpc.OK)},
+			Status: &status.Status{Code: int32(rpc.OK), Message: decision.reason},
 		}, nil
 	}
 
-	s.logger.Infof("[gRPCv3][denied]: %s", l)
+	s.logger.Infof("[gRPCv3][denied] %v: %s", decision.reason, l)
+
+	code := rpc.UNAUTHENTICATED
+	if decision.denial == denyForbidden {
+		code = rpc.PERMISSION_DENIED
+	}
+
 	return &authv3.CheckResponse{
 		HttpResponse: &authv3.CheckResponse_DeniedResponse{
-			DeniedResponse: &authv3.DeniedHttpResponse{
-				Status: &typev3.HttpStatus{Code: typev3.StatusCode_Forbidden},
-				Body:   denyBody,
-				Headers: []*corev3.HeaderValueOption{
-					{},
-				},
-			},
+			DeniedResponse: deniedResponse(decision),
 		},
-		Status: &status.Status{Code: int32(rpc.PERMISSION_DENIED)},
+		Status: &status.Status{Code: int32(code), Message: decision.reason},
 	}, nil
 }
@@ -2,11 +2,17 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/gogo/googleapis/google/rpc"
 	pbusers "github.com/kic/users/pkg/proto/users"
+	"github.com/lestrrat-go/jwx/jwt"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,15 +20,66 @@ import (
 
 	"github.com/kic/users/pkg/database"
 	"github.com/kic/users/pkg/logging"
+	"github.com/kic/users/pkg/password"
 )
 
 var service *UsersService
 
+// testArgon2idParams - cheap enough that tests can hash freely, the default parameters take around 50ms a hash
+var testArgon2idParams = password.Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+var testHasher = password.NewArgon2idHasher(testArgon2idParams)
+
+// testClock - a clock tests move forward instead of sleeping through backoffs and expiries
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Now()}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestService - a service on the users and signing keys of service with the test hasher and no password policy,
+// like service itself. opts are applied after these, so they can replace them.
+func newTestService(t *testing.T, opts ...ServiceOption) *UsersService {
+	defaults := []ServiceOption{
+		WithKeyRing(service.keys),
+		WithPasswordHasher(testHasher),
+		WithPasswordPolicy(password.Policy{}),
+		WithAppURL("https://app.example.com/"),
+	}
+
+	s, err := NewUsersService(service.db, service.logger, append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("Failed to create users service: %v", err)
+	}
+	return s
+}
+
 func TestMain(m *testing.M) {
 	logger := logging.CreateLogger(zapcore.DebugLevel)
-	os.Setenv("SECRET_KEY", "supersecret")
+	os.Setenv("SECRET_KEY", "test-3q8ZkV1xNf6rTb0Yw2HsLm9CjDp5GaUe")
 
-	pass, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	pass, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
 
 	seedData := map[uint]*database.UserModel{
 		0: {
@@ -47,11 +104,29 @@ func TestMain(m *testing.M) {
 			City:     "",
 			Bio:      "",
 		},
+		2: {
+			Model: gorm.Model{
+				ID: 2,
+			},
+			Email:    "admin@gmail.com",
+			Username: "admin",
+			Password: string(pass),
+			Birthday: time.Time{},
+			City:     "",
+			Bio:      "",
+			Roles:    database.RoleAdmin,
+		},
 	}
 
 	repo := database.NewMockRepository(seedData, logger)
 
-	service = NewUsersService(repo, logger)
+	var err error
+	// fixtures use short passwords like "password", the policy itself is tested in passwordpolicy_test.go
+	service, err = NewUsersService(repo, logger, WithPasswordHasher(testHasher), WithPasswordPolicy(password.Policy{}))
+
+	if err != nil {
+		logger.Fatalf("Failed to create users service: %v", err)
+	}
 
 	os.Exit(m.Run())
 }
@@ -112,3 +187,176 @@ func Test_ShouldFailAuthentication(t *testing.T) {
 		t.Errorf("Got OkResponse with improper credentials")
 	}
 }
+
+// upstreamHeaders - the headers an allowed check response sets on the upstream request
+func upstreamHeaders(t *testing.T, check *authv3.CheckResponse) map[string]string {
+	ok := check.GetOkResponse()
+	if ok == nil {
+		t.Fatalf("Did not get OkResponse with proper credentials")
+	}
+
+	headers := map[string]string{}
+	for _, header := range ok.GetHeaders() {
+		if header.GetAppend().GetValue() {
+			t.Errorf("Header %v is appended to client supplied values", header.GetHeader().GetKey())
+		}
+		headers[header.GetHeader().GetKey()] = header.GetHeader().GetValue()
+	}
+	return headers
+}
+
+func Test_ShouldForwardIdentityHeaders(t *testing.T) {
+	token, _ := service.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "admin",
+		Password: "password",
+	})
+
+	check, _ := service.Check(context.Background(), &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{
+						authHeader:     "Bearer " + token.GetToken(),
+						userIDHeader:   "0",
+						usernameHeader: "qdn123",
+						rolesHeader:    "user",
+					},
+				},
+			},
+		},
+	})
+
+	headers := upstreamHeaders(t, check)
+
+	if headers[resultHeader] != resultAllowed {
+		t.Errorf("Expected %v header to be %v, got %v", resultHeader, resultAllowed, headers[resultHeader])
+	}
+	if headers[userIDHeader] != "2" {
+		t.Errorf("Expected %v header to be 2, got %v", userIDHeader, headers[userIDHeader])
+	}
+	if headers[usernameHeader] != "admin" {
+		t.Errorf("Expected %v header to be admin, got %v", usernameHeader, headers[usernameHeader])
+	}
+	if headers[rolesHeader] != database.RoleAdmin {
+		t.Errorf("Expected %v header to be %v, got %v", rolesHeader, database.RoleAdmin, headers[rolesHeader])
+	}
+}
+
+func Test_ShouldRemoveUsernameHeaderForTokenWithoutUsername(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {})
+
+	check := checkToken(token)
+	headers := upstreamHeaders(t, check)
+
+	if _, ok := headers[usernameHeader]; ok {
+		t.Errorf("Set %v header for a token without a username", usernameHeader)
+	}
+
+	removed := false
+	for _, header := range check.GetOkResponse().GetHeadersToRemove() {
+		removed = removed || header == usernameHeader
+	}
+	if !removed {
+		t.Errorf("Client supplied %v header is not removed", usernameHeader)
+	}
+}
+
+// deniedWith - the status, deny body and headers of a denied check
+func deniedWith(t *testing.T, check *authv3.CheckResponse) (typev3.StatusCode, denyError, map[string]string) {
+	denied := check.GetDeniedResponse()
+	if denied == nil {
+		t.Fatalf("Request was not denied")
+	}
+
+	var body denyError
+	if err := json.Unmarshal([]byte(denied.GetBody()), &body); err != nil {
+		t.Errorf("Deny body %q is not JSON: %v", denied.GetBody(), err)
+	}
+
+	headers := map[string]string{}
+	for _, header := range denied.GetHeaders() {
+		if header.GetHeader().GetKey() == "" {
+			t.Errorf("Deny response has an empty header")
+		}
+		headers[header.GetHeader().GetKey()] = header.GetHeader().GetValue()
+	}
+
+	return denied.GetStatus().GetCode(), body, headers
+}
+
+func Test_ShouldDenyMissingTokenWith401(t *testing.T) {
+	check, _ := service.Check(context.Background(), &authv3.CheckRequest{})
+
+	code, body, headers := deniedWith(t, check)
+
+	if code != typev3.StatusCode_Unauthorized || body.Error != denyTokenMissing {
+		t.Errorf("Expected 401 %v, got %v %v", denyTokenMissing, code, body.Error)
+	}
+	if headers["www-authenticate"] != `Bearer realm="kic"` {
+		t.Errorf("Unexpected WWW-Authenticate %q", headers["www-authenticate"])
+	}
+	if check.GetStatus().GetCode() != int32(rpc.UNAUTHENTICATED) {
+		t.Errorf("Expected UNAUTHENTICATED status, got %v", check.GetStatus().GetCode())
+	}
+}
+
+func Test_ShouldDenyInvalidTokenWith401(t *testing.T) {
+	code, body, headers := deniedWith(t, checkToken("sdfasdfsdfasdf"))
+
+	if code != typev3.StatusCode_Unauthorized || body.Error != denyTokenInvalid {
+		t.Errorf("Expected 401 %v, got %v %v", denyTokenInvalid, code, body.Error)
+	}
+	if !strings.Contains(headers["www-authenticate"], `error="invalid_token"`) {
+		t.Errorf("Unexpected WWW-Authenticate %q", headers["www-authenticate"])
+	}
+}
+
+func Test_ShouldNotTellClientsWhyTokenIsInvalid(t *testing.T) {
+	// the signature fails to verify, which is only for the logs
+	token := signClaims(t, func(jwt.Token) {})
+	forged := token[:strings.LastIndex(token, ".")+1] + "c2lnbmF0dXJl"
+
+	_, body, headers := deniedWith(t, checkToken(forged))
+
+	if headers[reasonHeader] != denyTokenInvalid || body.Message != denyMessages[denyTokenInvalid] {
+		t.Errorf("Deny response tells more than that the token is invalid: %q, %q", headers[reasonHeader], body.Message)
+	}
+}
+
+func Test_ShouldDenyExpiredTokenWith401(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Set(jwt.ExpirationKey, time.Now().Add(-time.Hour))
+	})
+
+	code, body, headers := deniedWith(t, checkToken(token))
+
+	if code != typev3.StatusCode_Unauthorized || body.Error != denyTokenExpired {
+		t.Errorf("Expected 401 %v, got %v %v", denyTokenExpired, code, body.Error)
+	}
+	if !strings.Contains(headers["www-authenticate"], "expired") {
+		t.Errorf("Unexpected WWW-Authenticate %q", headers["www-authenticate"])
+	}
+}
+
+func Test_ShouldDenyUnauthorizedCallerWith403(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+	addTestUser(t, "forbidden")
+	login := loginTestUser(t, "forbidden")
+
+	check := checkRequest(s, "", "GET", "/admin", login.Token)
+
+	code, body, headers := deniedWith(t, check)
+
+	if code != typev3.StatusCode_Forbidden || body.Error != denyForbidden {
+		t.Errorf("Expected 403 %v, got %v %v", denyForbidden, code, body.Error)
+	}
+	if _, ok := headers["www-authenticate"]; ok {
+		t.Errorf("Asked an authenticated caller to authenticate")
+	}
+	if headers[reasonHeader] != denyForbidden || body.Message != denyMessages[denyForbidden] {
+		t.Errorf("Deny response tells which rule was broken: %q, %q", headers[reasonHeader], body.Message)
+	}
+	if check.GetStatus().GetCode() != int32(rpc.PERMISSION_DENIED) {
+		t.Errorf("Expected PERMISSION_DENIED status, got %v", check.GetStatus().GetCode())
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+)
+
+// DefaultPublicMethods - methods that can be called without a token unless WithPublicMethods is used
+var DefaultPublicMethods = []string{
+	"/kic.users.Users/GetJWTToken",
+	"/kic.users.Users/AddUser",
+	"/kic.users.Users/RefreshJWTToken",
+	"/kic.users.Users/GetJWKS",
+	// the challenge token stands in for the password, there is no JWT yet
+	"/kic.users.Users/CompleteMFAChallenge",
+	// users who forgot their password cannot get a JWT, the emailed reset token proves who they are
+	"/kic.users.Users/RequestPasswordReset",
+	"/kic.users.Users/CompletePasswordReset",
+	// unverified users may not be able to log in until they verify
+	"/kic.users.Users/VerifyEmail",
+	"/kic.users.Users/ResendVerificationEmail",
+	// passwordless login, the emailed code stands in for the password
+	"/kic.users.Users/RequestLoginCode",
+	"/kic.users.Users/LoginWithCode",
+	// the API key of the service account stands in for the password
+	"/kic.users.Users/GetServiceAccountToken",
+	// envoy authenticates itself through the mesh, the token it checks is in the request body
+	"/envoy.service.auth.v3.Authorization/Check",
+}
+
+// principalFromToken - the caller a verified token was issued to
+func principalFromToken(tok jwt.Token) (*auth.Principal, error) {
+	userID, err := userIDFromToken(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens issued before usernames were added to them leave it empty
+	username, _ := tok.Get(usernameClaim)
+	name, _ := username.(string)
+
+	sessionID, authTime := sessionFromToken(tok)
+
+	return &auth.Principal{
+		UserID:    userID,
+		Username:  name,
+		Roles:     rolesFromToken(tok),
+		TokenID:   tok.JwtID(),
+		IssuedAt:  tok.IssuedAt(),
+		ExpiresAt: tok.Expiration(),
+		SessionID: sessionID,
+		AuthTime:  authTime,
+	}, nil
+}
+
+// principalFromCredential - the caller identified by a bearer credential, either a user JWT, a service account
+// JWT or a service account API key
+func (s *UsersService) principalFromCredential(ctx context.Context, credential string) (*auth.Principal, error) {
+	if isServiceAccountKey(credential) {
+		account, _, err := s.serviceAccountForKey(ctx, credential)
+		if err != nil {
+			return nil, err
+		}
+		return serviceAccountPrincipal(account), nil
+	}
+
+	tok, err := s.verifyJWT(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	if isServiceAccountToken(tok) {
+		return s.serviceAccountFromToken(ctx, tok)
+	}
+
+	if err := s.checkRevocation(ctx, tok); err != nil {
+		return nil, err
+	}
+
+	return principalFromToken(tok)
+}
+
+// authenticate - verify the credential in the authorization header and attach its principal to the context
+func (s *UsersService) authenticate(ctx context.Context) (context.Context, error) {
+	headers, ok := metadata.FromIncomingContext(ctx)
+
+	if !ok || len(headers[authHeader]) == 0 {
+		return ctx, errors.New("no authorization header")
+	}
+
+	tokString, err := parseCredentialsFromHeader(headers[authHeader][0])
+
+	if err != nil {
+		return ctx, err
+	}
+
+	principal, err := s.principalFromCredential(ctx, tokString)
+
+	if err != nil {
+		return ctx, err
+	}
+
+	return auth.NewContext(ctx, principal), nil
+}
+
+// authenticateMethod - authenticate calls to every method that is not public
+func (s *UsersService) authenticateMethod(ctx context.Context, method string) (context.Context, error) {
+	if s.publicMethods[method] {
+		return ctx, nil
+	}
+
+	ctx, err := s.authenticate(ctx)
+
+	if err != nil {
+		s.logger.Debugf("Failed to authenticate call to %v: %v", method, err)
+		return ctx, status.Errorf(codes.Unauthenticated, "Send a valid token along with request")
+	}
+
+	return ctx, nil
+}
+
+// callerFromContext - the user attached by the authentication interceptor. Service accounts are refused, the
+// handlers asking for the caller act on the caller's own account and service accounts have none.
+func callerFromContext(ctx context.Context) (*auth.Principal, error) {
+	principal, ok := auth.FromContext(ctx)
+
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "Send a valid token along with request")
+	}
+
+	if principal.IsServiceAccount() {
+		return nil, status.Errorf(codes.PermissionDenied, "Service accounts cannot do this")
+	}
+
+	return principal, nil
+}
+
+// UnaryAuthenticationInterceptor - attach the caller of non-public unary methods to the context
+func (s *UsersService) UnaryAuthenticationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticateMethod(ctx, info.FullMethod)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// authenticatedStream - a server stream whose context carries the caller
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// StreamAuthenticationInterceptor - attach the caller of non-public streaming methods to the context
+func (s *UsersService) StreamAuthenticationInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticateMethod(ss.Context(), info.FullMethod)
+
+	if err != nil {
+		return err
+	}
+
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
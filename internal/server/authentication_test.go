@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kic/users/pkg/auth"
+	pbusers "github.com/kic/users/pkg/proto/users"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (t *testServerStream) Context() context.Context {
+	return t.ctx
+}
+
+func authenticateCall(s *UsersService, ctx context.Context, method string) (*auth.Principal, error) {
+	var caller *auth.Principal
+	_, err := s.UnaryAuthenticationInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			caller, _ = auth.FromContext(ctx)
+			return nil, nil
+		})
+	return caller, err
+}
+
+func Test_ShouldAttachPrincipalToContext(t *testing.T) {
+	id := addTestUser(t, "principal")
+	login := loginTestUser(t, "principal")
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authHeader, "Bearer "+login.Token))
+
+	caller, err := authenticateCall(service, ctx, "/kic.users.Users/GetUserByID")
+	if err != nil {
+		t.Fatalf("Failed to authenticate valid token: %v", err)
+	}
+	if caller == nil || caller.UserID != id || caller.TokenID == "" {
+		t.Errorf("Unexpected principal %v for user %v", caller, id)
+	}
+}
+
+func Test_ShouldRejectCallWithoutAuthorizationHeader(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-other", "value"))
+
+	_, err := authenticateCall(service, ctx, "/kic.users.Users/DeleteUserByID")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Call without authorization header was not rejected: %v", err)
+	}
+}
+
+func Test_ShouldRejectCallWithInvalidToken(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authHeader, "Bearer not-a-token"))
+
+	_, err := authenticateCall(service, ctx, "/kic.users.Users/GetUserByID")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Call with invalid token was not rejected: %v", err)
+	}
+}
+
+func Test_ShouldNotPanicDeletingWithoutAuthorizationHeader(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-other", "value"))
+
+	_, err := service.DeleteUserByID(ctx, &pbusers.DeleteUserByIDRequest{
+		UserID: 0,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Deleted user without a token: %v", err)
+	}
+}
+
+func Test_ShouldSkipAuthenticationForPublicMethods(t *testing.T) {
+	caller, err := authenticateCall(service, context.Background(), "/kic.users.Users/GetJWTToken")
+	if err != nil || caller != nil {
+		t.Errorf("Public method was authenticated: %v, %v", caller, err)
+	}
+}
+
+func Test_ShouldConfigurePublicMethods(t *testing.T) {
+	s := newTestService(t, WithPublicMethods("/kic.users.Users/GetUserByID"))
+
+	if _, err := authenticateCall(s, context.Background(), "/kic.users.Users/GetUserByID"); err != nil {
+		t.Errorf("Configured public method required a token: %v", err)
+	}
+
+	_, err := authenticateCall(s, context.Background(), "/kic.users.Users/GetJWTToken")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Method no longer configured as public did not require a token: %v", err)
+	}
+}
+
+func Test_ShouldAttachPrincipalToStreamContext(t *testing.T) {
+	id := addTestUser(t, "streamer")
+	login := loginTestUser(t, "streamer")
+
+	stream := &testServerStream{
+		ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(authHeader, "Bearer "+login.Token)),
+	}
+
+	var caller *auth.Principal
+	err := service.StreamAuthenticationInterceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/kic.users.Users/Stream"},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			caller, _ = auth.FromContext(ss.Context())
+			return nil
+		})
+	if err != nil || caller == nil || caller.UserID != id {
+		t.Errorf("Stream context does not carry the caller: %v, %v", caller, err)
+	}
+
+	stream.ctx = context.Background()
+	err = service.StreamAuthenticationInterceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/kic.users.Users/Stream"},
+		func(srv interface{}, ss grpc.ServerStream) error {
+			return nil
+		})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Stream without token was not rejected: %v", err)
+	}
+}
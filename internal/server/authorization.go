@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	rolesClaim    = "roles"
+	usernameClaim = "username"
+)
+
+// methodPolicy - who may call a gRPC method
+type methodPolicy struct {
+	// callers need at least one of these roles, any authenticated caller when empty
+	roles []string
+}
+
+// methodPolicies - the policy of every method served, methods missing from the table are denied
+var methodPolicies = map[string]methodPolicy{
+	"/kic.users.Users/GetJWTToken":       {},
+	"/kic.users.Users/AddUser":           {},
+	"/kic.users.Users/RefreshJWTToken":   {},
+	"/kic.users.Users/GetJWKS":           {},
+	"/kic.users.Users/GetUserByUsername": {},
+	"/kic.users.Users/GetUserByID":       {},
+	"/kic.users.Users/GetUserNameByID":   {},
+	"/kic.users.Users/Logout":            {},
+	"/kic.users.Users/LogoutEverywhere":  {},
+	"/kic.users.Users/EnrollTOTP":        {},
+	"/kic.users.Users/VerifyTOTP":        {},
+	"/kic.users.Users/DisableTOTP":       {},
+	// changes the password of the caller, who needs no further ownership check
+	"/kic.users.Users/ChangePassword": {},
+	// only list and revoke sessions of the caller
+	"/kic.users.Users/ListSessions":  {},
+	"/kic.users.Users/RevokeSession": {},
+	// public, called with an MFA challenge token or a token or code from an email instead of a JWT
+	"/kic.users.Users/CompleteMFAChallenge":    {},
+	"/kic.users.Users/RequestPasswordReset":    {},
+	"/kic.users.Users/CompletePasswordReset":   {},
+	"/kic.users.Users/VerifyEmail":             {},
+	"/kic.users.Users/ResendVerificationEmail": {},
+	"/kic.users.Users/RequestLoginCode":        {},
+	"/kic.users.Users/LoginWithCode":           {},
+	"/kic.users.Users/GetServiceAccountToken":  {},
+	// ownership is checked by the handlers with authorizeUser, admins may act on any account
+	"/kic.users.Users/DeleteUserByID": {},
+	"/kic.users.Users/UpdateUserInfo": {},
+	"/kic.users.Users/UpdateUserRoles": {
+		roles: []string{database.RoleAdmin},
+	},
+	"/kic.users.Users/UnlockAccount": {
+		roles: []string{database.RoleAdmin},
+	},
+	"/kic.users.Users/CreateServiceAccount": {
+		roles: []string{database.RoleAdmin},
+	},
+	"/kic.users.Users/ListServiceAccounts": {
+		roles: []string{database.RoleAdmin},
+	},
+	"/kic.users.Users/RotateServiceAccountKey": {
+		roles: []string{database.RoleAdmin},
+	},
+	"/kic.users.Users/RevokeServiceAccount": {
+		roles: []string{database.RoleAdmin},
+	},
+	"/envoy.service.auth.v3.Authorization/Check": {},
+}
+
+// rolesFromToken - get the roles a token was issued with, tokens from before roles existed belong to regular users
+func rolesFromToken(tok jwt.Token) []string {
+	claim, ok := tok.Get(rolesClaim)
+	if !ok {
+		return []string{database.RoleUser}
+	}
+
+	var roles []string
+
+	switch v := claim.(type) {
+	case []string:
+		roles = v
+	case []interface{}:
+		for _, role := range v {
+			if str, ok := role.(string); ok {
+				roles = append(roles, str)
+			}
+		}
+	}
+
+	return roles
+}
+
+// authorize - check the caller of a method against its policy, public methods only need a policy
+func (s *UsersService) authorize(ctx context.Context, method string) error {
+	policy, ok := methodPolicies[method]
+
+	if !ok {
+		s.logger.Infof("Denied call to %v, which has no policy", method)
+		return status.Errorf(codes.PermissionDenied, "Method not allowed")
+	}
+
+	if s.publicMethods[method] {
+		return nil
+	}
+
+	// not callerFromContext, which refuses service accounts
+	caller, ok := auth.FromContext(ctx)
+
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "Send a valid token along with request")
+	}
+
+	if caller.IsServiceAccount() && !caller.HasScope(method) {
+		s.logger.Infof("Denied call to %v by service account %v with scopes %v", method, caller.Username, caller.Scopes)
+		return status.Errorf(codes.PermissionDenied, "Not allowed to call %v", method)
+	}
+
+	if len(policy.roles) > 0 && !caller.HasRole(policy.roles...) {
+		s.logger.Infof("Denied call to %v by user %v with roles %v", method, caller.UserID, caller.Roles)
+		return status.Errorf(codes.PermissionDenied, "Not allowed to call %v", method)
+	}
+
+	return nil
+}
+
+// authorizeUser - the caller of a mutating RPC must own the account it changes or be an admin
+func (s *UsersService) authorizeUser(ctx context.Context, userID int64) (*auth.Principal, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if caller.UserID != userID && !caller.HasRole(database.RoleAdmin) {
+		s.logger.Infof("Denied changes to user %v by user %v", userID, caller.UserID)
+		return nil, status.Errorf(codes.PermissionDenied, "Cannot change another user's account")
+	}
+
+	return caller, nil
+}
+
+// authorizeAdmin - the caller must be an admin, for RPCs that change what other users may do
+func (s *UsersService) authorizeAdmin(ctx context.Context) (*auth.Principal, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !caller.HasRole(database.RoleAdmin) {
+		return nil, status.Errorf(codes.PermissionDenied, "Only admins can do this")
+	}
+
+	return caller, nil
+}
+
+// UnaryAuthorizationInterceptor - enforce methodPolicies before calling the handler, chain it after
+// UnaryAuthenticationInterceptor so the caller is known
+func (s *UsersService) UnaryAuthorizationInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+func (s *UsersService) UpdateUserRoles(ctx context.Context, req *pbusers.UpdateUserRolesRequest) (*pbusers.UpdateUserRolesResponse, error) {
+	if _, err := s.authorizeAdmin(ctx); err != nil {
+		return &pbusers.UpdateUserRolesResponse{
+			Success: false,
+		}, err
+	}
+
+	if len(req.Roles) == 0 {
+		return &pbusers.UpdateUserRolesResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Users need at least one role")
+	}
+
+	for _, role := range req.Roles {
+		if !database.IsValidRole(role) {
+			return &pbusers.UpdateUserRolesResponse{
+				Success: false,
+			}, status.Errorf(codes.InvalidArgument, "Unknown role %v", role)
+		}
+	}
+
+	usr, err := s.db.GetUserByID(ctx, req.UserID)
+
+	if err != nil {
+		return &pbusers.UpdateUserRolesResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "User not found")
+	}
+
+	updated := &database.UserModel{
+		Model: usr.Model,
+		Roles: strings.Join(req.Roles, ","),
+	}
+
+	err = s.db.UpdateUserInfo(ctx, updated)
+
+	if err != nil {
+		s.logger.Errorf("Failed to update roles of user %v: %v", req.UserID, err)
+		return &pbusers.UpdateUserRolesResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not update roles")
+	}
+
+	// access tokens carry the old roles, refresh tokens stay valid since refreshing reads the new ones
+	err = s.revocations.RevokeUserTokens(ctx, uint(req.UserID), revocationTime())
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke tokens after changing roles of user %v: %v", req.UserID, err)
+		return &pbusers.UpdateUserRolesResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke existing sessions")
+	}
+
+	s.decisions.purgeUser(req.UserID)
+
+	return &pbusers.UpdateUserRolesResponse{
+		Success: true,
+		Roles:   updated.RoleList(),
+	}, nil
+}
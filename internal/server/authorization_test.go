@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func callWithPolicy(ctx context.Context, method string) error {
+	_, err := service.UnaryAuthorizationInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	return err
+}
+
+func Test_ShouldAllowPublicMethodWithoutToken(t *testing.T) {
+	err := callWithPolicy(context.Background(), "/kic.users.Users/AddUser")
+	if err != nil {
+		t.Errorf("Public method was denied: %v", err)
+	}
+}
+
+func Test_ShouldDenyMethodWithoutPolicy(t *testing.T) {
+	login := loginTestUser(t, "admin")
+
+	err := callWithPolicy(authContext(login.Token), "/kic.users.Users/NotInTheTable")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Method without a policy was not denied: %v", err)
+	}
+}
+
+func Test_ShouldRequireTokenForProtectedMethod(t *testing.T) {
+	err := callWithPolicy(context.Background(), "/kic.users.Users/GetUserByID")
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Protected method was callable without a token: %v", err)
+	}
+}
+
+func Test_ShouldOnlyLetAdminsUpdateRoles(t *testing.T) {
+	addTestUser(t, "rolesuser")
+	login := loginTestUser(t, "rolesuser")
+
+	err := callWithPolicy(authContext(login.Token), "/kic.users.Users/UpdateUserRoles")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Regular user was allowed to update roles: %v", err)
+	}
+
+	admin := loginTestUser(t, "admin")
+
+	err = callWithPolicy(authContext(admin.Token), "/kic.users.Users/UpdateUserRoles")
+	if err != nil {
+		t.Errorf("Admin was not allowed to update roles: %v", err)
+	}
+}
+
+func Test_ShouldEmbedRolesInToken(t *testing.T) {
+	login := loginTestUser(t, "admin")
+
+	tok, err := service.DecodeJWT(login.Token)
+	if err != nil {
+		t.Fatalf("Failed to decode token: %v", err)
+	}
+
+	roles := rolesFromToken(tok)
+	if len(roles) != 1 || roles[0] != database.RoleAdmin {
+		t.Errorf("Expected admin role in token, got %v", roles)
+	}
+}
+
+func Test_ShouldUpdateUserRoles(t *testing.T) {
+	id := addTestUser(t, "promoteme")
+	admin := loginTestUser(t, "admin")
+	before := loginTestUser(t, "promoteme")
+
+	res, err := service.UpdateUserRoles(authContext(admin.Token), &pbusers.UpdateUserRolesRequest{
+		UserID: id,
+		Roles:  []string{database.RoleUser, database.RoleModerator},
+	})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to update roles: %v", err)
+	}
+
+	// the session carries on, but its token with the old roles is revoked even if issued in the same second
+	if _, err := service.DecodeJWT(before.Token); err == nil {
+		t.Errorf("Token with the old roles still decodes")
+	}
+
+	login := loginTestUser(t, "promoteme")
+	tok, err := service.DecodeJWT(login.Token)
+	if err != nil {
+		t.Fatalf("Token issued after the role change was revoked: %v", err)
+	}
+
+	if caller, _ := principalFromToken(tok); !caller.HasRole(database.RoleModerator) {
+		t.Errorf("Expected moderator role in new token, got %v", rolesFromToken(tok))
+	}
+}
+
+func Test_ShouldRejectUnknownRole(t *testing.T) {
+	id := addTestUser(t, "unknownrole")
+	admin := loginTestUser(t, "admin")
+
+	_, err := service.UpdateUserRoles(authContext(admin.Token), &pbusers.UpdateUserRolesRequest{
+		UserID: id,
+		Roles:  []string{"superuser"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Unknown role was accepted: %v", err)
+	}
+}
+
+func Test_ShouldLetAdminDeleteAnotherUser(t *testing.T) {
+	id := addTestUser(t, "deletedbyadmin")
+	admin := loginTestUser(t, "admin")
+
+	res, err := service.DeleteUserByID(authContext(admin.Token), &pbusers.DeleteUserByIDRequest{
+		UserID: id,
+	})
+	if err != nil || !res.Success {
+		t.Errorf("Admin failed to delete another user: %v", err)
+	}
+
+	res, err = service.DeleteUserByID(authContext(admin.Token), &pbusers.DeleteUserByIDRequest{
+		UserID: id,
+	})
+	if status.Code(err) != codes.Internal || res.GetSuccess() {
+		t.Errorf("Deleting an already deleted user looked successful: %v", err)
+	}
+}
+
+func Test_ShouldNotLetUserDeleteAnotherUser(t *testing.T) {
+	id := addTestUser(t, "notdeleted")
+	addTestUser(t, "deleter")
+	login := loginTestUser(t, "deleter")
+
+	_, err := service.DeleteUserByID(authContext(login.Token), &pbusers.DeleteUserByIDRequest{
+		UserID: id,
+	})
+	if err == nil {
+		t.Errorf("User deleted another user's account")
+	}
+}
+
+func Test_ShouldNotLetUserUpdateRoles(t *testing.T) {
+	id := addTestUser(t, "selfpromoter")
+	login := loginTestUser(t, "selfpromoter")
+
+	_, err := service.UpdateUserRoles(authContext(login.Token), &pbusers.UpdateUserRolesRequest{
+		UserID: id,
+		Roles:  []string{database.RoleAdmin},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("User was able to change their own roles: %v", err)
+	}
+}
+
+func Test_ShouldNotLetUserUpdateAnotherUser(t *testing.T) {
+	id := addTestUser(t, "victim")
+	addTestUser(t, "attacker")
+	login := loginTestUser(t, "attacker")
+
+	for _, req := range []*pbusers.UpdateUserInfoRequest{
+		{UserID: id, Email: "attacker@evil.com"},
+		{UserID: id, DesiredPassword: "hijacked"},
+		{UserID: id, Bio: "defaced"},
+	} {
+		resp, err := service.UpdateUserInfo(authContext(login.Token), req)
+		if status.Code(err) != codes.PermissionDenied || resp.Success {
+			t.Errorf("User updated another user's account with %v: %v", req, err)
+		}
+	}
+
+	victim, _ := service.GetUserByID(context.Background(), &pbusers.GetUserByIDRequest{UserID: id})
+	if victim.User.Email != "victim@gmail.com" || victim.User.Bio != "" {
+		t.Errorf("Victim account was changed: %v", victim.User)
+	}
+
+	if valid, err := service.ValidateUser("victim", "password"); !valid || err != nil {
+		t.Errorf("Victim password was changed: %v", err)
+	}
+}
+
+func Test_ShouldNotUpdateUserWithoutToken(t *testing.T) {
+	id := addTestUser(t, "notoken")
+
+	_, err := service.UpdateUserInfo(context.Background(), &pbusers.UpdateUserInfoRequest{
+		UserID: id,
+		Bio:    "anonymous",
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Updated user without a token: %v", err)
+	}
+}
+
+func Test_ShouldLetAdminUpdateAnotherUser(t *testing.T) {
+	id := addTestUser(t, "updatedbyadmin")
+	admin := loginTestUser(t, "admin")
+
+	resp, err := service.UpdateUserInfo(authContext(admin.Token), &pbusers.UpdateUserInfoRequest{
+		UserID: id,
+		Bio:    "moderated",
+	})
+	if err != nil || resp.UpdatedUser.Bio != "moderated" {
+		t.Errorf("Admin failed to update another user: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// reauthenticationWindow - how long after logging in sensitive changes can be made without the current password
+const reauthenticationWindow = 5 * time.Minute
+
+// reauthenticate - make the caller prove who they are before a sensitive change, so a stolen token alone is not
+// enough to take over the account. Either the current password is sent or the caller logged in recently. Returns
+// the time the caller last authenticated.
+func (s *UsersService) reauthenticate(ctx context.Context, caller *auth.Principal, currentPassword string) (time.Time, error) {
+	if currentPassword == "" {
+		if !caller.AuthTime.IsZero() && time.Since(caller.AuthTime) <= reauthenticationWindow {
+			return caller.AuthTime, nil
+		}
+
+		return time.Time{}, status.Errorf(codes.PermissionDenied, "Send the current password or log in again")
+	}
+
+	usr, err := s.db.GetUserByID(ctx, caller.UserID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to get user %v to reauthenticate: %v", caller.UserID, err)
+		return time.Time{}, status.Errorf(codes.NotFound, "User not found")
+	}
+
+	// the password is as guessable here as at login, so failures count towards the same lockout
+	subjects := loginSubjects(ctx, usr.Username)
+
+	if err := s.checkLoginAllowed(ctx, subjects); err != nil {
+		return time.Time{}, err
+	}
+
+	valid, err := s.ValidateUser(usr.Username, currentPassword)
+
+	if err != nil || !valid {
+		s.recordLoginFailure(ctx, subjects)
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "Invalid current password")
+	}
+
+	return time.Now(), nil
+}
+
+// replaceSessionToken - a new JWT for the session of the caller, after revokeOtherSessions revoked the old one
+func (s *UsersService) replaceSessionToken(ctx context.Context, caller *auth.Principal, usr *database.UserModel, authTime time.Time) (string, error) {
+	token, tokenID, err := s.generateSessionJWT(ctx, int64(usr.ID), usr.Username, usr.RoleList(), caller.SessionID, authTime)
+	if err != nil {
+		return "", err
+	}
+
+	s.touchSession(ctx, caller.SessionID, tokenID)
+
+	return token, nil
+}
+
+// revokeSessionsAfterEmailChange - log the user out of every session but the one of the caller, whose token is
+// replaced. Admins changing the email of someone else log the user out everywhere and get no token.
+func (s *UsersService) revokeSessionsAfterEmailChange(ctx context.Context, caller *auth.Principal, usr *database.UserModel, authTime time.Time) (string, error) {
+	if caller.UserID != int64(usr.ID) {
+		return "", s.revokeAllUserTokens(ctx, usr.ID)
+	}
+
+	if err := s.revokeOtherSessions(ctx, usr.ID, caller.SessionID); err != nil {
+		return "", err
+	}
+
+	return s.replaceSessionToken(ctx, caller, usr, authTime)
+}
+
+func (s *UsersService) ChangePassword(ctx context.Context, req *pbusers.ChangePasswordRequest) (*pbusers.ChangePasswordResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, err
+	}
+
+	if req.NewPassword == "" {
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "New password is required")
+	}
+
+	authTime, err := s.reauthenticate(ctx, caller, req.CurrentPassword)
+
+	if err != nil {
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, err
+	}
+
+	usr, err := s.db.GetUserByID(ctx, caller.UserID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to get user %v to change the password of: %v", caller.UserID, err)
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "User not found")
+	}
+
+	if err := s.checkPasswordPolicy("newPassword", req.NewPassword, usr.Username, usr.Email); err != nil {
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.NewPassword)
+
+	if err != nil {
+		s.logger.Errorf("Failed to hash password: %v", err)
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Password cannot be encrypted")
+	}
+
+	model := &database.UserModel{Password: hashedPassword}
+	model.ID = usr.ID
+
+	err = s.db.UpdateUserInfo(ctx, model)
+
+	if err != nil {
+		s.logger.Errorf("Failed to change password of user %v: %v", usr.ID, err)
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not change password")
+	}
+
+	// other sessions may have been started with the old password by whoever else knew it
+	err = s.revokeOtherSessions(ctx, usr.ID, caller.SessionID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke other sessions after password change: %v", err)
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke existing sessions")
+	}
+
+	token, err := s.replaceSessionToken(ctx, caller, usr, authTime)
+
+	if err != nil {
+		s.logger.Errorf("Failed to replace token of user %v: %v", usr.ID, err)
+		return &pbusers.ChangePasswordResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	return &pbusers.ChangePasswordResponse{
+		Success: true,
+		Token:   token,
+	}, nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// tokenSession - the session ID and auth time of a token issued by the shared service
+func tokenSession(t *testing.T, token string) (string, time.Time) {
+	tok, err := service.DecodeJWT(token)
+	if err != nil {
+		t.Fatalf("Failed to decode token: %v", err)
+	}
+	return sessionFromToken(tok)
+}
+
+// staleToken - a token of the same session as the given one, for a login longer ago than the reauthentication window
+func staleToken(t *testing.T, userID int64, username, token string) string {
+	sessionID, _ := tokenSession(t, token)
+
+	stale, _, err := service.generateSessionJWT(context.Background(), userID, username, []string{database.RoleUser}, sessionID, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	return stale
+}
+
+func Test_ShouldKeepSessionAcrossRefresh(t *testing.T) {
+	addTestUser(t, "sessionkeeper")
+	login := loginTestUser(t, "sessionkeeper")
+
+	sessionID, authTime := tokenSession(t, login.Token)
+	if sessionID == "" || time.Since(authTime) > time.Minute {
+		t.Fatalf("Login token has no session, sid %q and auth_time %v", sessionID, authTime)
+	}
+
+	refreshed, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: login.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("Failed to refresh: %v", err)
+	}
+
+	refreshedSession, refreshedAuthTime := tokenSession(t, refreshed.Token)
+	if refreshedSession != sessionID || !refreshedAuthTime.Equal(authTime) {
+		t.Errorf("Refresh changed the session from %q at %v to %q at %v", sessionID, authTime, refreshedSession, refreshedAuthTime)
+	}
+}
+
+func Test_ShouldChangePasswordRecentlyLoggedIn(t *testing.T) {
+	addTestUser(t, "recentlogin")
+	other := loginTestUser(t, "recentlogin")
+	login := loginTestUser(t, "recentlogin")
+	sessionID, _ := tokenSession(t, login.Token)
+
+	res, err := service.ChangePassword(authContext(login.Token), &pbusers.ChangePasswordRequest{
+		NewPassword: "changedpassword",
+	})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to change password right after logging in: %v", err)
+	}
+
+	if valid, _ := service.ValidateUser("recentlogin", "changedpassword"); !valid {
+		t.Errorf("New password was not set")
+	}
+
+	if replaced, _ := tokenSession(t, res.Token); replaced != sessionID {
+		t.Errorf("Replacement token belongs to session %q instead of %q", replaced, sessionID)
+	}
+
+	if _, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{RefreshToken: other.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Other session was not logged out: %v", err)
+	}
+}
+
+func Test_ShouldRequireCurrentPasswordWhenLoginIsStale(t *testing.T) {
+	id := addTestUser(t, "stalelogin")
+	login := loginTestUser(t, "stalelogin")
+	ctx := authContext(staleToken(t, id, "stalelogin", login.Token))
+	clock := newTestClock()
+	s := newTestService(t, WithClock(clock.Now))
+
+	_, err := s.ChangePassword(ctx, &pbusers.ChangePasswordRequest{NewPassword: "stolentoken"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Password changed with a stale token and no current password: %v", err)
+	}
+
+	_, err = s.ChangePassword(ctx, &pbusers.ChangePasswordRequest{CurrentPassword: "guess", NewPassword: "stolentoken"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Password changed with the wrong current password: %v", err)
+	}
+
+	if valid, _ := s.ValidateUser("stalelogin", "password"); !valid {
+		t.Fatalf("Password was changed by a refused request")
+	}
+
+	// the failed guess backs off further attempts briefly
+	clock.advance(1100 * time.Millisecond)
+
+	res, err := s.ChangePassword(ctx, &pbusers.ChangePasswordRequest{CurrentPassword: "password", NewPassword: "knownpassword"})
+	if err != nil || !res.Success {
+		t.Errorf("Failed to change password with the current password: %v", err)
+	}
+}
+
+func Test_ShouldNotChangePasswordThroughUpdateUserInfo(t *testing.T) {
+	id := addTestUser(t, "updatepassword")
+	login := loginTestUser(t, "updatepassword")
+
+	_, err := service.UpdateUserInfo(authContext(login.Token), &pbusers.UpdateUserInfoRequest{
+		UserID:          id,
+		DesiredPassword: "sneakypassword",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("UpdateUserInfo accepted a password: %v", err)
+	}
+
+	if valid, _ := service.ValidateUser("updatepassword", "password"); !valid {
+		t.Errorf("Password was changed through UpdateUserInfo")
+	}
+}
+
+func Test_ShouldGuardEmailChange(t *testing.T) {
+	id := addTestUser(t, "guardedemail")
+	other := loginTestUser(t, "guardedemail")
+	login := loginTestUser(t, "guardedemail")
+	ctx := authContext(staleToken(t, id, "guardedemail", login.Token))
+
+	_, err := service.UpdateUserInfo(ctx, &pbusers.UpdateUserInfoRequest{
+		UserID: id,
+		Email:  "takeover@evil.com",
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Email changed with a stale token and no current password: %v", err)
+	}
+
+	// other fields need no password
+	if _, err := service.UpdateUserInfo(ctx, &pbusers.UpdateUserInfoRequest{UserID: id, Bio: "still me"}); err != nil {
+		t.Fatalf("Failed to update bio: %v", err)
+	}
+
+	res, err := service.UpdateUserInfo(ctx, &pbusers.UpdateUserInfoRequest{
+		UserID:          id,
+		Email:           "guardedemail@example.com",
+		CurrentPassword: "password",
+	})
+	if err != nil || !res.Success || res.Token == "" {
+		t.Fatalf("Failed to change email with the current password: %v", err)
+	}
+
+	usr, _ := service.db.GetUserByID(context.Background(), id)
+	if usr.PendingEmail != "guardedemail@example.com" {
+		t.Errorf("Email change is not pending, got %q", usr.PendingEmail)
+	}
+
+	if _, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{RefreshToken: other.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Other session was not logged out: %v", err)
+	}
+	if _, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{RefreshToken: login.RefreshToken}); err != nil {
+		t.Errorf("Session that changed the email was logged out: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+const (
+	defaultIssuer    = "kic-users"
+	defaultAudience  = "kic"
+	defaultClockSkew = 30 * time.Second
+	// tokens issued before the standard claims were added only carry uid and live for an hour at most
+	defaultLegacyTokenPeriod = time.Hour
+
+	tokenLifetime = time.Hour
+
+	// the refresh token session a token belongs to and when the user logged in to it, as in OpenID Connect
+	sessionIDClaim = "sid"
+	authTimeClaim  = "auth_time"
+)
+
+// TokenConfig - the claims tokens are issued with and how strictly they are checked when decoded
+type TokenConfig struct {
+	Issuer string
+	// tokens are issued for every audience and accepted if they are meant for any of them
+	Audiences []string
+	// how far apart the clocks of the issuing and checking services may be
+	ClockSkew time.Duration
+	// tokens with only a uid claim are accepted until this time
+	LegacyTokensUntil time.Time
+}
+
+// TokenConfigFromEnv - read the token config from JWT_ISSUER, JWT_AUDIENCES (comma separated), JWT_CLOCK_SKEW
+// and JWT_LEGACY_TOKEN_PERIOD, the time after startup during which legacy uid only tokens are still accepted
+func TokenConfigFromEnv() (TokenConfig, error) {
+	config := TokenConfig{
+		Issuer:            defaultIssuer,
+		Audiences:         []string{defaultAudience},
+		ClockSkew:         defaultClockSkew,
+		LegacyTokensUntil: time.Now().Add(defaultLegacyTokenPeriod),
+	}
+
+	if v := os.Getenv("JWT_ISSUER"); v != "" {
+		config.Issuer = v
+	}
+
+	if v := os.Getenv("JWT_AUDIENCES"); v != "" {
+		config.Audiences = nil
+		for _, aud := range strings.Split(v, ",") {
+			if aud = strings.TrimSpace(aud); aud != "" {
+				config.Audiences = append(config.Audiences, aud)
+			}
+		}
+		if len(config.Audiences) == 0 {
+			return config, errors.New("JWT_AUDIENCES must list at least one audience")
+		}
+	}
+
+	if v := os.Getenv("JWT_CLOCK_SKEW"); v != "" {
+		skew, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid JWT_CLOCK_SKEW %v: %w", v, err)
+		}
+		config.ClockSkew = skew
+	}
+
+	if v := os.Getenv("JWT_LEGACY_TOKEN_PERIOD"); v != "" {
+		period, err := time.ParseDuration(v)
+		if err != nil {
+			return config, fmt.Errorf("invalid JWT_LEGACY_TOKEN_PERIOD %v: %w", v, err)
+		}
+		config.LegacyTokensUntil = time.Now().Add(period)
+	}
+
+	return config, nil
+}
+
+// setStandardClaims - fill in the registered claims every token is issued with
+func (s *UsersService) setStandardClaims(t jwt.Token, userID int64, tokenID string, issuedAt time.Time) error {
+	claims := map[string]interface{}{
+		jwt.SubjectKey:    strconv.FormatInt(userID, 10),
+		jwt.IssuerKey:     s.tokens.Issuer,
+		jwt.AudienceKey:   s.tokens.Audiences,
+		jwt.IssuedAtKey:   issuedAt,
+		jwt.NotBeforeKey:  issuedAt,
+		jwt.ExpirationKey: issuedAt.Add(tokenLifetime),
+		jwt.JwtIDKey:      tokenID,
+		// kept for the other kic services that read the user ID from uid
+		"uid": strconv.FormatInt(userID, 10),
+	}
+
+	for name, value := range claims {
+		if err := t.Set(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setSessionClaims - record the session of a token, tokens issued outside of a session leave sid out
+func setSessionClaims(t jwt.Token, sessionID string, authTime time.Time) error {
+	if sessionID != "" {
+		if err := t.Set(sessionIDClaim, sessionID); err != nil {
+			return err
+		}
+	}
+
+	if !authTime.IsZero() {
+		// a number of seconds like the registered time claims, not the string a time.Time would become
+		if err := t.Set(authTimeClaim, authTime.Unix()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sessionFromToken - the session ID and auth time of a token, empty for tokens issued before sessions were recorded
+func sessionFromToken(t jwt.Token) (string, time.Time) {
+	var sessionID string
+	if claim, ok := t.Get(sessionIDClaim); ok {
+		sessionID, _ = claim.(string)
+	}
+
+	var authTime time.Time
+	if claim, ok := t.Get(authTimeClaim); ok {
+		switch v := claim.(type) {
+		case float64:
+			authTime = time.Unix(int64(v), 0)
+		case int64:
+			authTime = time.Unix(v, 0)
+		}
+	}
+
+	return sessionID, authTime
+}
+
+// validateClaims - check the registered claims of a token whose signature was already verified
+func (s *UsersService) validateClaims(t jwt.Token) error {
+	// checked separately so clients can be told to refresh instead of logging in again
+	if exp := t.Expiration(); !exp.IsZero() && time.Now().After(exp.Add(s.tokens.ClockSkew)) {
+		return errTokenExpired
+	}
+
+	err := jwt.Validate(t,
+		jwt.WithIssuer(s.tokens.Issuer),
+		jwt.WithAcceptableSkew(s.tokens.ClockSkew),
+	)
+	if err != nil {
+		return err
+	}
+
+	if t.Issuer() == "" && t.Subject() == "" {
+		return s.validateLegacyClaims(t)
+	}
+
+	if _, ok := t.Get(mfaChallengeClaim); ok {
+		return errors.New("MFA challenge tokens cannot be used to authenticate")
+	}
+
+	if t.Issuer() == "" {
+		return errors.New("token has no issuer")
+	}
+
+	if t.Subject() == "" {
+		return errors.New("token has no subject")
+	}
+
+	if t.Expiration().IsZero() {
+		return errors.New("token has no expiry")
+	}
+
+	for _, aud := range t.Audience() {
+		for _, accepted := range s.tokens.Audiences {
+			if aud == accepted {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("token is not meant for this audience")
+}
+
+// validateLegacyClaims - tokens issued before the registered claims were added only have uid and exp
+func (s *UsersService) validateLegacyClaims(t jwt.Token) error {
+	if !time.Now().Before(s.tokens.LegacyTokensUntil) {
+		return errors.New("legacy tokens are no longer accepted")
+	}
+
+	if _, ok := t.Get("uid"); !ok {
+		return errors.New("token has no subject")
+	}
+
+	if t.Expiration().IsZero() {
+		return errors.New("token has no expiry")
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+
+	"github.com/kic/users/pkg/database"
+)
+
+// signClaims - sign a token with the test service's key after letting the caller adjust its claims
+func signClaims(t *testing.T, adjust func(jwt.Token)) string {
+	tok := jwt.New()
+	if err := service.setStandardClaims(tok, 0, "claims-test", time.Now()); err != nil {
+		t.Fatalf("Failed to set claims: %v", err)
+	}
+	adjust(tok)
+
+	key := service.keys.signer()
+	signed, err := jwt.Sign(tok, key.alg, key.private)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return string(signed)
+}
+
+func Test_ShouldIssueStandardClaims(t *testing.T) {
+	token, _ := service.GenerateJWT(0, "tester", []string{database.RoleUser})
+
+	tok, err := service.DecodeJWT(token)
+	if err != nil {
+		t.Fatalf("Failed to decode token: %v", err)
+	}
+
+	if tok.Subject() != "0" || tok.Issuer() != defaultIssuer || tok.JwtID() == "" {
+		t.Errorf("Token is missing sub, iss or jti: %v %v %v", tok.Subject(), tok.Issuer(), tok.JwtID())
+	}
+
+	if len(tok.Audience()) != 1 || tok.Audience()[0] != defaultAudience {
+		t.Errorf("Token has wrong audience %v", tok.Audience())
+	}
+
+	if tok.IssuedAt().IsZero() || tok.NotBefore().IsZero() || tok.Expiration().IsZero() {
+		t.Errorf("Token is missing iat, nbf or exp")
+	}
+}
+
+func Test_ShouldRejectWrongIssuer(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Set(jwt.IssuerKey, "someone-else")
+	})
+
+	if _, err := service.DecodeJWT(token); err == nil {
+		t.Errorf("Accepted a token from another issuer")
+	}
+}
+
+func Test_ShouldRejectWrongAudience(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Set(jwt.AudienceKey, []string{"another-app"})
+	})
+
+	if _, err := service.DecodeJWT(token); err == nil {
+		t.Errorf("Accepted a token meant for another audience")
+	}
+}
+
+func Test_ShouldAcceptAnyConfiguredAudience(t *testing.T) {
+	s := newTestService(t, WithTokenConfig(TokenConfig{
+		Issuer:    defaultIssuer,
+		Audiences: []string{"kic-mobile", defaultAudience},
+		ClockSkew: defaultClockSkew,
+	}))
+
+	if _, err := s.DecodeJWT(signClaims(t, func(jwt.Token) {})); err != nil {
+		t.Errorf("Rejected a token meant for one of the configured audiences: %v", err)
+	}
+}
+
+func Test_ShouldRejectExpiredToken(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Set(jwt.ExpirationKey, time.Now().Add(-time.Minute))
+	})
+
+	if _, err := service.DecodeJWT(token); err == nil {
+		t.Errorf("Accepted an expired token")
+	}
+}
+
+func Test_ShouldRejectTokenNotYetValid(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Set(jwt.NotBeforeKey, time.Now().Add(time.Minute))
+	})
+
+	if _, err := service.DecodeJWT(token); err == nil {
+		t.Errorf("Accepted a token before its nbf")
+	}
+}
+
+func Test_ShouldTolerateClockSkew(t *testing.T) {
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Set(jwt.IssuedAtKey, time.Now().Add(10*time.Second))
+		tok.Set(jwt.NotBeforeKey, time.Now().Add(10*time.Second))
+	})
+
+	if _, err := service.DecodeJWT(token); err != nil {
+		t.Errorf("Rejected a token within the clock skew tolerance: %v", err)
+	}
+}
+
+func Test_ShouldRejectLegacyTokenAfterMigration(t *testing.T) {
+	s := newTestService(t, WithTokenConfig(TokenConfig{
+		Issuer:            defaultIssuer,
+		Audiences:         []string{defaultAudience},
+		ClockSkew:         defaultClockSkew,
+		LegacyTokensUntil: time.Now().Add(-time.Minute),
+	}))
+
+	token := signClaims(t, func(tok jwt.Token) {
+		tok.Remove(jwt.SubjectKey)
+		tok.Remove(jwt.IssuerKey)
+		tok.Remove(jwt.AudienceKey)
+	})
+
+	if _, err := service.DecodeJWT(token); err != nil {
+		t.Errorf("Rejected a legacy token during the migration period: %v", err)
+	}
+
+	if _, err := s.DecodeJWT(token); err == nil {
+		t.Errorf("Accepted a legacy token after the migration period")
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// DefaultDecisionCacheSize and DefaultDecisionCacheTTL - limits of the Check decision cache unless
+// WithDecisionCache is used
+const (
+	DefaultDecisionCacheSize = 10000
+	DefaultDecisionCacheTTL  = 30 * time.Second
+)
+
+// decisionCacheStats - hits, misses and evictions of every decision cache, served on /debug/vars of the admin port
+var decisionCacheStats = expvar.NewMap("authz_decision_cache")
+
+type cachedDecision struct {
+	key       string
+	decision  checkDecision
+	expiresAt time.Time
+}
+
+// decisionCache - bounded LRU of Check decisions for verified tokens, so repeated requests skip verifying the
+// JWT. Revoking tokens purges the decisions of their user, other replicas find out when they check the revocation
+// state of a cached decision before using it.
+type decisionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+func newDecisionCache(size int, ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// get - the cached decision for the key, if it has not expired
+func (c *decisionCache) get(key string) (checkDecision, bool) {
+	if c == nil || c.size <= 0 {
+		return checkDecision{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		decisionCacheStats.Add("misses", 1)
+		return checkDecision{}, false
+	}
+
+	entry := elem.Value.(*cachedDecision)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		decisionCacheStats.Add("misses", 1)
+		return checkDecision{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	decisionCacheStats.Add("hits", 1)
+	return entry.decision, true
+}
+
+// add - cache a decision about a verified caller, never past the expiry of their token
+func (c *decisionCache) add(key string, decision checkDecision) {
+	if c == nil || c.size <= 0 || decision.caller == nil {
+		return
+	}
+
+	expiresAt := c.now().Add(c.ttl)
+	if exp := decision.caller.ExpiresAt; !exp.IsZero() && exp.Before(expiresAt) {
+		expiresAt = exp
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedDecision{
+		key:       key,
+		decision:  decision,
+		expiresAt: expiresAt,
+	})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		decisionCacheStats.Add("evictions", 1)
+	}
+}
+
+// forget - drop the decision for the key, after its caller turned out to be revoked
+func (c *decisionCache) forget(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// purgeUser - forget the decisions about a user, after their tokens were revoked
+func (c *decisionCache) purgeUser(userID int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cachedDecision).decision.caller.UserID == userID {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+// purgeServiceAccount - forget the decisions about a service account, after it was revoked or its key rotated
+func (c *decisionCache) purgeServiceAccount(id int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*cachedDecision).decision.caller.ServiceAccountID == id {
+			c.remove(elem)
+		}
+		elem = next
+	}
+}
+
+func (c *decisionCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cachedDecision).key)
+}
+
+func (c *decisionCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
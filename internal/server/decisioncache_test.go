@@ -0,0 +1,163 @@
+package server
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kic/users/pkg/auth"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+func cacheStat(name string) int64 {
+	if v, ok := decisionCacheStats.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func Test_ShouldCacheCheckDecisions(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+	login := loginTestUser(t, "admin")
+
+	hits, misses := cacheStat("hits"), cacheStat("misses")
+
+	for i := 0; i < 3; i++ {
+		if check := checkRequest(s, "", "GET", "/admin", login.Token); check.GetOkResponse() == nil {
+			t.Fatalf("Denied admin: %v", check.GetStatus().GetMessage())
+		}
+	}
+
+	if got := cacheStat("misses") - misses; got != 1 {
+		t.Errorf("Expected 1 cache miss, got %v", got)
+	}
+	if got := cacheStat("hits") - hits; got != 2 {
+		t.Errorf("Expected 2 cache hits, got %v", got)
+	}
+
+	// another rule is decided separately
+	if check := checkRequest(s, "", "GET", "/users", login.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied admin: %v", check.GetStatus().GetMessage())
+	}
+	if got := cacheStat("misses") - misses; got != 2 {
+		t.Errorf("Expected a cache miss for another rule, got %v misses", got)
+	}
+}
+
+func Test_ShouldNotCacheDecisionsPastTokenExpiry(t *testing.T) {
+	clock := newTestClock()
+	c := newDecisionCache(10, time.Hour)
+	c.now = clock.Now
+
+	c.add("key", checkDecision{allowed: true, caller: &auth.Principal{ExpiresAt: clock.Now().Add(50 * time.Millisecond)}})
+
+	if _, ok := c.get("key"); !ok {
+		t.Fatalf("Decision was not cached")
+	}
+
+	clock.advance(60 * time.Millisecond)
+
+	if _, ok := c.get("key"); ok {
+		t.Errorf("Decision outlived the token")
+	}
+}
+
+func Test_ShouldEvictLeastRecentlyUsedDecision(t *testing.T) {
+	c := newDecisionCache(2, time.Hour)
+	caller := &auth.Principal{UserID: 1}
+
+	c.add("a", checkDecision{caller: caller})
+	c.add("b", checkDecision{caller: caller})
+	c.get("a")
+	c.add("c", checkDecision{caller: caller})
+
+	if c.len() != 2 {
+		t.Errorf("Cache grew past its size to %v", c.len())
+	}
+	if _, ok := c.get("b"); ok {
+		t.Errorf("Least recently used decision was not evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("Recently used decision was evicted")
+	}
+}
+
+func Test_ShouldNotCacheInvalidTokens(t *testing.T) {
+	c := newDecisionCache(10, time.Hour)
+
+	c.add("key", checkDecision{allowed: false, denial: denyTokenInvalid})
+
+	if c.len() != 0 {
+		t.Errorf("Cached a decision without a verified caller")
+	}
+}
+
+func Test_ShouldPurgeCachedDecisionsOnLogout(t *testing.T) {
+	addTestUser(t, "cachedlogout")
+	login := loginTestUser(t, "cachedlogout")
+
+	if check := checkToken(login.Token); check.GetOkResponse() == nil {
+		t.Fatalf("Denied valid token")
+	}
+
+	_, err := service.Logout(authContext(login.Token), &pbusers.LogoutRequest{})
+	if err != nil {
+		t.Fatalf("Failed to log out: %v", err)
+	}
+
+	if check := checkToken(login.Token); check.GetOkResponse() != nil {
+		t.Errorf("Cached decision allowed a token after logout")
+	}
+}
+
+func Test_ShouldAllowDeniedRequestsInShadowMode(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)), WithShadowMode(true))
+
+	addTestUser(t, "shadowed")
+	login := loginTestUser(t, "shadowed")
+
+	check := checkRequest(s, "", "GET", "/admin", login.Token)
+
+	headers := upstreamHeaders(t, check)
+	if !strings.Contains(headers[reasonHeader], "would deny") {
+		t.Errorf("Expected shadow reason, got %q", headers[reasonHeader])
+	}
+	if headers[usernameHeader] != "shadowed" {
+		t.Errorf("Shadow mode dropped the caller identity")
+	}
+}
+
+func Test_ShouldDenyCachedDecisionsRevokedOnAnotherReplica(t *testing.T) {
+	// shares the stores of service but not its decision cache
+	replica := newTestService(t, WithRefreshTokenRepository(service.refreshTokens), WithRevocationRepository(service.revocations),
+		WithSessionRepository(service.sessions))
+
+	addTestUser(t, "replicated")
+	kept := loginTestUser(t, "replicated")
+	lost := loginTestUser(t, "replicated")
+	loggedOut := loginTestUser(t, "replicated")
+
+	for _, token := range []string{kept.Token, lost.Token, loggedOut.Token} {
+		if check := checkRequest(replica, "", "GET", "/", token); check.GetOkResponse() == nil {
+			t.Fatalf("Denied valid token: %v", check.GetStatus().GetMessage())
+		}
+	}
+
+	lostSession, _ := tokenSession(t, lost.Token)
+	if _, err := service.RevokeSession(authContext(kept.Token), &pbusers.RevokeSessionRequest{SessionID: lostSession}); err != nil {
+		t.Fatalf("Failed to revoke session: %v", err)
+	}
+	if _, err := service.Logout(authContext(loggedOut.Token), &pbusers.LogoutRequest{}); err != nil {
+		t.Fatalf("Failed to log out: %v", err)
+	}
+
+	for _, token := range []string{lost.Token, loggedOut.Token} {
+		if check := checkRequest(replica, "", "GET", "/", token); check.GetOkResponse() != nil {
+			t.Errorf("Cached decision allowed a token revoked on another replica")
+		}
+	}
+	if check := checkRequest(replica, "", "GET", "/", kept.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied a token that was not revoked: %v", check.GetStatus().GetMessage())
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/mail"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// EmailVerificationPolicy - whether users need a verified email address to log in
+type EmailVerificationPolicy string
+
+const (
+	// EmailVerificationOptional - unverified users can log in, the default since accounts from before
+	// verification existed are all unverified
+	EmailVerificationOptional EmailVerificationPolicy = "optional"
+	// EmailVerificationRequired - GetJWTToken refuses users who have not verified their email yet
+	EmailVerificationRequired EmailVerificationPolicy = "required"
+)
+
+const (
+	emailVerificationTokenBytes = 32
+	emailVerificationLifetime   = 24 * time.Hour
+	emailVerificationPath       = "/verify-email"
+)
+
+const emailVerificationBody = `Hi %v,
+
+Please confirm that this is your email address for your KIC account:
+
+%v
+
+The link expires in %v. If you did not sign up for KIC or change your email you can ignore this email.
+`
+
+// ParseEmailVerificationPolicy - the policy with the given name
+func ParseEmailVerificationPolicy(name string) (EmailVerificationPolicy, error) {
+	switch policy := EmailVerificationPolicy(name); policy {
+	case EmailVerificationOptional, EmailVerificationRequired:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown email verification policy %q", name)
+}
+
+// sendEmailVerification - email a link to confirm the address to it
+func (s *UsersService) sendEmailVerification(ctx context.Context, userID uint, username, email string) error {
+	token, err := newOpaqueToken(emailVerificationTokenBytes)
+
+	if err != nil {
+		return err
+	}
+
+	err = s.oneTimeTokens.AddOneTimeToken(ctx, &database.OneTimeTokenModel{
+		UserID:    userID,
+		Purpose:   database.PurposeEmailVerification,
+		TokenHash: hashToken(token),
+		Email:     email,
+		ExpiresAt: time.Now().Add(emailVerificationLifetime),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      email,
+		Subject: "Confirm your KIC email address",
+		Body:    fmt.Sprintf(emailVerificationBody, username, s.accountLink(emailVerificationPath, token), emailVerificationLifetime),
+	})
+}
+
+func (s *UsersService) VerifyEmail(ctx context.Context, req *pbusers.VerifyEmailRequest) (*pbusers.VerifyEmailResponse, error) {
+	verification, err := s.oneTimeTokens.UseOneTimeToken(ctx, database.PurposeEmailVerification, hashToken(req.Token))
+
+	if errors.Is(err, database.ErrOneTimeTokenInvalid) {
+		return &pbusers.VerifyEmailResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Invalid or expired verification token")
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to use email verification token: %v", err)
+		return &pbusers.VerifyEmailResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not verify email")
+	}
+
+	// fails when the user changed their email again since the link was sent, or someone else took the address
+	err = s.db.ConfirmEmail(ctx, int64(verification.UserID), verification.Email)
+
+	if err != nil {
+		s.logger.Debugf("Failed to confirm email of user %v: %v", verification.UserID, err)
+		return &pbusers.VerifyEmailResponse{
+			Success: false,
+		}, status.Errorf(codes.FailedPrecondition, "Email address can no longer be verified")
+	}
+
+	return &pbusers.VerifyEmailResponse{
+		Success: true,
+		Email:   verification.Email,
+	}, nil
+}
+
+func (s *UsersService) ResendVerificationEmail(ctx context.Context, req *pbusers.ResendVerificationEmailRequest) (*pbusers.ResendVerificationEmailResponse, error) {
+	if req.Email == "" {
+		return &pbusers.ResendVerificationEmailResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Email is required")
+	}
+
+	usr, err := s.db.GetUserByEmail(ctx, req.Email)
+
+	// the response must not tell which addresses have accounts or are verified
+	if err != nil || usr.EmailVerified {
+		s.logger.Debugf("No unverified user with email %v: %v", req.Email, err)
+		return &pbusers.ResendVerificationEmailResponse{
+			Success: true,
+		}, nil
+	}
+
+	err = s.sendEmailVerification(ctx, usr.ID, usr.Username, usr.Email)
+
+	if err != nil {
+		s.logger.Errorf("Failed to send verification email to user %v: %v", usr.ID, err)
+		return &pbusers.ResendVerificationEmailResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not send verification email")
+	}
+
+	return &pbusers.ResendVerificationEmailResponse{
+		Success: true,
+	}, nil
+}
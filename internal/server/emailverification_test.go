@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/mail"
+	pbcommon "github.com/kic/users/pkg/proto/common"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// verificationToken - the token in the last verification link emailed to the address
+func verificationToken(t *testing.T, mailer *mail.MemoryMailer, email string) string {
+	msg, ok := mailer.Last(email)
+	if !ok {
+		t.Fatalf("No email was sent to %v", email)
+	}
+
+	link, err := url.Parse(accountLinkPattern.FindString(msg.Body))
+	if err != nil || link.Path != emailVerificationPath {
+		t.Fatalf("Email has no verification link: %q", msg.Body)
+	}
+	return link.Query().Get("token")
+}
+
+func signUp(t *testing.T, s *UsersService, username string) int64 {
+	res, err := s.AddUser(context.Background(), &pbusers.AddUserRequest{
+		Email:           username + "@gmail.com",
+		DesiredUsername: username,
+		DesiredPassword: "password",
+		Birthday: &pbcommon.Date{
+			Year:  1990,
+			Month: 1,
+			Day:   2,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add user %v: %v", username, err)
+	}
+	return res.CreatedUser.UserID
+}
+
+func Test_ShouldVerifyEmailOnSignup(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer))
+	id := signUp(t, s, "newcomer")
+
+	res, err := s.VerifyEmail(context.Background(), &pbusers.VerifyEmailRequest{
+		Token: verificationToken(t, mailer, "newcomer@gmail.com"),
+	})
+	if err != nil || !res.Success || res.Email != "newcomer@gmail.com" {
+		t.Fatalf("Failed to verify email: %v", err)
+	}
+
+	if usr, _ := s.db.GetUserByID(context.Background(), id); !usr.EmailVerified {
+		t.Errorf("User was not marked as verified")
+	}
+
+	_, err = s.VerifyEmail(context.Background(), &pbusers.VerifyEmailRequest{
+		Token: verificationToken(t, mailer, "newcomer@gmail.com"),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Verification token was accepted twice: %v", err)
+	}
+}
+
+func Test_ShouldKeepEmailChangePending(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer))
+	id := signUp(t, s, "mover")
+	login := loginTestUser(t, "mover")
+
+	_, err := s.UpdateUserInfo(authContext(login.Token), &pbusers.UpdateUserInfoRequest{
+		UserID: id,
+		Email:  "moved@gmail.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to change email: %v", err)
+	}
+
+	usr, _ := s.db.GetUserByID(context.Background(), id)
+	if usr.Email != "mover@gmail.com" || usr.PendingEmail != "moved@gmail.com" {
+		t.Fatalf("Email was changed before it was verified: %v pending %v", usr.Email, usr.PendingEmail)
+	}
+
+	_, err = s.VerifyEmail(context.Background(), &pbusers.VerifyEmailRequest{
+		Token: verificationToken(t, mailer, "moved@gmail.com"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to verify new email: %v", err)
+	}
+
+	usr, _ = s.db.GetUserByID(context.Background(), id)
+	if usr.Email != "moved@gmail.com" || usr.PendingEmail != "" || !usr.EmailVerified {
+		t.Errorf("Verified email change was not applied: %v pending %v", usr.Email, usr.PendingEmail)
+	}
+
+	// the link for the old address no longer belongs to the user
+	_, err = s.VerifyEmail(context.Background(), &pbusers.VerifyEmailRequest{
+		Token: verificationToken(t, mailer, "mover@gmail.com"),
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Stale verification link was accepted: %v", err)
+	}
+}
+
+func Test_ShouldRequireVerifiedEmailToLogIn(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer), WithEmailVerificationPolicy(EmailVerificationRequired))
+
+	signUp(t, s, "unverified")
+
+	login := &pbusers.GetJWTTokenRequest{Username: "unverified", Password: "password"}
+
+	if _, err := s.GetJWTToken(context.Background(), login); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Unverified user could log in: %v", err)
+	}
+
+	if _, err := s.ResendVerificationEmail(context.Background(), &pbusers.ResendVerificationEmailRequest{Email: "unverified@gmail.com"}); err != nil {
+		t.Fatalf("Failed to resend verification email: %v", err)
+	}
+	if len(mailer.Sent()) != 2 {
+		t.Fatalf("Expected a second verification email, got %v emails", len(mailer.Sent()))
+	}
+
+	s.VerifyEmail(context.Background(), &pbusers.VerifyEmailRequest{Token: verificationToken(t, mailer, "unverified@gmail.com")})
+
+	if _, err := s.GetJWTToken(context.Background(), login); err != nil {
+		t.Errorf("Verified user could not log in: %v", err)
+	}
+}
+
+func Test_ShouldParseEmailVerificationPolicy(t *testing.T) {
+	if policy, err := ParseEmailVerificationPolicy("required"); err != nil || policy != EmailVerificationRequired {
+		t.Errorf("Failed to parse required policy: %v", err)
+	}
+	if _, err := ParseEmailVerificationPolicy("sometimes"); err == nil {
+		t.Errorf("Parsed an unknown policy")
+	}
+}
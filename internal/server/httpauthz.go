@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+)
+
+const (
+	// envoy removes the listed headers from the upstream request, the HTTP counterpart of HeadersToRemove
+	headersToRemoveHeader = "x-envoy-auth-headers-to-remove"
+
+	// set by nginx auth_request setups, whose subrequest has its own method and URI, only trusted with
+	// WithNginxAuthRequest
+	originalMethodHeader = "x-original-method"
+	originalURIHeader    = "x-original-uri"
+)
+
+// checkRequestFromHTTP - describe a request forwarded by an HTTP authorization proxy the way envoy's gRPC
+// ext_authz filter does, with lowercase header names. The original method and URI headers are only used when
+// nginxAuth is set, otherwise they are dropped like any other header a client could have sent.
+func checkRequestFromHTTP(r *http.Request, nginxAuth bool) *authv3.CheckRequest {
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	method := r.Method
+	path := r.URL.RequestURI()
+
+	if nginxAuth {
+		if original := r.Header.Get(originalMethodHeader); original != "" {
+			method = original
+		}
+		if original := r.Header.Get(originalURIHeader); original != "" {
+			path = original
+		}
+	} else {
+		delete(headers, originalMethodHeader)
+		delete(headers, originalURIHeader)
+	}
+
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Host:    r.Host,
+					Method:  method,
+					Path:    path,
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+// ServeAuthz - envoy's HTTP ext_authz service and nginx auth_request endpoint. Requests are decided by Check,
+// so both transports return the same decisions and upstream headers.
+//
+// Behind nginx the service must run with WithNginxAuthRequest, so the subrequest is decided by the method and URI
+// of the client's request instead of its own. Envoy sends the client's request itself and needs no such option.
+//
+// Envoy drops the identity headers a client sent itself through x-envoy-auth-headers-to-remove, which nginx
+// ignores. Under nginx every identity header has to be replaced with the value of the authorization response,
+// which is empty when it was not set, so nginx leaves the header out instead of passing on the client's:
+//
+//	location = /_kic_auth {
+//	    internal;
+//	    proxy_pass http://kic-users-service.kic.svc.cluster.local:8081;
+//	    proxy_pass_request_body off;
+//	    proxy_set_header content-length "";
+//	    proxy_set_header host $host;
+//	    proxy_set_header x-original-method $request_method;
+//	    proxy_set_header x-original-uri $request_uri;
+//	}
+//
+//	location / {
+//	    auth_request /_kic_auth;
+//	    auth_request_set $kic_user_id $upstream_http_x_kic_user_id;
+//	    auth_request_set $kic_username $upstream_http_x_kic_username;
+//	    auth_request_set $kic_roles $upstream_http_x_kic_roles;
+//	    auth_request_set $kic_service_account $upstream_http_x_kic_service_account;
+//	    proxy_set_header x-kic-user-id $kic_user_id;
+//	    proxy_set_header x-kic-username $kic_username;
+//	    proxy_set_header x-kic-roles $kic_roles;
+//	    proxy_set_header x-kic-service-account $kic_service_account;
+//	    proxy_pass http://upstream;
+//	}
+func (s *UsersService) ServeAuthz(w http.ResponseWriter, r *http.Request) {
+	check, err := s.Check(r.Context(), checkRequestFromHTTP(r, s.nginxAuth))
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if ok := check.GetOkResponse(); ok != nil {
+		for _, header := range ok.GetHeaders() {
+			w.Header().Set(header.GetHeader().GetKey(), header.GetHeader().GetValue())
+		}
+
+		if len(ok.GetHeadersToRemove()) > 0 {
+			w.Header().Set(headersToRemoveHeader, strings.Join(ok.GetHeadersToRemove(), ","))
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	denied := check.GetDeniedResponse()
+
+	for _, header := range denied.GetHeaders() {
+		w.Header().Set(header.GetHeader().GetKey(), header.GetHeader().GetValue())
+	}
+
+	w.WriteHeader(int(denied.GetStatus().GetCode()))
+	w.Write([]byte(denied.GetBody()))
+}
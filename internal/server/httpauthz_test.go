@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+)
+
+func serveAuthz(s *UsersService, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set(usernameHeader, "spoofed")
+
+	rec := httptest.NewRecorder()
+	s.ServeAuthz(rec, req)
+	return rec
+}
+
+func Test_ShouldAllowOverHTTPWithIdentityHeaders(t *testing.T) {
+	login := loginTestUser(t, "admin")
+
+	rec := serveAuthz(service, http.MethodGet, "/kic.users.Users/GetUserByID", login.Token)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %v", rec.Code, rec.Body.String())
+	}
+
+	grpcHeaders := upstreamHeaders(t, checkToken(login.Token))
+	for _, header := range []string{resultHeader, userIDHeader, usernameHeader, rolesHeader} {
+		if rec.Header().Get(header) != grpcHeaders[header] {
+			t.Errorf("HTTP %v header %q differs from gRPC %q", header, rec.Header().Get(header), grpcHeaders[header])
+		}
+	}
+}
+
+func Test_ShouldDenyOverHTTPLikeGRPC(t *testing.T) {
+	for _, token := range []string{"", "sdfasdfsdfasdf"} {
+		rec := serveAuthz(service, http.MethodGet, "/kic.users.Users/GetUserByID", token)
+
+		headers := map[string]string{}
+		if token != "" {
+			headers[authHeader] = "Bearer " + token
+		}
+		check, _ := service.Check(context.Background(), &authv3.CheckRequest{
+			Attributes: &authv3.AttributeContext{
+				Request: &authv3.AttributeContext_Request{
+					Http: &authv3.AttributeContext_HttpRequest{
+						Headers: headers,
+					},
+				},
+			},
+		})
+		denied := check.GetDeniedResponse()
+
+		if rec.Code != int(denied.GetStatus().GetCode()) {
+			t.Errorf("HTTP status %v differs from gRPC %v", rec.Code, denied.GetStatus().GetCode())
+		}
+		if rec.Body.String() != denied.GetBody() {
+			t.Errorf("HTTP body %q differs from gRPC %q", rec.Body.String(), denied.GetBody())
+		}
+		for _, header := range denied.GetHeaders() {
+			if rec.Header().Get(header.GetHeader().GetKey()) != header.GetHeader().GetValue() {
+				t.Errorf("HTTP %v header differs from gRPC", header.GetHeader().GetKey())
+			}
+		}
+	}
+}
+
+func Test_ShouldUseOriginalURIOverHTTP(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)), WithNginxAuthRequest(true))
+	addTestUser(t, "nginxuser")
+	login := loginTestUser(t, "nginxuser")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	req.Header.Set(originalMethodHeader, http.MethodGet)
+	req.Header.Set(originalURIHeader, "/admin/users")
+
+	rec := httptest.NewRecorder()
+	s.ServeAuthz(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the original URI, got %v", rec.Code)
+	}
+}
+
+func Test_ShouldIgnoreOriginalURIWithoutNginx(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+	addTestUser(t, "notnginx")
+	login := loginTestUser(t, "notnginx")
+
+	// a client naming a public path for its request to an admin one
+	req := httptest.NewRequest(http.MethodGet, "http://api.keeping-it-casual.com/admin/users", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	req.Header.Set(originalMethodHeader, http.MethodGet)
+	req.Header.Set(originalURIHeader, "/health")
+
+	rec := httptest.NewRecorder()
+	s.ServeAuthz(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for the requested URI, got %v", rec.Code)
+	}
+}
+
+func Test_ShouldRemoveSpoofedHeadersOverHTTP(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+
+	rec := serveAuthz(s, http.MethodGet, "http://api.keeping-it-casual.com/health", "")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for public path, got %v", rec.Code)
+	}
+
+	removed := rec.Header().Get(headersToRemoveHeader)
+	if !strings.Contains(removed, usernameHeader) || !strings.Contains(removed, userIDHeader) {
+		t.Errorf("Identity headers are not removed for anonymous requests: %q", removed)
+	}
+}
+
+// nginxUpstreamHeaders - the identity headers nginx passes upstream when configured as ServeAuthz describes, every
+// one of them replaced with the value from the authorization response
+func nginxUpstreamHeaders(s *UsersService, uri, credential string) (int, map[string]string) {
+	req := httptest.NewRequest(http.MethodGet, "/_kic_auth", nil)
+	req.Host = "api.keeping-it-casual.com"
+	req.Header.Set(originalMethodHeader, http.MethodGet)
+	req.Header.Set(originalURIHeader, uri)
+	if credential != "" {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
+	// nginx passes the client's headers on to the subrequest
+	for _, header := range []string{userIDHeader, usernameHeader, rolesHeader, serviceAccountHeader} {
+		req.Header.Set(header, "spoofed")
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeAuthz(rec, req)
+
+	upstream := map[string]string{}
+	for _, header := range []string{userIDHeader, usernameHeader, rolesHeader, serviceAccountHeader} {
+		if value := rec.Header().Get(header); value != "" {
+			upstream[header] = value
+		}
+	}
+	return rec.Code, upstream
+}
+
+func Test_ShouldNotPassSpoofedHeadersThroughNginx(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)), WithNginxAuthRequest(true))
+
+	code, upstream := nginxUpstreamHeaders(s, "/health", "")
+	if code != http.StatusOK || len(upstream) != 0 {
+		t.Errorf("Expected 200 without identity headers for an anonymous request, got %v with %v", code, upstream)
+	}
+
+	addTestUser(t, "nginxspoofer")
+	login := loginTestUser(t, "nginxspoofer")
+
+	code, upstream = nginxUpstreamHeaders(s, "/users", login.Token)
+	if code != http.StatusOK || upstream[usernameHeader] != "nginxspoofer" || upstream[userIDHeader] == "spoofed" {
+		t.Errorf("Expected the identity of the user, got %v with %v", code, upstream)
+	}
+	if _, ok := upstream[serviceAccountHeader]; ok {
+		t.Errorf("Passed a spoofed %v header for a user", serviceAccountHeader)
+	}
+}
@@ -0,0 +1,624 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	// JWKSPath - where the public signing keys are served over HTTP
+	JWKSPath = "/.well-known/jwks.json"
+
+	// DefaultKeyGracePeriod - how long a key removed from the key source is still accepted, tokens live for
+	// an hour so this keeps every token it signed valid until it expires
+	DefaultKeyGracePeriod = time.Hour
+
+	// the key ID given to the symmetric key read from SECRET_KEY
+	secretKeyID = "secret-key"
+
+	// name of the file in a key directory holding the ID of the key to sign with
+	activeKeyFile = "active"
+
+	// symmetric keys shorter than this can be brute forced from a single token
+	minSecretLength = 32
+	// bits of entropy estimateEntropy must find in a symmetric key. It only counts how often each character
+	// repeats, so a random 32 character base64 string, which holds 192 bits, is estimated at around 146 and now
+	// and then below 128. A 44 character key (32 random bytes in base64) is estimated at around 215.
+	minSecretEntropy = 128
+	// RSA keys smaller than this are considered breakable
+	minRSAKeyBits = 2048
+)
+
+// development secrets must start with one of these so they can be refused in production
+var developmentSecretPrefixes = []string{"dev-", "test-", "insecure-"}
+
+// secretKeyRing - a key ring holding the symmetric SECRET_KEY, refusing weak keys and development keys in production
+func secretKeyRing(secret string, production bool) (*KeyRing, error) {
+	if secret == "" {
+		return nil, errors.New("SECRET_KEY must be set when no signing keys are configured")
+	}
+
+	if production {
+		for _, prefix := range developmentSecretPrefixes {
+			if strings.HasPrefix(strings.ToLower(secret), prefix) {
+				return nil, fmt.Errorf("refusing to use a development SECRET_KEY (prefixed %q) in production", prefix)
+			}
+		}
+	}
+
+	key, err := jwk.New([]byte(secret))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECRET_KEY: %w", err)
+	}
+
+	if err := key.Set(jwk.KeyIDKey, secretKeyID); err != nil {
+		return nil, err
+	}
+
+	ring, err := NewStaticKeyRing(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECRET_KEY: %w", err)
+	}
+
+	return ring, nil
+}
+
+// estimateEntropy - Shannon entropy of the bytes in the secret multiplied by its length, an upper bound on
+// how hard the secret is to guess that catches short, repetitive and single character class secrets
+func estimateEntropy(secret []byte) float64 {
+	if len(secret) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range secret {
+		counts[b]++
+	}
+
+	perByte := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(len(secret))
+		perByte -= p * math.Log2(p)
+	}
+
+	return perByte * float64(len(secret))
+}
+
+// validateSecret - make sure a symmetric key is long and random enough to sign tokens with
+func validateSecret(secret []byte) error {
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("symmetric key must be at least %v bytes, got %v", minSecretLength, len(secret))
+	}
+
+	if bits := estimateEntropy(secret); bits < minSecretEntropy {
+		return fmt.Errorf("symmetric key is too predictable, estimated %.0f bits of entropy but need %v", bits, minSecretEntropy)
+	}
+
+	return nil
+}
+
+// validateKeyMaterial - make sure a key is strong enough and internally consistent before signing with it
+func validateKeyMaterial(key jwk.Key) error {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return err
+	}
+
+	switch k := raw.(type) {
+	case []byte:
+		return validateSecret(k)
+	case *rsa.PrivateKey:
+		if bits := k.N.BitLen(); bits < minRSAKeyBits {
+			return fmt.Errorf("RSA key must be at least %v bits, got %v", minRSAKeyBits, bits)
+		}
+		return k.Validate()
+	case *ecdsa.PrivateKey:
+		if !k.Curve.IsOnCurve(k.X, k.Y) {
+			return errors.New("ECDSA public key is not on its curve")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported key type %T", raw)
+}
+
+// signingKey - a key tokens are signed or verified with, along with the algorithm it is used with
+type signingKey struct {
+	id      string
+	alg     jwa.SignatureAlgorithm
+	private jwk.Key
+	verify  interface{}
+
+	// zero while the key is still present in the key source
+	retiredAt time.Time
+}
+
+func newSigningKey(id string, key jwk.Key) (*signingKey, error) {
+	alg, err := algorithmForKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateKeyMaterial(key); err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		if err := jwk.AssignKeyID(key); err != nil {
+			return nil, err
+		}
+		id = key.KeyID()
+	} else if err := key.Set(jwk.KeyIDKey, id); err != nil {
+		return nil, err
+	}
+
+	verify, err := verificationKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		id:      id,
+		alg:     alg,
+		private: key,
+		verify:  verify,
+	}, nil
+}
+
+// algorithmForKey - the JWA signature algorithm matching the type of a private key
+func algorithmForKey(key jwk.Key) (jwa.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case jwk.RSAPrivateKey:
+		return jwa.RS256, nil
+	case jwk.ECDSAPrivateKey:
+		switch k.Crv() {
+		case jwa.P256:
+			return jwa.ES256, nil
+		case jwa.P384:
+			return jwa.ES384, nil
+		case jwa.P521:
+			return jwa.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported curve %v", k.Crv())
+	case jwk.SymmetricKey:
+		return jwa.HS256, nil
+	}
+	return "", fmt.Errorf("unsupported key type %v", key.KeyType())
+}
+
+// verificationKey - the raw key tokens signed by the given key are verified with
+func verificationKey(key jwk.Key) (interface{}, error) {
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		return nil, err
+	}
+
+	if _, ok := key.(jwk.SymmetricKey); ok {
+		return raw, nil
+	}
+
+	return jwk.PublicRawKeyOf(raw)
+}
+
+// readKeyFile - PEM files hold RSA or ECDSA private keys, any other file holds a raw symmetric secret
+func readKeyFile(path string) (jwk.Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(path) == ".pem" {
+		key, err := jwk.ParseKey(data, jwk.WithPEM(true))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key %v: %w", path, err)
+		}
+		return key, nil
+	}
+
+	return jwk.New([]byte(strings.TrimSpace(string(data))))
+}
+
+// KeyRing - the keys tokens are signed with and verified against. Keys loaded from a directory or file can be
+// reloaded while the server runs, keys that disappear from it are still accepted for a grace period so rotating
+// the signing key does not log anyone out.
+type KeyRing struct {
+	mu sync.RWMutex
+
+	source   string
+	activeID string
+	grace    time.Duration
+
+	keys   map[string]*signingKey
+	active *signingKey
+
+	// fingerprint of the key source as of the last load, used to skip reloading when nothing changed
+	loaded string
+}
+
+// NewStaticKeyRing - a key ring holding a single key that is never reloaded
+func NewStaticKeyRing(key jwk.Key) (*KeyRing, error) {
+	k, err := newSigningKey(key.KeyID(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyRing{
+		keys:   map[string]*signingKey{k.id: k},
+		active: k,
+	}, nil
+}
+
+// LoadKeyRing - load signing keys from source, which is either a single PEM file or a directory of key files.
+// In a directory every "<kid>.pem" file is an RSA or ECDSA private key and every other "<kid>.<ext>" file a
+// symmetric secret. The key to sign with is the ID in the directory's "active" file, activeID when there is no
+// such file, and otherwise the key with the greatest ID so that date based IDs rotate in order.
+func LoadKeyRing(source, activeID string, grace time.Duration) (*KeyRing, error) {
+	k := &KeyRing{
+		source:   source,
+		activeID: activeID,
+		grace:    grace,
+		keys:     map[string]*signingKey{},
+	}
+
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// fingerprint - names, sizes and modification times of the files in the key source
+func (k *KeyRing) fingerprint() (string, error) {
+	info, err := os.Stat(k.source)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.IsDir() {
+		return fmt.Sprintf("%v:%v:%v", info.Name(), info.Size(), info.ModTime().UnixNano()), nil
+	}
+
+	files, err := ioutil.ReadDir(k.source)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&b, "%v:%v:%v;", f.Name(), f.Size(), f.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// read - load every key in the key source and work out which one is active
+func (k *KeyRing) read() (map[string]*signingKey, string, error) {
+	info, err := os.Stat(k.source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := map[string]*signingKey{}
+
+	if !info.IsDir() {
+		key, err := readKeyFile(k.source)
+		if err != nil {
+			return nil, "", err
+		}
+		// a single file has no name to use as an ID, so the thumbprint of the key is used instead
+		sk, err := newSigningKey("", key)
+		if err != nil {
+			return nil, "", err
+		}
+		keys[sk.id] = sk
+		return keys, sk.id, nil
+	}
+
+	files, err := ioutil.ReadDir(k.source)
+	if err != nil {
+		return nil, "", err
+	}
+
+	activeID := k.activeID
+	var ids []string
+
+	for _, f := range files {
+		name := f.Name()
+		// skip directories and the hidden ..data links kubernetes uses when mounting secrets
+		if f.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		path := filepath.Join(k.source, name)
+
+		if name == activeKeyFile {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, "", err
+			}
+			activeID = strings.TrimSpace(string(data))
+			continue
+		}
+
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+
+		id := strings.TrimSuffix(name, filepath.Ext(name))
+		sk, err := newSigningKey(id, key)
+		if err != nil {
+			return nil, "", fmt.Errorf("unsupported signing key %v: %w", path, err)
+		}
+		keys[id] = sk
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil, "", fmt.Errorf("no signing keys found in %v", k.source)
+	}
+
+	if activeID == "" {
+		sort.Strings(ids)
+		activeID = ids[len(ids)-1]
+	}
+
+	return keys, activeID, nil
+}
+
+// Reload - read the key source again, keys that are no longer in it are kept for the grace period
+func (k *KeyRing) Reload() error {
+	if k.source == "" {
+		return nil
+	}
+
+	fingerprint, err := k.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	keys, activeID, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	active, ok := keys[activeID]
+	if !ok {
+		return fmt.Errorf("active signing key %v not found in %v", activeID, k.source)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	for id, old := range k.keys {
+		if _, ok := keys[id]; ok {
+			continue
+		}
+		if old.retiredAt.IsZero() {
+			old.retiredAt = now
+		}
+		if now.Before(old.retiredAt.Add(k.grace)) {
+			keys[id] = old
+		}
+	}
+
+	k.keys = keys
+	k.active = active
+	k.loaded = fingerprint
+
+	return nil
+}
+
+// Watch - reload the key source every interval until the context is cancelled, a failed reload keeps the
+// keys that were already loaded
+func (k *KeyRing) Watch(ctx context.Context, interval time.Duration, logger *zap.SugaredLogger) {
+	if k.source == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fingerprint, err := k.fingerprint()
+		if err != nil {
+			logger.Errorf("Failed to check signing keys in %v: %v", k.source, err)
+			continue
+		}
+
+		k.mu.RLock()
+		changed := fingerprint != k.loaded
+		k.mu.RUnlock()
+
+		if !changed {
+			k.pruneRetired()
+			continue
+		}
+
+		if err := k.Reload(); err != nil {
+			logger.Errorf("Failed to reload signing keys from %v: %v", k.source, err)
+			continue
+		}
+
+		logger.Infof("Reloaded signing keys from %v, signing with key %v", k.source, k.signer().id)
+	}
+}
+
+// pruneRetired - drop retired keys whose grace period is over
+func (k *KeyRing) pruneRetired() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	for id, key := range k.keys {
+		if !key.retiredAt.IsZero() && !now.Before(key.retiredAt.Add(k.grace)) {
+			delete(k.keys, id)
+		}
+	}
+}
+
+// signer - the key new tokens are signed with
+func (k *KeyRing) signer() *signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.active
+}
+
+// verifierFor - find the key a token has to be verified with from the kid in its header
+func (k *KeyRing) verifierFor(payload []byte) (*signingKey, error) {
+	msg, err := jws.Parse(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(msg.Signatures()) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+
+	headers := msg.Signatures()[0].ProtectedHeaders()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	kid := headers.KeyID()
+	var key *signingKey
+
+	if kid == "" {
+		// tokens issued before keys had IDs were signed with the symmetric SECRET_KEY
+		if k.active.alg != jwa.HS256 {
+			return nil, errors.New("token has no key ID")
+		}
+		key = k.active
+	} else {
+		found, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %v", kid)
+		}
+		if !found.retiredAt.IsZero() && !time.Now().Before(found.retiredAt.Add(k.grace)) {
+			return nil, fmt.Errorf("signing key %v has been retired", kid)
+		}
+		key = found
+	}
+
+	// never let the token choose the algorithm, otherwise a public key could be used as an HMAC secret
+	if headers.Algorithm() != key.alg {
+		return nil, fmt.Errorf("token algorithm %v does not match key %v", headers.Algorithm(), key.id)
+	}
+
+	return key, nil
+}
+
+// publicKeys - the public halves of every asymmetric key still accepted, symmetric keys are never published
+func (k *KeyRing) publicKeys() (jwk.Set, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := jwk.NewSet()
+
+	for _, id := range ids {
+		key := k.keys[id]
+		if key.alg == jwa.HS256 {
+			continue
+		}
+
+		public, err := jwk.PublicKeyOf(key.private)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := public.Set(jwk.AlgorithmKey, key.alg); err != nil {
+			return nil, err
+		}
+
+		if err := public.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+			return nil, err
+		}
+
+		set.Add(public)
+	}
+
+	return set, nil
+}
+
+// PublicKeySet - the public keys other services can verify tokens with
+func (s *UsersService) PublicKeySet() (jwk.Set, error) {
+	return s.keys.publicKeys()
+}
+
+func (s *UsersService) GetJWKS(ctx context.Context, req *pbusers.GetJWKSRequest) (*pbusers.GetJWKSResponse, error) {
+	set, err := s.PublicKeySet()
+
+	if err != nil {
+		s.logger.Errorf("Failed to build public key set: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not get public keys")
+	}
+
+	keys, err := json.Marshal(set)
+
+	if err != nil {
+		s.logger.Errorf("Failed to encode public key set: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not get public keys")
+	}
+
+	return &pbusers.GetJWKSResponse{
+		Keys: string(keys),
+	}, nil
+}
+
+// ServeJWKS - serve the public signing keys as a JSON Web Key Set
+func (s *UsersService) ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := s.GetJWKS(r.Context(), &pbusers.GetJWKSRequest{})
+
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write([]byte(resp.Keys))
+}
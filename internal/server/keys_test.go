@@ -0,0 +1,394 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/logging"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	dir, err := ioutil.TempDir("", "kic-users-keys")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "signing.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+// verifyWithJWKS - verify a token the way another service would, using only the published key set
+func verifyWithJWKS(t *testing.T, s *UsersService, token string) jwt.Token {
+	resp, err := s.GetJWKS(context.Background(), &pbusers.GetJWKSRequest{})
+	if err != nil {
+		t.Fatalf("Failed to get JWKS: %v", err)
+	}
+
+	set, err := jwk.ParseString(resp.Keys)
+	if err != nil {
+		t.Fatalf("Failed to parse JWKS: %v", err)
+	}
+
+	key, ok := set.Get(0)
+	if !ok {
+		t.Fatalf("JWKS is empty")
+	}
+
+	if _, ok := key.Get("d"); ok {
+		t.Errorf("JWKS contains private key material")
+	}
+
+	var raw interface{}
+	if err := key.Raw(&raw); err != nil {
+		t.Fatalf("Failed to get raw key from JWKS: %v", err)
+	}
+
+	tok, err := jwt.Parse([]byte(token), jwt.WithVerify(jwa.SignatureAlgorithm(key.Algorithm()), raw))
+	if err != nil {
+		t.Fatalf("Failed to verify token with published key: %v", err)
+	}
+	return tok
+}
+
+func Test_ShouldSignWithRSAKey(t *testing.T) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys, err := LoadKeyRing(writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv)), "", DefaultKeyGracePeriod)
+	if err != nil {
+		t.Fatalf("Failed to load signing key: %v", err)
+	}
+	s := newTestService(t, WithKeyRing(keys))
+
+	if alg := s.keys.signer().alg; alg != jwa.RS256 {
+		t.Errorf("Expected RS256 for an RSA key, got %v", alg)
+	}
+
+	token, err := s.GenerateJWT(42, "tester", []string{database.RoleUser})
+	if err != nil {
+		t.Fatalf("Failed to sign token with RSA key: %v", err)
+	}
+
+	if _, err := s.DecodeJWT(token); err != nil {
+		t.Errorf("Failed to decode token signed with RSA key: %v", err)
+	}
+
+	tok := verifyWithJWKS(t, s, token)
+	if uid, _ := tok.Get("uid"); uid != "42" {
+		t.Errorf("Token verified with JWKS has wrong uid %v", uid)
+	}
+}
+
+func Test_ShouldSignWithECDSAKey(t *testing.T) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	keys, err := LoadKeyRing(writePEM(t, "PRIVATE KEY", der), "", DefaultKeyGracePeriod)
+	if err != nil {
+		t.Fatalf("Failed to load signing key: %v", err)
+	}
+	s := newTestService(t, WithKeyRing(keys))
+
+	if alg := s.keys.signer().alg; alg != jwa.ES256 {
+		t.Errorf("Expected ES256 for a P-256 key, got %v", alg)
+	}
+
+	token, err := s.GenerateJWT(7, "tester", []string{database.RoleUser})
+	if err != nil {
+		t.Fatalf("Failed to sign token with ECDSA key: %v", err)
+	}
+
+	verifyWithJWKS(t, s, token)
+}
+
+func Test_ShouldNotPublishSymmetricKey(t *testing.T) {
+	resp, err := service.GetJWKS(context.Background(), &pbusers.GetJWKSRequest{})
+	if err != nil {
+		t.Fatalf("Failed to get JWKS: %v", err)
+	}
+
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal([]byte(resp.Keys), &doc); err != nil {
+		t.Fatalf("JWKS is not valid JSON: %v", err)
+	}
+	if len(doc.Keys) != 0 {
+		t.Errorf("Symmetric signing key was published in JWKS")
+	}
+}
+
+func Test_ShouldServeJWKSOverHTTP(t *testing.T) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys, err := LoadKeyRing(writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(priv)), "", DefaultKeyGracePeriod)
+	if err != nil {
+		t.Fatalf("Failed to load signing key: %v", err)
+	}
+	s := newTestService(t, WithKeyRing(keys))
+
+	rec := httptest.NewRecorder()
+	s.ServeJWKS(rec, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from JWKS endpoint, got %v", rec.Code)
+	}
+
+	set, err := jwk.Parse(rec.Body.Bytes())
+	if err != nil || set.Len() != 1 {
+		t.Errorf("JWKS endpoint did not return a single key: %v", err)
+	}
+}
+
+func Test_ShouldFailLoadingGarbageKey(t *testing.T) {
+	if _, err := LoadKeyRing(writePEM(t, "PRIVATE KEY", []byte("garbage")), "", DefaultKeyGracePeriod); err == nil {
+		t.Errorf("Loaded a signing key from garbage")
+	}
+}
+
+func writeRSAKey(t *testing.T, dir, name string) {
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func newKeyDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kic-users-keyring")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	msg, err := jws.Parse([]byte(token))
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	return msg.Signatures()[0].ProtectedHeaders().KeyID()
+}
+
+func Test_ShouldSignWithGreatestKeyID(t *testing.T) {
+	dir := newKeyDir(t)
+	writeRSAKey(t, dir, "2021-01.pem")
+	writeRSAKey(t, dir, "2021-02.pem")
+
+	keys, err := LoadKeyRing(dir, "", DefaultKeyGracePeriod)
+	if err != nil {
+		t.Fatalf("Failed to load key ring: %v", err)
+	}
+	s := newTestService(t, WithKeyRing(keys))
+
+	token, _ := s.GenerateJWT(1, "tester", []string{database.RoleUser})
+	if kid := tokenKeyID(t, token); kid != "2021-02" {
+		t.Errorf("Expected token to be signed with key 2021-02, got %v", kid)
+	}
+
+	set, _ := s.PublicKeySet()
+	if set.Len() != 2 {
+		t.Errorf("Expected both keys to be published, got %v", set.Len())
+	}
+}
+
+func Test_ShouldRotateSigningKey(t *testing.T) {
+	dir := newKeyDir(t)
+	writeRSAKey(t, dir, "old.pem")
+	ioutil.WriteFile(filepath.Join(dir, activeKeyFile), []byte("old\n"), 0600)
+
+	keys, err := LoadKeyRing(dir, "", DefaultKeyGracePeriod)
+	if err != nil {
+		t.Fatalf("Failed to load key ring: %v", err)
+	}
+	s := newTestService(t, WithKeyRing(keys))
+
+	oldToken, _ := s.GenerateJWT(1, "tester", []string{database.RoleUser})
+
+	// publish the new key, switch to it and then drop the old one
+	writeRSAKey(t, dir, "new.pem")
+	ioutil.WriteFile(filepath.Join(dir, activeKeyFile), []byte("new\n"), 0600)
+	os.Remove(filepath.Join(dir, "old.pem"))
+
+	if err := keys.Reload(); err != nil {
+		t.Fatalf("Failed to reload key ring: %v", err)
+	}
+
+	newToken, _ := s.GenerateJWT(1, "tester", []string{database.RoleUser})
+	if kid := tokenKeyID(t, newToken); kid != "new" {
+		t.Errorf("Expected token to be signed with the new key, got %v", kid)
+	}
+
+	if _, err := s.DecodeJWT(oldToken); err != nil {
+		t.Errorf("Token signed with a key in its grace period was rejected: %v", err)
+	}
+
+	if _, err := s.DecodeJWT(newToken); err != nil {
+		t.Errorf("Token signed with the new key was rejected: %v", err)
+	}
+}
+
+func Test_ShouldRejectKeyAfterGracePeriod(t *testing.T) {
+	dir := newKeyDir(t)
+	writeRSAKey(t, dir, "a.pem")
+
+	keys, err := LoadKeyRing(dir, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to load key ring: %v", err)
+	}
+	s := newTestService(t, WithKeyRing(keys))
+
+	token, _ := s.GenerateJWT(1, "tester", []string{database.RoleUser})
+
+	writeRSAKey(t, dir, "b.pem")
+	os.Remove(filepath.Join(dir, "a.pem"))
+	keys.Reload()
+
+	if _, err := s.DecodeJWT(token); err == nil {
+		t.Errorf("Token signed with a removed key was accepted after the grace period")
+	}
+}
+
+func Test_ShouldFailWithMissingActiveKey(t *testing.T) {
+	dir := newKeyDir(t)
+	writeRSAKey(t, dir, "a.pem")
+
+	if _, err := LoadKeyRing(dir, "b", DefaultKeyGracePeriod); err == nil {
+		t.Errorf("Loaded a key ring whose active key does not exist")
+	}
+}
+
+func Test_ShouldAcceptLegacyTokenWithoutKeyID(t *testing.T) {
+	tok := jwt.New()
+	tok.Set(jwt.ExpirationKey, time.Now().Add(time.Hour))
+	tok.Set("uid", "0")
+
+	signed, err := jwt.Sign(tok, jwa.HS256, []byte(os.Getenv("SECRET_KEY")))
+	if err != nil {
+		t.Fatalf("Failed to sign legacy token: %v", err)
+	}
+
+	if _, err := service.DecodeJWT(string(signed)); err != nil {
+		t.Errorf("Legacy token without a key ID was rejected: %v", err)
+	}
+}
+
+func Test_ShouldRejectAlgorithmMismatch(t *testing.T) {
+	dir := newKeyDir(t)
+	writeRSAKey(t, dir, "rsa.pem")
+
+	keys, _ := LoadKeyRing(dir, "", DefaultKeyGracePeriod)
+	s := newTestService(t, WithKeyRing(keys))
+
+	// sign an HS256 token using the published public key as the secret, claiming the RSA key's ID
+	set, _ := s.PublicKeySet()
+	public, _ := set.Get(0)
+	secret, _ := jwk.Pem(public)
+
+	hdrs := jws.NewHeaders()
+	hdrs.Set(jws.KeyIDKey, "rsa")
+
+	tok := jwt.New()
+	tok.Set(jwt.ExpirationKey, time.Now().Add(time.Hour))
+	tok.Set("uid", "0")
+
+	signed, _ := jwt.Sign(tok, jwa.HS256, secret, jwt.WithHeaders(hdrs))
+
+	if _, err := s.DecodeJWT(string(signed)); err == nil {
+		t.Errorf("Accepted an HS256 token claiming to be signed by an RSA key")
+	}
+}
+
+func Test_ShouldRefuseMissingSecretKey(t *testing.T) {
+	if _, err := secretKeyRing("", false); err == nil {
+		t.Errorf("Created a key ring without a secret key")
+	}
+}
+
+func Test_ShouldRefuseShortSecretKey(t *testing.T) {
+	if _, err := secretKeyRing("supersecret", false); err == nil {
+		t.Errorf("Created a key ring with a short secret key")
+	}
+}
+
+func Test_ShouldRefuseLowEntropySecretKey(t *testing.T) {
+	if _, err := secretKeyRing("abababababababababababababababababababab", false); err == nil {
+		t.Errorf("Created a key ring with a repetitive secret key")
+	}
+}
+
+func Test_ShouldRefuseDevelopmentSecretKeyInProduction(t *testing.T) {
+	secret := os.Getenv("SECRET_KEY")
+
+	if _, err := secretKeyRing(secret, false); err != nil {
+		t.Errorf("Refused the development secret key outside production: %v", err)
+	}
+
+	if _, err := secretKeyRing(secret, true); err == nil {
+		t.Errorf("Accepted a development secret key in production")
+	}
+}
+
+func Test_ShouldAcceptStrongSecretKeyInProduction(t *testing.T) {
+	secret, _ := newOpaqueToken(48)
+
+	if _, err := secretKeyRing(secret, true); err != nil {
+		t.Errorf("Refused a strong random secret key in production: %v", err)
+	}
+}
+
+func Test_ShouldFailServiceWithoutSecretKey(t *testing.T) {
+	secret := os.Getenv("SECRET_KEY")
+	os.Unsetenv("SECRET_KEY")
+	defer os.Setenv("SECRET_KEY", secret)
+
+	logger := logging.CreateLogger(zapcore.DebugLevel)
+	repo := database.NewMockRepository(map[uint]*database.UserModel{}, logger)
+
+	if _, err := NewUsersService(repo, logger); err == nil {
+		t.Errorf("Created a users service without a secret key")
+	}
+}
+
+func Test_ShouldRefuseSmallRSAKey(t *testing.T) {
+	dir := newKeyDir(t)
+	priv, _ := rsa.GenerateKey(rand.Reader, 1024)
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	ioutil.WriteFile(filepath.Join(dir, "small.pem"), data, 0600)
+
+	if _, err := LoadKeyRing(dir, "", DefaultKeyGracePeriod); err == nil {
+		t.Errorf("Loaded a 1024 bit RSA key")
+	}
+}
+
+func Test_ShouldRefuseWeakSymmetricKeyFile(t *testing.T) {
+	dir := newKeyDir(t)
+	ioutil.WriteFile(filepath.Join(dir, "weak.key"), []byte("password"), 0600)
+
+	if _, err := LoadKeyRing(dir, "", DefaultKeyGracePeriod); err == nil {
+		t.Errorf("Loaded a weak symmetric key")
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const forwardedForHeader = "x-forwarded-for"
+
+// LockoutPolicy - how failed logins slow down and then lock out further attempts
+type LockoutPolicy struct {
+	// failed logins of one username before it is locked out
+	UserMaxFailures int
+	// failed logins from one client IP before it is locked out, higher since users can share an address
+	IPMaxFailures int
+	// wait after the first failure, doubled by every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// how long a lockout lasts, failures older than this are forgotten
+	LockoutDuration time.Duration
+}
+
+// DefaultLockoutPolicy - the lockout policy unless WithLockoutPolicy is used
+var DefaultLockoutPolicy = LockoutPolicy{
+	UserMaxFailures: 5,
+	IPMaxFailures:   50,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutDuration: 15 * time.Minute,
+}
+
+// backoff - how long to wait after the given number of consecutive failures
+func (p LockoutPolicy) backoff(failures int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// userSubject and ipSubject - the keys failed logins are counted under
+func userSubject(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipSubject(ip string) string {
+	return "ip:" + ip
+}
+
+// clientIP - the address a call came from, as seen by the ingress proxy if there is one
+func clientIP(ctx context.Context) string {
+	if headers, ok := metadata.FromIncomingContext(ctx); ok {
+		if forwarded := headers.Get(forwardedForHeader); len(forwarded) > 0 {
+			// the last address was appended by our ingress proxy, the ones before it are up to the client
+			addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+
+	return ""
+}
+
+// loginSubjects - the username and client IP of a login attempt
+func loginSubjects(ctx context.Context, username string) []string {
+	subjects := []string{userSubject(username)}
+
+	if ip := clientIP(ctx); ip != "" {
+		subjects = append(subjects, ipSubject(ip))
+	}
+
+	return subjects
+}
+
+// tooManyAttempts - the error for logins refused until the given time, with a RetryInfo detail for clients
+func tooManyAttempts(retryAt, now time.Time) error {
+	st := status.New(codes.ResourceExhausted, "Too many failed logins, try again later")
+
+	if withRetry, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(retryAt.Sub(now).Round(time.Second)),
+	}); err == nil {
+		st = withRetry
+	}
+
+	return st.Err()
+}
+
+// checkLoginAllowed - refuse logins of locked out subjects and of subjects still backing off after a failure
+func (s *UsersService) checkLoginAllowed(ctx context.Context, subjects []string) error {
+	now := s.clock()
+
+	for _, subject := range subjects {
+		attempts, err := s.loginAttempts.GetLoginAttempts(ctx, subject)
+
+		if err != nil {
+			s.logger.Errorf("Failed to get login attempts of %v: %v", subject, err)
+			return status.Errorf(codes.Internal, "Could not log in")
+		}
+
+		if now.Before(attempts.LockedUntil) {
+			return tooManyAttempts(attempts.LockedUntil, now)
+		}
+
+		if attempts.Failures == 0 || attempts.LastFailure.Before(now.Add(-s.lockout.LockoutDuration)) {
+			continue
+		}
+
+		if retryAt := attempts.LastFailure.Add(s.lockout.backoff(attempts.Failures)); now.Before(retryAt) {
+			return tooManyAttempts(retryAt, now)
+		}
+	}
+
+	return nil
+}
+
+// recordLoginFailure - count a failed login against every subject and lock out those with too many failures
+func (s *UsersService) recordLoginFailure(ctx context.Context, subjects []string) {
+	now := s.clock()
+
+	for _, subject := range subjects {
+		attempts, err := s.loginAttempts.RecordLoginFailure(ctx, subject, now, now.Add(-s.lockout.LockoutDuration))
+
+		if err != nil {
+			s.logger.Errorf("Failed to record failed login of %v: %v", subject, err)
+			continue
+		}
+
+		max := s.lockout.UserMaxFailures
+		if strings.HasPrefix(subject, "ip:") {
+			max = s.lockout.IPMaxFailures
+		}
+
+		if attempts.Failures < max {
+			continue
+		}
+
+		s.logger.Warnf("Locking out %v after %v failed logins", subject, attempts.Failures)
+
+		err = s.loginAttempts.LockLogin(ctx, subject, now.Add(s.lockout.LockoutDuration))
+
+		if err != nil {
+			s.logger.Errorf("Failed to lock out %v: %v", subject, err)
+		}
+	}
+}
+
+func (s *UsersService) UnlockAccount(ctx context.Context, req *pbusers.UnlockAccountRequest) (*pbusers.UnlockAccountResponse, error) {
+	if _, err := s.authorizeAdmin(ctx); err != nil {
+		return &pbusers.UnlockAccountResponse{
+			Success: false,
+		}, err
+	}
+
+	usr, err := s.db.GetUserByID(ctx, req.UserID)
+
+	if err != nil {
+		return &pbusers.UnlockAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "User not found")
+	}
+
+	err = s.loginAttempts.ResetLoginAttempts(ctx, userSubject(usr.Username))
+
+	if err != nil {
+		s.logger.Errorf("Failed to unlock user %v: %v", req.UserID, err)
+		return &pbusers.UnlockAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not unlock account")
+	}
+
+	return &pbusers.UnlockAccountResponse{
+		Success: true,
+	}, nil
+}
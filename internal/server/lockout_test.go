@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// testLockoutPolicy - locks out after a few failures, with backoffs tests wait out by advancing a testClock
+var testLockoutPolicy = LockoutPolicy{
+	UserMaxFailures: 3,
+	IPMaxFailures:   4,
+	BaseDelay:       10 * time.Millisecond,
+	MaxDelay:        20 * time.Millisecond,
+	LockoutDuration: time.Hour,
+}
+
+func tryLogin(s *UsersService, ctx context.Context, username, password string) error {
+	_, err := s.GetJWTToken(ctx, &pbusers.GetJWTTokenRequest{
+		Username: username,
+		Password: password,
+	})
+	return err
+}
+
+func Test_ShouldBackOffAfterFailedLogin(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "backoff")
+
+	tryLogin(s, context.Background(), "backoff", "wrong")
+
+	err := tryLogin(s, context.Background(), "backoff", "password")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Retrying immediately after a failure was not refused: %v", err)
+	}
+
+	retry := false
+	for _, detail := range status.Convert(err).Details() {
+		_, ok := detail.(*errdetails.RetryInfo)
+		retry = retry || ok
+	}
+	if !retry {
+		t.Errorf("Refused login has no RetryInfo")
+	}
+
+	clock.advance(20 * time.Millisecond)
+
+	if err := tryLogin(s, context.Background(), "backoff", "password"); err != nil {
+		t.Errorf("Login after the backoff failed: %v", err)
+	}
+}
+
+func Test_ShouldLockOutAccountUntilUnlocked(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy), WithClock(clock.Now))
+	id := addTestUser(t, "lockme")
+
+	for i := 0; i < 3; i++ {
+		tryLogin(s, context.Background(), "lockme", "wrong")
+		clock.advance(25 * time.Millisecond)
+	}
+
+	if err := tryLogin(s, context.Background(), "LockMe", "password"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Locked out account could log in: %v", err)
+	}
+
+	addTestUser(t, "notanunlocker")
+	user := loginTestUser(t, "notanunlocker")
+
+	_, err := s.UnlockAccount(authContext(user.Token), &pbusers.UnlockAccountRequest{UserID: id})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Regular user unlocked an account: %v", err)
+	}
+
+	admin := loginTestUser(t, "admin")
+
+	res, err := s.UnlockAccount(authContext(admin.Token), &pbusers.UnlockAccountRequest{UserID: id})
+	if err != nil || !res.Success {
+		t.Fatalf("Admin failed to unlock account: %v", err)
+	}
+
+	if err := tryLogin(s, context.Background(), "lockme", "password"); err != nil {
+		t.Errorf("Unlocked account could not log in: %v", err)
+	}
+}
+
+func Test_ShouldLockOutClientIP(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "sharedip")
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(forwardedForHeader, "1.2.3.4, 203.0.113.9"))
+
+	for i := 0; i < 4; i++ {
+		tryLogin(s, ctx, "nosuchuser"+string(rune('a'+i)), "wrong")
+		clock.advance(25 * time.Millisecond)
+	}
+
+	if err := tryLogin(s, ctx, "sharedip", "password"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Login from locked out IP was not refused: %v", err)
+	}
+
+	other := metadata.NewIncomingContext(context.Background(), metadata.Pairs(forwardedForHeader, "203.0.113.10"))
+	if err := tryLogin(s, other, "sharedip", "password"); err != nil {
+		t.Errorf("Login from another IP failed: %v", err)
+	}
+}
+
+func Test_ShouldFindClientIP(t *testing.T) {
+	forwarded := metadata.NewIncomingContext(context.Background(), metadata.Pairs(forwardedForHeader, "6.6.6.6, 10.1.2.3"))
+	if ip := clientIP(forwarded); ip != "10.1.2.3" {
+		t.Errorf("Expected the address added by the proxy, got %v", ip)
+	}
+
+	fromPeer := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.4.5.6"), Port: 5000}})
+	if ip := clientIP(fromPeer); ip != "10.4.5.6" {
+		t.Errorf("Expected the peer address, got %v", ip)
+	}
+}
+
+func Test_ShouldCapLoginBackoff(t *testing.T) {
+	policy := LockoutPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for failures, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		if got := policy.backoff(failures); got != want {
+			t.Errorf("Expected backoff %v after %v failures, got %v", want, failures, got)
+		}
+	}
+}
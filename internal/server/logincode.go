@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/mail"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	loginCodeDigits   = 6
+	loginCodeLifetime = 10 * time.Minute
+	// wrong guesses before a code is given up on and a new one has to be requested
+	loginCodeMaxAttempts = 5
+)
+
+const loginCodeBody = `Hi %v,
+
+Your KIC login code is:
+
+%v
+
+It can be used once and expires in %v. If you did not ask for it you can ignore this email.
+`
+
+// Notifier - delivers one time login codes to users, by email unless WithNotifier is used so other channels
+// like SMS can be added
+type Notifier interface {
+	NotifyLoginCode(ctx context.Context, user *database.UserModel, code string, expiresIn time.Duration) error
+}
+
+// mailNotifier - emails login codes through the mailer of the service
+type mailNotifier struct {
+	mailer mail.Mailer
+}
+
+func (n *mailNotifier) NotifyLoginCode(ctx context.Context, user *database.UserModel, code string, expiresIn time.Duration) error {
+	return n.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: "Your KIC login code",
+		Body:    fmt.Sprintf(loginCodeBody, user.Username, code, expiresIn),
+	})
+}
+
+// newLoginCode - a random numeric code short enough to type from another device
+func newLoginCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < loginCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", loginCodeDigits, n), nil
+}
+
+// hashLoginCode - codes are short enough for two users to get the same one, hashing the user ID in keeps the
+// stored hashes unique
+func hashLoginCode(userID uint, code string) string {
+	return hashToken(fmt.Sprintf("%v:%v", userID, strings.TrimSpace(code)))
+}
+
+func (s *UsersService) RequestLoginCode(ctx context.Context, req *pbusers.RequestLoginCodeRequest) (*pbusers.RequestLoginCodeResponse, error) {
+	if req.Email == "" {
+		return &pbusers.RequestLoginCodeResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Email is required")
+	}
+
+	usr, err := s.db.GetUserByEmail(ctx, req.Email)
+
+	// the response must not tell which addresses have accounts
+	if err != nil {
+		s.logger.Debugf("No user to send a login code with email %v: %v", req.Email, err)
+		return &pbusers.RequestLoginCodeResponse{
+			Success: true,
+		}, nil
+	}
+
+	code, err := newLoginCode()
+
+	if err != nil {
+		s.logger.Errorf("Failed to generate login code: %v", err)
+		return &pbusers.RequestLoginCodeResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not send login code")
+	}
+
+	// only the newest code works, so guesses cannot be spread over several codes
+	err = s.oneTimeTokens.RevokeUserOneTimeTokens(ctx, usr.ID, database.PurposeLoginCode)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke login codes of user %v: %v", usr.ID, err)
+		return &pbusers.RequestLoginCodeResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not send login code")
+	}
+
+	err = s.oneTimeTokens.AddOneTimeToken(ctx, &database.OneTimeTokenModel{
+		UserID:    usr.ID,
+		Purpose:   database.PurposeLoginCode,
+		TokenHash: hashLoginCode(usr.ID, code),
+		ExpiresAt: time.Now().Add(loginCodeLifetime),
+	})
+
+	if err != nil {
+		s.logger.Errorf("Failed to store login code of user %v: %v", usr.ID, err)
+		return &pbusers.RequestLoginCodeResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not send login code")
+	}
+
+	err = s.notifier.NotifyLoginCode(ctx, usr, code, loginCodeLifetime)
+
+	if err != nil {
+		s.logger.Errorf("Failed to send login code to user %v: %v", usr.ID, err)
+		return &pbusers.RequestLoginCodeResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not send login code")
+	}
+
+	return &pbusers.RequestLoginCodeResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *UsersService) LoginWithCode(ctx context.Context, req *pbusers.LoginWithCodeRequest) (*pbusers.LoginWithCodeResponse, error) {
+	usr, lookupErr := s.db.GetUserByEmail(ctx, req.Email)
+
+	// failures count towards the same lockout as wrong passwords
+	subject := req.Email
+	if lookupErr == nil {
+		subject = usr.Username
+	}
+	subjects := loginSubjects(ctx, subject)
+
+	if err := s.checkLoginAllowed(ctx, subjects); err != nil {
+		s.logger.Debugf("Refusing code login of %v: %v", req.Email, err)
+		return nil, err
+	}
+
+	if lookupErr != nil {
+		s.logger.Debugf("No user to log in with email %v: %v", req.Email, lookupErr)
+		s.recordLoginFailure(ctx, subjects)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid or expired code")
+	}
+
+	_, err := s.oneTimeTokens.UseOneTimeToken(ctx, database.PurposeLoginCode, hashLoginCode(usr.ID, req.Code))
+
+	if errors.Is(err, database.ErrOneTimeTokenInvalid) {
+		s.logger.Debugf("Invalid login code for user %v", usr.ID)
+		s.recordLoginFailure(ctx, subjects)
+
+		if err := s.oneTimeTokens.RecordOneTimeTokenFailure(ctx, usr.ID, database.PurposeLoginCode, loginCodeMaxAttempts); err != nil {
+			s.logger.Errorf("Failed to record wrong login code of user %v: %v", usr.ID, err)
+		}
+
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid or expired code")
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to use login code: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not log in")
+	}
+
+	// receiving the code proves the user controls the address
+	if !usr.EmailVerified {
+		if err := s.db.ConfirmEmail(ctx, int64(usr.ID), usr.Email); err != nil {
+			s.logger.Errorf("Failed to mark email of user %v as verified: %v", usr.ID, err)
+		} else {
+			usr.EmailVerified = true
+		}
+	}
+
+	login, err := s.finishLogin(ctx, usr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbusers.LoginWithCodeResponse{
+		Token:             login.Token,
+		RefreshToken:      login.RefreshToken,
+		MfaRequired:       login.MfaRequired,
+		MfaChallengeToken: login.MfaChallengeToken,
+	}, nil
+}
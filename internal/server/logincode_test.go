@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/mail"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// capturingNotifier - keeps the last login code of every user instead of sending it
+type capturingNotifier struct {
+	codes map[string]string
+}
+
+func (n *capturingNotifier) NotifyLoginCode(ctx context.Context, user *database.UserModel, code string, expiresIn time.Duration) error {
+	n.codes[user.Email] = code
+	return nil
+}
+
+func newCapturingNotifier() *capturingNotifier {
+	return &capturingNotifier{codes: map[string]string{}}
+}
+
+// codeLockoutPolicy - lets tests guess login codes wrong more often than a user could before being locked out
+var codeLockoutPolicy = LockoutPolicy{
+	UserMaxFailures: 10,
+	IPMaxFailures:   10,
+	BaseDelay:       time.Millisecond,
+	MaxDelay:        time.Millisecond,
+	LockoutDuration: time.Minute,
+}
+
+func requestCode(t *testing.T, s *UsersService, notifier *capturingNotifier, email string) string {
+	if _, err := s.RequestLoginCode(context.Background(), &pbusers.RequestLoginCodeRequest{Email: email}); err != nil {
+		t.Fatalf("Failed to request login code: %v", err)
+	}
+
+	code, ok := notifier.codes[email]
+	if !ok || len(code) != loginCodeDigits {
+		t.Fatalf("No login code was sent to %v", email)
+	}
+	return code
+}
+
+func Test_ShouldLogInWithCode(t *testing.T) {
+	notifier := newCapturingNotifier()
+	s := newTestService(t, WithNotifier(notifier), WithLockoutPolicy(codeLockoutPolicy))
+	id := addTestUser(t, "passwordless")
+
+	code := requestCode(t, s, notifier, "passwordless@gmail.com")
+
+	res, err := s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{
+		Email: "passwordless@gmail.com",
+		Code:  code,
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in with code: %v", err)
+	}
+
+	tok, err := s.DecodeJWT(res.Token)
+	if err != nil {
+		t.Fatalf("Code login returned an invalid JWT: %v", err)
+	}
+	if caller, _ := principalFromToken(tok); caller.UserID != id || caller.Username != "passwordless" {
+		t.Errorf("Code login JWT was issued to %v", caller)
+	}
+
+	if usr, _ := s.db.GetUserByID(context.Background(), id); !usr.EmailVerified {
+		t.Errorf("Code login did not verify the email")
+	}
+
+	_, err = s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{
+		Email: "passwordless@gmail.com",
+		Code:  code,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Login code was accepted twice: %v", err)
+	}
+}
+
+func Test_ShouldOnlyAcceptNewestLoginCode(t *testing.T) {
+	clock := newTestClock()
+	notifier := newCapturingNotifier()
+	s := newTestService(t, WithNotifier(notifier), WithLockoutPolicy(codeLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "requestedtwice")
+
+	first := requestCode(t, s, notifier, "requestedtwice@gmail.com")
+	second := requestCode(t, s, notifier, "requestedtwice@gmail.com")
+
+	if first != second {
+		_, err := s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{Email: "requestedtwice@gmail.com", Code: first})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Replaced login code was accepted: %v", err)
+		}
+		clock.advance(5 * time.Millisecond)
+	}
+
+	if _, err := s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{Email: "requestedtwice@gmail.com", Code: second}); err != nil {
+		t.Errorf("Newest login code was not accepted: %v", err)
+	}
+}
+
+func Test_ShouldGiveUpLoginCodeAfterWrongGuesses(t *testing.T) {
+	clock := newTestClock()
+	notifier := newCapturingNotifier()
+	s := newTestService(t, WithNotifier(notifier), WithLockoutPolicy(codeLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "guessedcode")
+
+	code := requestCode(t, s, notifier, "guessedcode@gmail.com")
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for i := 0; i < loginCodeMaxAttempts; i++ {
+		_, err := s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{Email: "guessedcode@gmail.com", Code: wrong})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Wrong login code was not rejected: %v", err)
+		}
+		clock.advance(5 * time.Millisecond)
+	}
+
+	_, err := s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{Email: "guessedcode@gmail.com", Code: code})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Login code still worked after too many wrong guesses: %v", err)
+	}
+}
+
+func Test_ShouldNotRevealUnknownLoginCodeEmail(t *testing.T) {
+	notifier := newCapturingNotifier()
+	s := newTestService(t, WithNotifier(notifier), WithLockoutPolicy(codeLockoutPolicy))
+
+	res, err := s.RequestLoginCode(context.Background(), &pbusers.RequestLoginCodeRequest{Email: "ghost@gmail.com"})
+	if err != nil || !res.Success {
+		t.Errorf("Login code request for unknown email did not look successful: %v", err)
+	}
+	if len(notifier.codes) != 0 {
+		t.Errorf("Login code was sent for an unknown address")
+	}
+
+	_, err = s.LoginWithCode(context.Background(), &pbusers.LoginWithCodeRequest{Email: "ghost@gmail.com", Code: "123456"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for unknown email, got %v", err)
+	}
+}
+
+func Test_ShouldEmailLoginCodeByDefault(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer))
+	addTestUser(t, "emailedcode")
+
+	s.RequestLoginCode(context.Background(), &pbusers.RequestLoginCodeRequest{Email: "emailedcode@gmail.com"})
+
+	if _, ok := mailer.Last("emailedcode@gmail.com"); !ok {
+		t.Errorf("Login code was not emailed")
+	}
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	// challenge tokens are only meant for CompleteMFAChallenge, the separate audience keeps them from being
+	// accepted anywhere a JWT is
+	mfaChallengeAudience = "kic-users-mfa"
+	mfaChallengeClaim    = "mfa_challenge"
+	mfaChallengeLifetime = 5 * time.Minute
+
+	recoveryCodeCount = 10
+	// characters in a recovery code, shown split in two halves like abcde-fghij
+	recoveryCodeLength = 10
+)
+
+var errMFAChallengeInvalid = errors.New("invalid MFA challenge token")
+
+// newRecoveryCodes - generate single use recovery codes along with the hashes that are stored
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, recoveryCodeLength)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(buf))[:recoveryCodeLength]
+
+		codes = append(codes, code[:recoveryCodeLength/2]+"-"+code[recoveryCodeLength/2:])
+		hashes = append(hashes, hashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode - accept recovery codes typed in upper case or without the dash
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// issueMFAChallenge - sign the token that stands for a correct password until a code is sent
+func (s *UsersService) issueMFAChallenge(userID int64, username string) (string, error) {
+	tokenID, err := newOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := map[string]interface{}{
+		jwt.SubjectKey:    strconv.FormatInt(userID, 10),
+		jwt.IssuerKey:     s.tokens.Issuer,
+		jwt.AudienceKey:   []string{mfaChallengeAudience},
+		jwt.IssuedAtKey:   now,
+		jwt.NotBeforeKey:  now,
+		jwt.ExpirationKey: now.Add(mfaChallengeLifetime),
+		jwt.JwtIDKey:      tokenID,
+		usernameClaim:     username,
+		mfaChallengeClaim: true,
+	}
+
+	t := jwt.New()
+	for name, value := range claims {
+		if err := t.Set(name, value); err != nil {
+			return "", err
+		}
+	}
+
+	key := s.keys.signer()
+
+	signed, err := jwt.Sign(t, key.alg, key.private)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(signed), nil
+}
+
+// decodeMFAChallenge - the user a challenge token was issued to, if it is valid and was not used yet
+func (s *UsersService) decodeMFAChallenge(ctx context.Context, payload string) (*auth.Principal, error) {
+	key, err := s.keys.verifierFor([]byte(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(
+		[]byte(payload),
+		jwt.WithVerify(key.alg, key.verify),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = jwt.Validate(token,
+		jwt.WithIssuer(s.tokens.Issuer),
+		jwt.WithAudience(mfaChallengeAudience),
+		jwt.WithAcceptableSkew(s.tokens.ClockSkew),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := token.Get(mfaChallengeClaim); !ok || token.Expiration().IsZero() {
+		return nil, errMFAChallengeInvalid
+	}
+
+	caller, err := principalFromToken(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.revocations.IsTokenRevoked(ctx, caller.TokenID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, errMFAChallengeInvalid
+	}
+
+	return caller, nil
+}
+
+// verifyMFACode - check a TOTP code or recovery code of a user, each code is only accepted once
+func (s *UsersService) verifyMFACode(ctx context.Context, mfa *database.MFAModel, code string, allowRecovery bool) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if step, ok := matchTOTP(mfa.Secret, code, time.Now()); ok {
+		fresh, err := s.mfa.UseTOTPStep(ctx, mfa.UserID, step)
+		if fresh {
+			mfa.LastUsedStep = step
+		}
+		return fresh, err
+	}
+
+	if !allowRecovery {
+		return false, nil
+	}
+
+	return s.mfa.UseRecoveryCode(ctx, mfa.UserID, hashToken(normalizeRecoveryCode(code)))
+}
+
+func (s *UsersService) EnrollTOTP(ctx context.Context, req *pbusers.EnrollTOTPRequest) (*pbusers.EnrollTOTPResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, uint(caller.UserID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to get MFA of user %v: %v", caller.UserID, err)
+		return nil, status.Errorf(codes.Internal, "Could not enroll TOTP")
+	}
+
+	if mfa.Enabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "Two-factor authentication is already on")
+	}
+
+	secret, err := newTOTPSecret()
+
+	if err != nil {
+		s.logger.Errorf("Failed to generate TOTP secret: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not enroll TOTP")
+	}
+
+	err = s.mfa.SaveMFA(ctx, &database.MFAModel{
+		UserID: uint(caller.UserID),
+		Secret: secret,
+	})
+
+	if err != nil {
+		s.logger.Errorf("Failed to save TOTP secret of user %v: %v", caller.UserID, err)
+		return nil, status.Errorf(codes.Internal, "Could not enroll TOTP")
+	}
+
+	account := caller.Username
+	if account == "" {
+		account = strconv.FormatInt(caller.UserID, 10)
+	}
+
+	return &pbusers.EnrollTOTPResponse{
+		Secret:     secret,
+		OtpauthURI: otpauthURI(secret, account),
+	}, nil
+}
+
+func (s *UsersService) VerifyTOTP(ctx context.Context, req *pbusers.VerifyTOTPRequest) (*pbusers.VerifyTOTPResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, err
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, uint(caller.UserID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to get MFA of user %v: %v", caller.UserID, err)
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not verify TOTP")
+	}
+
+	if mfa.Secret == "" || mfa.Enabled {
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.FailedPrecondition, "No TOTP enrollment to verify")
+	}
+
+	valid, err := s.verifyMFACode(ctx, mfa, req.Code, false)
+
+	if err != nil {
+		s.logger.Errorf("Failed to verify TOTP of user %v: %v", caller.UserID, err)
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not verify TOTP")
+	}
+
+	if !valid {
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Invalid code")
+	}
+
+	recoveryCodes, hashes, err := newRecoveryCodes()
+
+	if err != nil {
+		s.logger.Errorf("Failed to generate recovery codes: %v", err)
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not verify TOTP")
+	}
+
+	err = s.mfa.ReplaceRecoveryCodes(ctx, mfa.UserID, hashes)
+
+	if err != nil {
+		s.logger.Errorf("Failed to store recovery codes of user %v: %v", caller.UserID, err)
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not verify TOTP")
+	}
+
+	mfa.Enabled = true
+
+	err = s.mfa.SaveMFA(ctx, mfa)
+
+	if err != nil {
+		s.logger.Errorf("Failed to turn on MFA of user %v: %v", caller.UserID, err)
+		return &pbusers.VerifyTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not verify TOTP")
+	}
+
+	return &pbusers.VerifyTOTPResponse{
+		Success:       true,
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *UsersService) DisableTOTP(ctx context.Context, req *pbusers.DisableTOTPRequest) (*pbusers.DisableTOTPResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, err
+	}
+
+	// codes are as guessable here as at login, so failures count towards the same lockout
+	subjects := loginSubjects(ctx, caller.Username)
+
+	if err := s.checkLoginAllowed(ctx, subjects); err != nil {
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, err
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, uint(caller.UserID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to get MFA of user %v: %v", caller.UserID, err)
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not disable TOTP")
+	}
+
+	if !mfa.Enabled {
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.FailedPrecondition, "Two-factor authentication is not on")
+	}
+
+	valid, err := s.verifyMFACode(ctx, mfa, req.Code, true)
+
+	if err != nil {
+		s.logger.Errorf("Failed to verify code of user %v: %v", caller.UserID, err)
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not disable TOTP")
+	}
+
+	if !valid {
+		s.recordLoginFailure(ctx, subjects)
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Invalid code")
+	}
+
+	err = s.mfa.DeleteMFA(ctx, mfa.UserID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to disable MFA of user %v: %v", caller.UserID, err)
+		return &pbusers.DisableTOTPResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not disable TOTP")
+	}
+
+	return &pbusers.DisableTOTPResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *UsersService) CompleteMFAChallenge(ctx context.Context, req *pbusers.CompleteMFAChallengeRequest) (*pbusers.CompleteMFAChallengeResponse, error) {
+	challenge, err := s.decodeMFAChallenge(ctx, req.ChallengeToken)
+
+	if err != nil {
+		s.logger.Debugf("Invalid MFA challenge: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid or expired MFA challenge")
+	}
+
+	subjects := loginSubjects(ctx, challenge.Username)
+
+	if err := s.checkLoginAllowed(ctx, subjects); err != nil {
+		s.logger.Debugf("Refusing MFA challenge of %v: %v", challenge.Username, err)
+		return nil, err
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, uint(challenge.UserID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to get MFA of user %v: %v", challenge.UserID, err)
+		return nil, status.Errorf(codes.Internal, "Could not log in")
+	}
+
+	// turned off since the challenge was issued, the user has to log in again
+	if !mfa.Enabled {
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid or expired MFA challenge")
+	}
+
+	valid, err := s.verifyMFACode(ctx, mfa, req.Code, true)
+
+	if err != nil {
+		s.logger.Errorf("Failed to verify code of user %v: %v", challenge.UserID, err)
+		return nil, status.Errorf(codes.Internal, "Could not log in")
+	}
+
+	if !valid {
+		s.logger.Debugf("Invalid MFA code for user %v", challenge.Username)
+		s.recordLoginFailure(ctx, subjects)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid code")
+	}
+
+	// challenges are single use, the code that completed it cannot be replayed with the same challenge
+	err = s.revocations.RevokeToken(ctx, &database.RevokedTokenModel{
+		TokenID:   challenge.TokenID,
+		UserID:    uint(challenge.UserID),
+		ExpiresAt: challenge.ExpiresAt,
+	})
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke MFA challenge: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not log in")
+	}
+
+	userData, err := s.db.GetUserByID(ctx, challenge.UserID)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not access user")
+	}
+
+	token, refreshToken, err := s.completeLogin(ctx, userData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pbusers.CompleteMFAChallengeResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// enrollTestUser - turn on two-factor authentication for a user, returning the TOTP key and recovery codes
+func enrollTestUser(t *testing.T, s *UsersService, username string) ([]byte, []string) {
+	login := loginTestUser(t, username)
+	ctx := authContext(login.Token)
+
+	enrolled, err := s.EnrollTOTP(ctx, &pbusers.EnrollTOTPRequest{})
+	if err != nil {
+		t.Fatalf("Failed to enroll TOTP: %v", err)
+	}
+
+	key, _ := totpEncoding.DecodeString(enrolled.Secret)
+
+	verified, err := s.VerifyTOTP(ctx, &pbusers.VerifyTOTPRequest{
+		Code: totpCode(key, totpStep(time.Now())),
+	})
+	if err != nil || !verified.Success {
+		t.Fatalf("Failed to verify TOTP: %v", err)
+	}
+
+	return key, verified.RecoveryCodes
+}
+
+func mfaChallenge(t *testing.T, s *UsersService, username string) string {
+	res, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: username,
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	if !res.MfaRequired || res.Token != "" || res.RefreshToken != "" {
+		t.Fatalf("Login of user with two-factor authentication did not stop at the challenge")
+	}
+
+	return res.MfaChallengeToken
+}
+
+func Test_ShouldRequireTOTPAtLogin(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "twofactor")
+	key, recoveryCodes := enrollTestUser(t, s, "twofactor")
+
+	challenge := mfaChallenge(t, s, "twofactor")
+
+	// the code used to verify the enrollment cannot be used again, the next period's code is still accepted
+	res, err := s.CompleteMFAChallenge(context.Background(), &pbusers.CompleteMFAChallengeRequest{
+		ChallengeToken: challenge,
+		Code:           totpCode(key, totpStep(time.Now())+1),
+	})
+	if err != nil {
+		t.Fatalf("Failed to complete MFA challenge: %v", err)
+	}
+
+	if _, err := s.DecodeJWT(res.Token); err != nil {
+		t.Errorf("MFA login returned an invalid JWT: %v", err)
+	}
+	if res.RefreshToken == "" {
+		t.Errorf("MFA login returned no refresh token")
+	}
+
+	clock.advance(20 * time.Millisecond)
+
+	// challenges are single use, even with another valid code
+	_, err = s.CompleteMFAChallenge(context.Background(), &pbusers.CompleteMFAChallengeRequest{
+		ChallengeToken: challenge,
+		Code:           recoveryCodes[0],
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("MFA challenge was accepted twice: %v", err)
+	}
+}
+
+func Test_ShouldRejectReplayedTOTPCode(t *testing.T) {
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy))
+	addTestUser(t, "replayed")
+	key, _ := enrollTestUser(t, s, "replayed")
+
+	_, err := s.CompleteMFAChallenge(context.Background(), &pbusers.CompleteMFAChallengeRequest{
+		ChallengeToken: mfaChallenge(t, s, "replayed"),
+		Code:           totpCode(key, totpStep(time.Now())),
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Code used to verify the enrollment was accepted again: %v", err)
+	}
+}
+
+func Test_ShouldAcceptRecoveryCodeOnce(t *testing.T) {
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy))
+	addTestUser(t, "recovering")
+	_, recoveryCodes := enrollTestUser(t, s, "recovering")
+
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("Expected %v recovery codes, got %v", recoveryCodeCount, len(recoveryCodes))
+	}
+
+	_, err := s.CompleteMFAChallenge(context.Background(), &pbusers.CompleteMFAChallengeRequest{
+		ChallengeToken: mfaChallenge(t, s, "recovering"),
+		Code:           recoveryCodes[0],
+	})
+	if err != nil {
+		t.Fatalf("Recovery code was not accepted: %v", err)
+	}
+
+	_, err = s.CompleteMFAChallenge(context.Background(), &pbusers.CompleteMFAChallengeRequest{
+		ChallengeToken: mfaChallenge(t, s, "recovering"),
+		Code:           recoveryCodes[0],
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Recovery code was accepted twice: %v", err)
+	}
+}
+
+func Test_ShouldNotAuthenticateWithMFAChallenge(t *testing.T) {
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy))
+	addTestUser(t, "challenged")
+	enrollTestUser(t, s, "challenged")
+
+	challenge := mfaChallenge(t, s, "challenged")
+
+	if _, err := s.DecodeJWT(challenge); err == nil {
+		t.Errorf("MFA challenge token was accepted as a JWT")
+	}
+
+	if check := checkToken(challenge); check.GetOkResponse() != nil {
+		t.Errorf("Check allowed an MFA challenge token")
+	}
+}
+
+func Test_ShouldLockOutWrongTOTPCodes(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "guessed")
+	enrollTestUser(t, s, "guessed")
+
+	for i := 0; i < 3; i++ {
+		_, err := s.CompleteMFAChallenge(context.Background(), &pbusers.CompleteMFAChallengeRequest{
+			ChallengeToken: mfaChallenge(t, s, "guessed"),
+			Code:           "000000",
+		})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Wrong code was not rejected: %v", err)
+		}
+		clock.advance(25 * time.Millisecond)
+	}
+
+	_, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "guessed",
+		Password: "password",
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Account was not locked out after wrong codes: %v", err)
+	}
+}
+
+func Test_ShouldDisableTOTP(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithLockoutPolicy(testLockoutPolicy), WithClock(clock.Now))
+	addTestUser(t, "disabling")
+	_, recoveryCodes := enrollTestUser(t, s, "disabling")
+
+	ctx := authContext(loginTestUser(t, "disabling").Token)
+
+	if _, err := s.DisableTOTP(ctx, &pbusers.DisableTOTPRequest{Code: "000000"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Two-factor authentication was turned off with a wrong code: %v", err)
+	}
+
+	clock.advance(20 * time.Millisecond)
+
+	res, err := s.DisableTOTP(ctx, &pbusers.DisableTOTPRequest{Code: recoveryCodes[1]})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to disable TOTP: %v", err)
+	}
+
+	login, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "disabling",
+		Password: "password",
+	})
+	if err != nil || login.MfaRequired || login.Token == "" {
+		t.Errorf("Login still asked for a code after disabling TOTP: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/password"
+)
+
+func storedPassword(t *testing.T, userID int64) string {
+	usr, err := service.db.GetUserByID(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("Failed to get user %v: %v", userID, err)
+	}
+	return usr.Password
+}
+
+func setStoredPassword(t *testing.T, userID int64, hash string) {
+	model := &database.UserModel{Password: hash}
+	model.ID = uint(userID)
+	if err := service.db.UpdateUserInfo(context.Background(), model); err != nil {
+		t.Fatalf("Failed to set password of user %v: %v", userID, err)
+	}
+}
+
+func Test_ShouldStoreArgon2idHashesByDefault(t *testing.T) {
+	// every other test hashes with testHasher
+	s, err := NewUsersService(service.db, service.logger, WithKeyRing(service.keys), WithPasswordPolicy(password.Policy{}))
+	if err != nil {
+		t.Fatalf("Failed to create users service: %v", err)
+	}
+
+	res, err := addPolicyUser(s, "argonuser", "password")
+	if err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	userID := res.CreatedUser.UserID
+
+	hash := storedPassword(t, userID)
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Fatalf("Password is not stored as an Argon2id PHC string with the default parameters: %v", hash)
+	}
+
+	if err := tryLogin(s, context.Background(), "argonuser", "password"); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	if storedPassword(t, userID) != hash {
+		t.Errorf("A current hash was replaced on login")
+	}
+}
+
+func Test_ShouldUpgradeBcryptHashOnLogin(t *testing.T) {
+	userID := addTestUser(t, "bcryptuser")
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	setStoredPassword(t, userID, string(legacy))
+
+	if valid, _ := service.ValidateUser("bcryptuser", "wrong"); valid {
+		t.Fatalf("Wrong password was accepted")
+	}
+	if storedPassword(t, userID) != string(legacy) {
+		t.Fatalf("Hash was replaced after a failed login")
+	}
+
+	loginTestUser(t, "bcryptuser")
+
+	upgraded := storedPassword(t, userID)
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("bcrypt hash was not upgraded to Argon2id on login: %v", upgraded)
+	}
+
+	loginTestUser(t, "bcryptuser")
+}
+
+func Test_ShouldRehashWhenParametersChange(t *testing.T) {
+	userID := addTestUser(t, "reparamuser")
+	params := testArgon2idParams
+	params.Memory = 2048
+	s := newTestService(t, WithPasswordHasher(password.NewArgon2idHasher(params)))
+
+	if err := tryLogin(s, context.Background(), "reparamuser", "password"); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	hash := storedPassword(t, userID)
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=2048,t=1,p=1$") {
+		t.Fatalf("Hash was not rehashed with the new parameters: %v", hash)
+	}
+
+	if err := tryLogin(s, context.Background(), "reparamuser", "password"); err != nil {
+		t.Fatalf("Failed to log in after rehashing: %v", err)
+	}
+	if storedPassword(t, userID) != hash {
+		t.Errorf("Hash with the current parameters was replaced again")
+	}
+}
+
+func Test_ShouldRehashWithBcryptHasher(t *testing.T) {
+	userID := addTestUser(t, "tobcryptuser")
+	s := newTestService(t, WithPasswordHasher(password.NewBcryptHasher(bcrypt.MinCost)))
+
+	if err := tryLogin(s, context.Background(), "tobcryptuser", "password"); err != nil {
+		t.Fatalf("Bcrypt hasher could not check an Argon2id hash: %v", err)
+	}
+
+	hash := storedPassword(t, userID)
+	if cost, err := bcrypt.Cost([]byte(hash)); err != nil || cost != bcrypt.MinCost {
+		t.Fatalf("Hash was not replaced by a bcrypt hash: %v", hash)
+	}
+
+	// raising the cost upgrades hashes made at the old one
+	s = newTestService(t, WithPasswordHasher(password.NewBcryptHasher(bcrypt.MinCost+1)))
+	if err := tryLogin(s, context.Background(), "tobcryptuser", "password"); err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	if cost, _ := bcrypt.Cost([]byte(storedPassword(t, userID))); cost != bcrypt.MinCost+1 {
+		t.Errorf("Hash was not rehashed at the higher cost, got cost %v", cost)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// domain of the ErrorInfo details of password policy errors, their reason is one of the password.Rule constants
+const passwordPolicyDomain = "users.kic"
+
+// checkPasswordPolicy - an InvalidArgument error listing every rule of the password policy the password breaks, in
+// a BadRequest detail for people and an ErrorInfo detail per rule for clients, field is the name of the request
+// field the password was sent in
+func (s *UsersService) checkPasswordPolicy(field, pass, username, email string) error {
+	violations := s.passwordRules.Check(pass, username, email)
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	details := []proto.Message{badRequest}
+
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: violation.Description,
+		})
+
+		details = append(details, &errdetails.ErrorInfo{
+			Reason:   violation.Rule,
+			Domain:   passwordPolicyDomain,
+			Metadata: map[string]string{"field": field},
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, "Password does not meet the password policy")
+
+	if withViolations, err := st.WithDetails(details...); err == nil {
+		st = withViolations
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/mail"
+	"github.com/kic/users/pkg/password"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const strongPassword = "violet kettle orbit 42"
+
+// breachPolicy - the default policy with a breach corpus of a single strong password
+func breachPolicy() password.Policy {
+	policy := password.DefaultPolicy
+	policy.Breaches = password.NewBreachCorpus("correct horse battery staple")
+	return policy
+}
+
+// policyViolations - the rules broken according to a password policy error, by field
+func policyViolations(t *testing.T, err error) map[string][]string {
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+
+	violations := map[string][]string{}
+	descriptions := 0
+	for _, detail := range status.Convert(err).Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if detail.Domain == passwordPolicyDomain {
+				field := detail.Metadata["field"]
+				violations[field] = append(violations[field], detail.Reason)
+			}
+		case *errdetails.BadRequest:
+			descriptions += len(detail.FieldViolations)
+		}
+	}
+
+	rules := 0
+	for _, fieldRules := range violations {
+		rules += len(fieldRules)
+	}
+	if rules != descriptions {
+		t.Errorf("Got %v broken rules but %v descriptions", rules, descriptions)
+	}
+	return violations
+}
+
+func sameRules(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func addPolicyUser(s *UsersService, username, pass string) (*pbusers.AddUserResponse, error) {
+	return s.AddUser(context.Background(), &pbusers.AddUserRequest{
+		Email:           username + "@gmail.com",
+		DesiredUsername: username,
+		DesiredPassword: pass,
+	})
+}
+
+func Test_ShouldListEveryBrokenPasswordRule(t *testing.T) {
+	s := newTestService(t, WithPasswordPolicy(breachPolicy()))
+
+	res, err := addPolicyUser(s, "weakling", "weakling")
+	if res.GetSuccess() {
+		t.Fatalf("User with a weak password was added")
+	}
+
+	violations := policyViolations(t, err)["desiredPassword"]
+	if !sameRules(violations, password.RuleContainsUsername, password.RuleContainsEmail, password.RuleStrength) {
+		t.Fatalf("Expected violations of the username, email and strength rules, got %q", violations)
+	}
+
+	if _, err := s.db.GetUserByEmail(context.Background(), "weakling@gmail.com"); err == nil {
+		t.Errorf("Rejected user was stored")
+	}
+
+	_, err = addPolicyUser(s, "shorty", "x7#Q")
+	if violations := policyViolations(t, err)["desiredPassword"]; len(violations) == 0 || violations[0] != password.RuleMinLength {
+		t.Errorf("Short password was not rejected for its length: %q", violations)
+	}
+}
+
+func Test_ShouldRejectBreachedPassword(t *testing.T) {
+	s := newTestService(t, WithPasswordPolicy(breachPolicy()))
+
+	if score := password.Strength("correct horse battery staple"); score < password.DefaultPolicy.MinScore {
+		t.Fatalf("Breached password is not strong enough to only fail the breach check, score %v", score)
+	}
+
+	_, err := addPolicyUser(s, "breached", "correct horse battery staple")
+	violations := policyViolations(t, err)["desiredPassword"]
+	if !sameRules(violations, password.RuleBreached) {
+		t.Errorf("Expected only the breach rule to fail, got %q", violations)
+	}
+
+	if res, err := addPolicyUser(s, "unbreached", strongPassword); err != nil || !res.Success {
+		t.Errorf("Strong password was rejected: %v", err)
+	}
+}
+
+func Test_ShouldCheckPasswordPolicyOnChange(t *testing.T) {
+	s := newTestService(t, WithPasswordPolicy(breachPolicy()))
+
+	if _, err := addPolicyUser(s, "policychange", strongPassword); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+
+	login, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "policychange",
+		Password: strongPassword,
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	_, err = s.ChangePassword(authContext(login.Token), &pbusers.ChangePasswordRequest{
+		CurrentPassword: strongPassword,
+		NewPassword:     "Mail@PolicyChange@Gmail.com",
+	})
+	violations := policyViolations(t, err)["newPassword"]
+	if !sameRules(violations, password.RuleContainsUsername, password.RuleContainsEmail) {
+		t.Fatalf("Expected the username and email rules to fail, got %q", violations)
+	}
+
+	if valid, _ := s.ValidateUser("policychange", strongPassword); !valid {
+		t.Errorf("Password was changed by a rejected change")
+	}
+
+	_, err = s.ChangePassword(authContext(login.Token), &pbusers.ChangePasswordRequest{
+		CurrentPassword: strongPassword,
+		NewPassword:     "amber lantern ferry 17",
+	})
+	if err != nil {
+		t.Errorf("Failed to change to a strong password: %v", err)
+	}
+}
+
+func Test_ShouldCheckPasswordPolicyOnReset(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer), WithPasswordPolicy(breachPolicy()))
+
+	if _, err := addPolicyUser(s, "policyreset", strongPassword); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+
+	if _, err := s.RequestPasswordReset(context.Background(), &pbusers.RequestPasswordResetRequest{Email: "policyreset@gmail.com"}); err != nil {
+		t.Fatalf("Failed to request password reset: %v", err)
+	}
+	token := resetToken(t, mailer, "policyreset@gmail.com")
+
+	_, err := s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{Token: token, NewPassword: "password1"})
+	if len(policyViolations(t, err)["newPassword"]) == 0 {
+		t.Fatalf("Weak password was not rejected")
+	}
+
+	// only known once the token tells whose password is reset
+	_, err = s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{Token: token, NewPassword: "policyreset violet kettle"})
+	if violations := policyViolations(t, err)["newPassword"]; !sameRules(violations, password.RuleContainsUsername, password.RuleContainsEmail) {
+		t.Fatalf("Expected the username and email rules to fail, got %q", violations)
+	}
+
+	// a rejected password does not use up the link
+	res, err := s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{Token: token, NewPassword: "amber lantern ferry 17"})
+	if err != nil || !res.Success {
+		t.Errorf("Failed to reset password after a rejected one: %v", err)
+	}
+}
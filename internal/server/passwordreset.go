@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/mail"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// DefaultAppURL - where the links in account emails point unless WithAppURL is used
+const DefaultAppURL = "https://keeping-it-casual.com"
+
+const (
+	passwordResetTokenBytes = 32
+	passwordResetLifetime   = time.Hour
+	passwordResetPath       = "/reset-password"
+)
+
+const passwordResetBody = `Hi %v,
+
+Someone asked to reset the password of your KIC account. If it was you, set a new password here:
+
+%v
+
+The link works once and expires in %v. If you did not ask for this you can ignore this email, your
+password stays the same.
+`
+
+// accountLink - the link to the page of the web app that takes the token of an account email
+func (s *UsersService) accountLink(path, token string) string {
+	return fmt.Sprintf("%v%v?token=%v", s.appURL, path, url.QueryEscape(token))
+}
+
+func (s *UsersService) RequestPasswordReset(ctx context.Context, req *pbusers.RequestPasswordResetRequest) (*pbusers.RequestPasswordResetResponse, error) {
+	if req.Email == "" {
+		return &pbusers.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Email is required")
+	}
+
+	usr, err := s.db.GetUserByEmail(ctx, req.Email)
+
+	// the response must not tell which addresses have accounts
+	if err != nil {
+		s.logger.Debugf("No user to reset the password of with email %v: %v", req.Email, err)
+		return &pbusers.RequestPasswordResetResponse{
+			Success: true,
+		}, nil
+	}
+
+	token, err := newOpaqueToken(passwordResetTokenBytes)
+
+	if err != nil {
+		s.logger.Errorf("Failed to generate password reset token: %v", err)
+		return &pbusers.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not request password reset")
+	}
+
+	err = s.oneTimeTokens.AddOneTimeToken(ctx, &database.OneTimeTokenModel{
+		UserID:    usr.ID,
+		Purpose:   database.PurposePasswordReset,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetLifetime),
+	})
+
+	if err != nil {
+		s.logger.Errorf("Failed to store password reset token of user %v: %v", usr.ID, err)
+		return &pbusers.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not request password reset")
+	}
+
+	err = s.mailer.Send(ctx, &mail.Message{
+		To:      usr.Email,
+		Subject: "Reset your KIC password",
+		Body:    fmt.Sprintf(passwordResetBody, usr.Username, s.accountLink(passwordResetPath, token), passwordResetLifetime),
+	})
+
+	if err != nil {
+		s.logger.Errorf("Failed to send password reset email to user %v: %v", usr.ID, err)
+		return &pbusers.RequestPasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not request password reset")
+	}
+
+	return &pbusers.RequestPasswordResetResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *UsersService) CompletePasswordReset(ctx context.Context, req *pbusers.CompletePasswordResetRequest) (*pbusers.CompletePasswordResetResponse, error) {
+	if req.NewPassword == "" {
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "New password is required")
+	}
+
+	reset, err := s.oneTimeTokens.GetOneTimeToken(ctx, database.PurposePasswordReset, hashToken(req.Token))
+
+	if errors.Is(err, database.ErrOneTimeTokenInvalid) {
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Invalid or expired reset token")
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to get password reset token: %v", err)
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not reset password")
+	}
+
+	usr, err := s.db.GetUserByID(ctx, int64(reset.UserID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to get user %v to reset the password of: %v", reset.UserID, err)
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not reset password")
+	}
+
+	// checked before the token is used up, so a rejected password can be replaced without a new link
+	if err := s.checkPasswordPolicy("newPassword", req.NewPassword, usr.Username, usr.Email); err != nil {
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.NewPassword)
+
+	if err != nil {
+		s.logger.Errorf("Failed to hash password: %v", err)
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Password cannot be encrypted")
+	}
+
+	// only one of several requests presenting the same token gets to use it
+	_, err = s.oneTimeTokens.UseOneTimeToken(ctx, database.PurposePasswordReset, reset.TokenHash)
+
+	if errors.Is(err, database.ErrOneTimeTokenInvalid) {
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Invalid or expired reset token")
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to use password reset token: %v", err)
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not reset password")
+	}
+
+	model := &database.UserModel{Password: hashedPassword}
+	model.ID = usr.ID
+
+	err = s.db.UpdateUserInfo(ctx, model)
+
+	if err != nil {
+		s.logger.Errorf("Failed to update password of user %v: %v", usr.ID, err)
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not reset password")
+	}
+
+	// other reset links sent before this one are as stale as the old password
+	err = s.oneTimeTokens.RevokeUserOneTimeTokens(ctx, usr.ID, database.PurposePasswordReset)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke password reset tokens of user %v: %v", usr.ID, err)
+	}
+
+	// whoever knew the old password may still be logged in
+	err = s.revokeAllUserTokens(ctx, usr.ID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke tokens after password reset: %v", err)
+		return &pbusers.CompletePasswordResetResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke existing sessions")
+	}
+
+	// proving control of the email address is enough to lift a lockout from guessed passwords
+	err = s.loginAttempts.ResetLoginAttempts(ctx, userSubject(usr.Username))
+
+	if err != nil {
+		s.logger.Errorf("Failed to reset login attempts of %v: %v", usr.Username, err)
+	}
+
+	return &pbusers.CompletePasswordResetResponse{
+		Success: true,
+	}, nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/mail"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+var accountLinkPattern = regexp.MustCompile(`https://\S+`)
+
+// resetToken - the token in the last password reset link emailed to the address
+func resetToken(t *testing.T, mailer *mail.MemoryMailer, email string) string {
+	msg, ok := mailer.Last(email)
+	if !ok {
+		t.Fatalf("No email was sent to %v", email)
+	}
+
+	link, err := url.Parse(accountLinkPattern.FindString(msg.Body))
+	if err != nil || link.Host != "app.example.com" || link.Path != passwordResetPath {
+		t.Fatalf("Email has no reset link: %q", msg.Body)
+	}
+	return link.Query().Get("token")
+}
+
+func Test_ShouldResetPassword(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer))
+	id := addTestUser(t, "forgetful")
+
+	login, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "forgetful",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	if _, err := s.RequestPasswordReset(context.Background(), &pbusers.RequestPasswordResetRequest{Email: "forgetful@gmail.com"}); err != nil {
+		t.Fatalf("Failed to request password reset: %v", err)
+	}
+
+	token := resetToken(t, mailer, "forgetful@gmail.com")
+
+	// a token outside of any session is only revoked by its issue time, likely the same second as the reset
+	sessionless, err := s.GenerateJWT(id, "forgetful", []string{database.RoleUser})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	res, err := s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{
+		Token:       token,
+		NewPassword: "a new password",
+	})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to reset password: %v", err)
+	}
+
+	if valid, _ := s.ValidateUser("forgetful", "a new password"); !valid {
+		t.Errorf("New password was not set")
+	}
+	if valid, _ := s.ValidateUser("forgetful", "password"); valid {
+		t.Errorf("Old password still works")
+	}
+	if _, err := s.DecodeJWT(login.Token); err == nil {
+		t.Errorf("Session from before the reset is still valid")
+	}
+	if _, err := s.DecodeJWT(sessionless); err == nil {
+		t.Errorf("Token issued before the reset is still valid")
+	}
+
+	relogin, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "forgetful",
+		Password: "a new password",
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in with the new password: %v", err)
+	}
+	if _, err := s.DecodeJWT(relogin.Token); err != nil {
+		t.Errorf("Token issued right after the reset was revoked: %v", err)
+	}
+
+	_, err = s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{
+		Token:       token,
+		NewPassword: "another password",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Reset token was accepted twice: %v", err)
+	}
+}
+
+func Test_ShouldNotRevealUnknownResetEmail(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer))
+
+	res, err := s.RequestPasswordReset(context.Background(), &pbusers.RequestPasswordResetRequest{Email: "nobody@gmail.com"})
+	if err != nil || !res.Success {
+		t.Errorf("Reset of unknown email did not look successful: %v", err)
+	}
+
+	if len(mailer.Sent()) != 0 {
+		t.Errorf("Email was sent for an unknown address")
+	}
+}
+
+func Test_ShouldRejectExpiredResetToken(t *testing.T) {
+	s := newTestService(t)
+	id := addTestUser(t, "tooslow")
+
+	token, _ := newOpaqueToken(passwordResetTokenBytes)
+	s.oneTimeTokens.AddOneTimeToken(context.Background(), &database.OneTimeTokenModel{
+		UserID:    uint(id),
+		Purpose:   database.PurposePasswordReset,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	_, err := s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{
+		Token:       token,
+		NewPassword: "a new password",
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expired reset token was accepted: %v", err)
+	}
+}
+
+func Test_ShouldRevokeOtherResetTokens(t *testing.T) {
+	mailer := mail.NewMemoryMailer()
+	s := newTestService(t, WithMailer(mailer))
+	addTestUser(t, "resettwice")
+
+	s.RequestPasswordReset(context.Background(), &pbusers.RequestPasswordResetRequest{Email: "resettwice@gmail.com"})
+	first := resetToken(t, mailer, "resettwice@gmail.com")
+
+	s.RequestPasswordReset(context.Background(), &pbusers.RequestPasswordResetRequest{Email: "resettwice@gmail.com"})
+	second := resetToken(t, mailer, "resettwice@gmail.com")
+
+	if _, err := s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{Token: second, NewPassword: "a new password"}); err != nil {
+		t.Fatalf("Failed to reset password: %v", err)
+	}
+
+	_, err := s.CompletePasswordReset(context.Background(), &pbusers.CompletePasswordResetRequest{Token: first, NewPassword: "another password"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Older reset token still works: %v", err)
+	}
+}
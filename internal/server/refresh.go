@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	refreshTokenBytes    = 32
+	refreshTokenLifetime = 30 * 24 * time.Hour
+)
+
+// newOpaqueToken - generate a random url safe token with n bytes of entropy
+func newOpaqueToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken - tokens are only ever stored hashed so a leaked table cannot be used to log in
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken - create and store a refresh token for the user, an empty sessionID starts a new session
+func (s *UsersService) issueRefreshToken(ctx context.Context, userID uint, sessionID string, authTime time.Time) (string, error) {
+	token, model, err := newRefreshToken(userID, sessionID, authTime)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.refreshTokens.AddRefreshToken(ctx, model); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func newRefreshToken(userID uint, sessionID string, authTime time.Time) (string, *database.RefreshTokenModel, error) {
+	if sessionID == "" {
+		id, err := newOpaqueToken(16)
+		if err != nil {
+			return "", nil, err
+		}
+		sessionID = id
+	}
+
+	token, err := newOpaqueToken(refreshTokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &database.RefreshTokenModel{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		SessionID: sessionID,
+		AuthTime:  authTime,
+		ExpiresAt: time.Now().Add(refreshTokenLifetime),
+	}, nil
+}
+
+// revokeReusedRefreshToken - a rotated token being presented again means either the client or an attacker
+// holds a copy, since we cannot tell which, the whole session is revoked
+func (s *UsersService) revokeReusedRefreshToken(ctx context.Context, stored *database.RefreshTokenModel) error {
+	s.logger.Warnf("Refresh token reuse detected for user %v in session %v, revoking session", stored.UserID, stored.SessionID)
+
+	if err := s.refreshTokens.RevokeRefreshTokenSession(ctx, stored.SessionID); err != nil {
+		s.logger.Errorf("Failed to revoke session %v after refresh token reuse: %v", stored.SessionID, err)
+	}
+
+	// the JWT last issued to the session may be held by the attacker too
+	if err := s.sessions.RevokeSession(ctx, stored.SessionID); err != nil {
+		s.logger.Errorf("Failed to revoke session %v after refresh token reuse: %v", stored.SessionID, err)
+	}
+
+	s.decisions.purgeUser(int64(stored.UserID))
+
+	return status.Errorf(codes.Unauthenticated, "Refresh token reuse detected, please log in again")
+}
+
+func (s *UsersService) RefreshJWTToken(ctx context.Context, req *pbusers.RefreshJWTTokenRequest) (*pbusers.RefreshJWTTokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "Send refresh token along with request")
+	}
+
+	hash := hashToken(req.RefreshToken)
+
+	stored, err := s.refreshTokens.GetRefreshToken(ctx, hash)
+
+	if err != nil {
+		s.logger.Debugf("Failed to find refresh token: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid refresh token")
+	}
+
+	if stored.RevokedAt != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "Refresh token has been revoked")
+	}
+
+	if stored.RotatedAt != nil {
+		return nil, s.revokeReusedRefreshToken(ctx, stored)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, status.Errorf(codes.Unauthenticated, "Refresh token has expired")
+	}
+
+	user, err := s.db.GetUserByID(ctx, int64(stored.UserID))
+
+	if err != nil {
+		s.logger.Debugf("Refresh token belongs to missing user %v: %v", stored.UserID, err)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid refresh token")
+	}
+
+	refreshToken, next, err := newRefreshToken(stored.UserID, stored.SessionID, stored.AuthTime)
+
+	if err != nil {
+		s.logger.Errorf("Failed to create refresh token: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	err = s.refreshTokens.RotateRefreshToken(ctx, hash, next)
+
+	if errors.Is(err, database.ErrRefreshTokenUsed) {
+		// lost a race against another request presenting the same token
+		return nil, s.revokeReusedRefreshToken(ctx, stored)
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to rotate refresh token: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	token, tokenID, err := s.generateSessionJWT(ctx, int64(stored.UserID), user.Username, user.RoleList(), stored.SessionID, stored.AuthTime)
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	s.touchSession(ctx, stored.SessionID, tokenID)
+
+	return &pbusers.RefreshJWTTokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
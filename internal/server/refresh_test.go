@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	pbusers "github.com/kic/users/pkg/proto/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_ShouldRefreshJWT(t *testing.T) {
+	login, err := service.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "qdn123",
+		Password: "password",
+	})
+	if err != nil || login.RefreshToken == "" {
+		t.Fatalf("Failed to get refresh token with proper credentials: %v", err)
+	}
+
+	refreshed, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: login.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("Failed to refresh token with valid refresh token: %v", err)
+	}
+
+	if refreshed.Token == "" || refreshed.RefreshToken == "" || refreshed.RefreshToken == login.RefreshToken {
+		t.Errorf("Did not get a new token and rotated refresh token")
+	}
+
+	if _, err := service.DecodeJWT(refreshed.Token); err != nil {
+		t.Errorf("Refreshed token does not decode: %v", err)
+	}
+}
+
+func Test_ShouldDetectRefreshTokenReuse(t *testing.T) {
+	login, _ := service.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "qdn123",
+		Password: "password",
+	})
+
+	refreshed, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: login.RefreshToken,
+	})
+	if err != nil {
+		t.Fatalf("Failed to refresh token with valid refresh token: %v", err)
+	}
+
+	_, err = service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: login.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated when reusing a rotated refresh token, got %v", err)
+	}
+
+	// the reuse revokes the whole session, including the token handed out by the legitimate refresh
+	_, err = service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: refreshed.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected session to be revoked after refresh token reuse, got %v", err)
+	}
+}
+
+func Test_ShouldFailRefreshJWTWithBadToken(t *testing.T) {
+	resp, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: "notarealrefreshtoken",
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated with a bad refresh token, got %v", err)
+	}
+	if resp != nil {
+		t.Errorf("Got a response back with a bad refresh token")
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// checkRevocation - reject tokens revoked individually, issued before all of the user's tokens were revoked, or
+// of a revoked session
+func (s *UsersService) checkRevocation(ctx context.Context, tok jwt.Token) error {
+	caller, err := principalFromToken(tok)
+	if err != nil {
+		return err
+	}
+
+	return s.checkCallerRevocation(ctx, caller)
+}
+
+// checkCallerRevocation - whether the token a caller was verified with has been revoked since, also run on cached
+// Check decisions since the token may have been revoked through another replica
+func (s *UsersService) checkCallerRevocation(ctx context.Context, caller *auth.Principal) error {
+	if caller.TokenID != "" {
+		revoked, err := s.revocations.IsTokenRevoked(ctx, caller.TokenID)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return errTokenRevoked
+		}
+	}
+
+	if caller.IsServiceAccount() {
+		return s.checkServiceAccountRevocation(ctx, caller)
+	}
+
+	revokedBefore, err := s.revocations.GetUserRevocation(ctx, uint(caller.UserID))
+	if err != nil {
+		return err
+	}
+
+	// tokens without an issue time predate revocation support and cannot be shown to be newer
+	if !revokedBefore.IsZero() && (caller.IssuedAt.IsZero() || caller.IssuedAt.Before(revokedBefore)) {
+		return errTokenRevoked
+	}
+
+	return s.checkSession(ctx, caller.SessionID)
+}
+
+// revocationTime - the time before which every token of a user is revoked when revoking them now. iat only has
+// second precision, so the whole current second is revoked and tokens issued later start at the next one.
+func revocationTime() time.Time {
+	return time.Now().Truncate(time.Second).Add(time.Second)
+}
+
+// issueTime - the iat of a new token of the user, which is up to a second ahead when the user's tokens were revoked
+// within the current second so the new token is not revoked along with them
+func (s *UsersService) issueTime(ctx context.Context, userID int64) (time.Time, error) {
+	now := time.Now()
+
+	revokedBefore, err := s.revocations.GetUserRevocation(ctx, uint(userID))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if now.Before(revokedBefore) {
+		return revokedBefore, nil
+	}
+
+	return now, nil
+}
+
+// revokeToken - revoke the token a caller authenticated with until it expires
+func (s *UsersService) revokeToken(ctx context.Context, caller *auth.Principal) error {
+	if caller.TokenID == "" {
+		return errors.New("token has no jti claim and cannot be revoked")
+	}
+
+	err := s.revocations.RevokeToken(ctx, &database.RevokedTokenModel{
+		TokenID:   caller.TokenID,
+		UserID:    uint(caller.UserID),
+		ExpiresAt: caller.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	// only once the token is revoked, or a concurrent Check could cache it as allowed again
+	s.decisions.purgeUser(caller.UserID)
+
+	return nil
+}
+
+// revokeAllUserTokens - revoke every JWT and refresh token issued to the user up until now
+func (s *UsersService) revokeAllUserTokens(ctx context.Context, userID uint) error {
+	err := s.revocations.RevokeUserTokens(ctx, userID, revocationTime())
+	if err != nil {
+		return err
+	}
+
+	s.decisions.purgeUser(int64(userID))
+
+	err = s.sessions.RevokeUserSessions(ctx, userID, "")
+	if err != nil {
+		return err
+	}
+
+	return s.refreshTokens.RevokeUserRefreshTokens(ctx, userID)
+}
+
+// revokeOtherSessions - revoke every JWT issued to the user up until now and the refresh tokens of every session
+// but the given one, whose JWT has to be replaced since it is revoked along with the others
+func (s *UsersService) revokeOtherSessions(ctx context.Context, userID uint, sessionID string) error {
+	// tokens without a session cannot be told apart from the others
+	if sessionID == "" {
+		return s.revokeAllUserTokens(ctx, userID)
+	}
+
+	err := s.revocations.RevokeUserTokens(ctx, userID, revocationTime())
+	if err != nil {
+		return err
+	}
+
+	s.decisions.purgeUser(int64(userID))
+
+	err = s.sessions.RevokeUserSessions(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return s.refreshTokens.RevokeOtherRefreshTokenSessions(ctx, userID, sessionID)
+}
+
+func (s *UsersService) Logout(ctx context.Context, req *pbusers.LogoutRequest) (*pbusers.LogoutResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userID := caller.UserID
+
+	if req.RefreshToken != "" {
+		stored, err := s.refreshTokens.GetRefreshToken(ctx, hashToken(req.RefreshToken))
+
+		if err != nil || int64(stored.UserID) != userID {
+			return &pbusers.LogoutResponse{
+				Success: false,
+			}, status.Errorf(codes.InvalidArgument, "Invalid refresh token")
+		}
+
+		err = s.refreshTokens.RevokeRefreshTokenSession(ctx, stored.SessionID)
+
+		if err == nil {
+			err = s.sessions.RevokeSession(ctx, stored.SessionID)
+		}
+
+		if err != nil {
+			s.logger.Errorf("Failed to revoke refresh token session %v: %v", stored.SessionID, err)
+			return &pbusers.LogoutResponse{
+				Success: false,
+			}, status.Errorf(codes.Internal, "Could not revoke refresh token")
+		}
+	}
+
+	err = s.revokeToken(ctx, caller)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke token of user %v: %v", userID, err)
+		return &pbusers.LogoutResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke token")
+	}
+
+	return &pbusers.LogoutResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *UsersService) LogoutEverywhere(ctx context.Context, req *pbusers.LogoutEverywhereRequest) (*pbusers.LogoutEverywhereResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userID := caller.UserID
+
+	err = s.revokeAllUserTokens(ctx, uint(userID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke tokens of user %v: %v", userID, err)
+		return &pbusers.LogoutEverywhereResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke tokens")
+	}
+
+	return &pbusers.LogoutEverywhereResponse{
+		Success: true,
+	}, nil
+}
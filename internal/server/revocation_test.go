@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"github.com/kic/users/pkg/database"
+	pbcommon "github.com/kic/users/pkg/proto/common"
+	pbusers "github.com/kic/users/pkg/proto/users"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func addTestUser(t *testing.T, username string) int64 {
+	res, err := service.AddUser(context.Background(), &pbusers.AddUserRequest{
+		Email:           username + "@gmail.com",
+		DesiredUsername: username,
+		DesiredPassword: "password",
+		Birthday: &pbcommon.Date{
+			Year:  1990,
+			Month: 1,
+			Day:   2,
+		},
+		City: "tester",
+	})
+	if err != nil {
+		t.Fatalf("Failed to add test user %v: %v", username, err)
+	}
+	return res.CreatedUser.UserID
+}
+
+func loginTestUser(t *testing.T, username string) *pbusers.GetJWTTokenResponse {
+	login, err := service.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: username,
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in test user %v: %v", username, err)
+	}
+	return login
+}
+
+// authContext - the context a handler sees after the authentication interceptor accepted the token
+func authContext(token string) context.Context {
+	ctx, _ := service.authenticate(metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(authHeader, fmt.Sprintf("Bearer %v", token)),
+	))
+	return ctx
+}
+
+func checkToken(token string) *authv3.CheckResponse {
+	check, _ := service.Check(context.Background(), &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{
+						authHeader: "Bearer " + token,
+					},
+				},
+			},
+		},
+	})
+	return check
+}
+
+func Test_ShouldLogout(t *testing.T) {
+	addTestUser(t, "logmeout")
+	login := loginTestUser(t, "logmeout")
+
+	resp, err := service.Logout(authContext(login.Token), &pbusers.LogoutRequest{
+		RefreshToken: login.RefreshToken,
+	})
+	if err != nil || !resp.Success {
+		t.Fatalf("Failed to log out with a valid token: %v", err)
+	}
+
+	if _, err := service.DecodeJWT(login.Token); err == nil {
+		t.Errorf("Token still decodes after logging out")
+	}
+
+	if checkToken(login.Token).GetOkResponse() != nil {
+		t.Errorf("Got OkResponse with a revoked token")
+	}
+
+	_, err = service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: login.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh token still usable after logging out, got %v", err)
+	}
+}
+
+func Test_ShouldFailLogoutWithoutToken(t *testing.T) {
+	resp, err := service.Logout(context.Background(), &pbusers.LogoutRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated when logging out without a token, got %v", err)
+	}
+	if resp != nil {
+		t.Errorf("Got a response when logging out without a token")
+	}
+}
+
+func Test_ShouldLogoutEverywhere(t *testing.T) {
+	addTestUser(t, "logmeouteverywhere")
+	first := loginTestUser(t, "logmeouteverywhere")
+	second := loginTestUser(t, "logmeouteverywhere")
+
+	resp, err := service.LogoutEverywhere(authContext(first.Token), &pbusers.LogoutEverywhereRequest{})
+	if err != nil || !resp.Success {
+		t.Fatalf("Failed to log out everywhere with a valid token: %v", err)
+	}
+
+	if _, err := service.DecodeJWT(first.Token); err == nil {
+		t.Errorf("Token used to log out everywhere still decodes")
+	}
+
+	_, err = service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: second.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh token of another session still usable, got %v", err)
+	}
+}
+
+func Test_ShouldRejectTokensIssuedBeforePasswordChange(t *testing.T) {
+	id := addTestUser(t, "changemypassword")
+	other := loginTestUser(t, "changemypassword")
+	login := loginTestUser(t, "changemypassword")
+	sessionless, err := service.GenerateJWT(id, "changemypassword", []string{database.RoleUser})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	res, err := service.ChangePassword(authContext(login.Token), &pbusers.ChangePasswordRequest{
+		CurrentPassword: "password",
+		NewPassword:     "newpassword",
+	})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to change password: %v", err)
+	}
+
+	_, err = service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: other.RefreshToken,
+	})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh token of another session still usable after password change, got %v", err)
+	}
+
+	// the session that changed the password carries on
+	if _, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{
+		RefreshToken: login.RefreshToken,
+	}); err != nil {
+		t.Errorf("Refresh token of the session that changed the password was revoked: %v", err)
+	}
+
+	// issued in the same second as the change, most likely
+	for _, token := range []string{other.Token, login.Token, sessionless} {
+		if _, err := service.DecodeJWT(token); err == nil {
+			t.Errorf("Token issued before the password change still decodes")
+		}
+	}
+
+	if _, err := service.DecodeJWT(res.Token); err != nil {
+		t.Errorf("Token replacing the one of the caller was revoked: %v", err)
+	}
+}
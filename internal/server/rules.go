@@ -0,0 +1,198 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+)
+
+// Requirements a rule can place on requests to the paths it matches
+const (
+	RequirePublic        = "public"
+	RequireAuthenticated = "authenticated"
+	RequireRole          = "role"
+	RequireOwner         = "owner"
+)
+
+// AuthzRule - what is needed to access the requests matching a host, path prefix and HTTP methods
+type AuthzRule struct {
+	// matches any host when empty
+	Host string `yaml:"host"`
+	// path prefix matched segment by segment, a {name} segment matches any value and names it for owner rules
+	Path string `yaml:"path"`
+	// matches any method when empty
+	Methods []string `yaml:"methods"`
+	Require string   `yaml:"require"`
+	// the role needed by role rules
+	Role string `yaml:"role"`
+	// the path parameter holding the ID of the user that owns the resource for owner rules
+	Param string `yaml:"param"`
+
+	segments []string
+	index    int
+}
+
+// AuthzRules - rules checked in order by Check, the first rule matching a request decides it
+type AuthzRules struct {
+	Rules []AuthzRule `yaml:"rules"`
+}
+
+// LoadAuthzRules - read and validate a YAML rule file
+func LoadAuthzRules(path string) (*AuthzRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseAuthzRules(data)
+}
+
+// ParseAuthzRules - parse and validate YAML rules
+func ParseAuthzRules(data []byte) (*AuthzRules, error) {
+	rules := &AuthzRules{}
+
+	if err := yaml.UnmarshalStrict(data, rules); err != nil {
+		return nil, err
+	}
+
+	for i := range rules.Rules {
+		rules.Rules[i].index = i
+		if err := rules.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %v: %v", i, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// compile - validate the rule and split its path into segments
+func (r *AuthzRule) compile() error {
+	if !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path %q must start with /", r.Path)
+	}
+
+	r.segments = splitPath(r.Path)
+
+	for i, method := range r.Methods {
+		r.Methods[i] = strings.ToUpper(method)
+	}
+
+	switch r.Require {
+	case RequirePublic, RequireAuthenticated:
+	case RequireRole:
+		if !database.IsValidRole(r.Role) {
+			return fmt.Errorf("unknown role %q", r.Role)
+		}
+	case RequireOwner:
+		for _, segment := range r.segments {
+			if segment == "{"+r.Param+"}" {
+				return nil
+			}
+		}
+		return fmt.Errorf("path %q has no parameter %q", r.Path, r.Param)
+	default:
+		return fmt.Errorf("unknown requirement %q", r.Require)
+	}
+
+	return nil
+}
+
+func splitPath(path string) []string {
+	if i := strings.IndexAny(path, "?#"); i != -1 {
+		path = path[:i]
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// match - whether the rule applies to a request, and the values of its path parameters if so
+func (r *AuthzRule) match(host, method, path string) (map[string]string, bool) {
+	if r.Host != "" {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(r.Host, host) {
+			return nil, false
+		}
+	}
+
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			found = found || m == strings.ToUpper(method)
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	segments := splitPath(path)
+	if len(segments) < len(r.segments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, want := range r.segments {
+		if strings.HasPrefix(want, "{") && strings.HasSuffix(want, "}") {
+			params[want[1:len(want)-1]] = segments[i]
+		} else if want != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// find - the first rule matching a request
+func (a *AuthzRules) find(host, method, path string) (*AuthzRule, map[string]string) {
+	if a == nil {
+		return nil, nil
+	}
+
+	for i := range a.Rules {
+		if params, ok := a.Rules[i].match(host, method, path); ok {
+			return &a.Rules[i], params
+		}
+	}
+
+	return nil, nil
+}
+
+// allows - whether an authenticated caller meets the rule, and why
+func (r *AuthzRule) allows(caller *auth.Principal, params map[string]string) (bool, string) {
+	switch r.Require {
+	case RequireRole:
+		if !caller.HasRole(r.Role) {
+			return false, fmt.Sprintf("requires role %v", r.Role)
+		}
+		return true, fmt.Sprintf("has role %v", r.Role)
+	case RequireOwner:
+		// their user ID is 0, which must not match a parameter
+		if caller.IsServiceAccount() {
+			return false, "service accounts own no user resources"
+		}
+		if caller.HasRole(database.RoleAdmin) {
+			return true, "admin may access any user's resources"
+		}
+		owner, err := strconv.ParseInt(params[r.Param], 10, 64)
+		if err != nil || owner != caller.UserID {
+			return false, fmt.Sprintf("requires ownership of %v %v", r.Param, params[r.Param])
+		}
+		return true, fmt.Sprintf("owns %v %v", r.Param, params[r.Param])
+	}
+
+	return true, "authenticated"
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"gopkg.in/yaml.v2"
+)
+
+const testRules = `
+rules:
+  - host: api.keeping-it-casual.com
+    path: /health
+    require: public
+  - path: /admin
+    require: role
+    role: admin
+  - path: /users/{userID}/posts
+    methods: [put, delete]
+    require: owner
+    param: userID
+  - path: /users
+    require: authenticated
+`
+
+func parseTestRules(t *testing.T) *AuthzRules {
+	rules, err := ParseAuthzRules([]byte(testRules))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	return rules
+}
+
+func checkRequest(s *UsersService, host, method, path, token string) *authv3.CheckResponse {
+	headers := map[string]string{}
+	if token != "" {
+		headers[authHeader] = "Bearer " + token
+	}
+
+	check, _ := s.Check(context.Background(), &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Host:    host,
+					Method:  method,
+					Path:    path,
+					Headers: headers,
+				},
+			},
+		},
+	})
+	return check
+}
+
+func Test_ShouldRejectInvalidRules(t *testing.T) {
+	for _, rules := range []string{
+		"rules:\n  - path: /a\n    require: everyone\n",
+		"rules:\n  - path: /a\n    require: role\n    role: superuser\n",
+		"rules:\n  - path: /users/{id}\n    require: owner\n    param: userID\n",
+		"rules:\n  - path: users\n    require: public\n",
+		"rules:\n  - path: /a\n    require: public\n    unknown: field\n",
+	} {
+		if _, err := ParseAuthzRules([]byte(rules)); err == nil {
+			t.Errorf("Accepted invalid rules %q", rules)
+		}
+	}
+}
+
+func Test_ShouldAllowPublicPathWithoutToken(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+
+	check := checkRequest(s, "api.keeping-it-casual.com:443", "GET", "/health/live?verbose=1", "")
+	if check.GetOkResponse() == nil {
+		t.Fatalf("Denied public path: %v", check.GetStatus().GetMessage())
+	}
+	if !strings.Contains(check.GetStatus().GetMessage(), "public") {
+		t.Errorf("Expected public reason, got %q", check.GetStatus().GetMessage())
+	}
+
+	check = checkRequest(s, "other.example.com", "GET", "/health", "")
+	if check.GetOkResponse() != nil {
+		t.Errorf("Public rule matched another host")
+	}
+}
+
+func Test_ShouldRequireRoleForPath(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+	addTestUser(t, "notanadmin")
+	user := loginTestUser(t, "notanadmin")
+	admin := loginTestUser(t, "admin")
+
+	check := checkRequest(s, "", "GET", "/admin/users", user.Token)
+	if check.GetOkResponse() != nil {
+		t.Errorf("Allowed user without the admin role")
+	}
+	if !strings.Contains(check.GetStatus().GetMessage(), "requires role admin") {
+		t.Errorf("Expected role reason, got %q", check.GetStatus().GetMessage())
+	}
+
+	if check := checkRequest(s, "", "GET", "/admin/users", admin.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied admin: %v", check.GetStatus().GetMessage())
+	}
+}
+
+func Test_ShouldRequireOwnershipOfPathParameter(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+	id := addTestUser(t, "postowner")
+	addTestUser(t, "notpostowner")
+	owner := loginTestUser(t, "postowner")
+	other := loginTestUser(t, "notpostowner")
+	admin := loginTestUser(t, "admin")
+
+	path := fmt.Sprintf("/users/%v/posts/12", id)
+
+	if check := checkRequest(s, "", "DELETE", path, owner.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied owner: %v", check.GetStatus().GetMessage())
+	}
+
+	if check := checkRequest(s, "", "DELETE", path, other.Token); check.GetOkResponse() != nil {
+		t.Errorf("Allowed another user to delete the owner's post")
+	}
+
+	if check := checkRequest(s, "", "DELETE", path, admin.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied admin: %v", check.GetStatus().GetMessage())
+	}
+
+	// reads fall through to the authenticated rule for /users
+	if check := checkRequest(s, "", "GET", path, other.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied authenticated read: %v", check.GetStatus().GetMessage())
+	}
+
+	if check := checkRequest(s, "", "GET", path, ""); check.GetOkResponse() != nil {
+		t.Errorf("Allowed read without a token")
+	}
+}
+
+func Test_ShouldRequireTokenWhenNoRuleMatches(t *testing.T) {
+	s := newTestService(t, WithAuthzRules(parseTestRules(t)))
+	login := loginTestUser(t, "admin")
+
+	if check := checkRequest(s, "", "GET", "/elsewhere", ""); check.GetOkResponse() != nil {
+		t.Errorf("Allowed request without a token")
+	}
+
+	if check := checkRequest(s, "", "GET", "/elsewhere", login.Token); check.GetOkResponse() == nil {
+		t.Errorf("Denied request with a valid token: %v", check.GetStatus().GetMessage())
+	}
+}
+
+func Test_ShouldParseDeployedRules(t *testing.T) {
+	for _, file := range []string{"../../deployment/authz-rules.yaml", "../../test-deployment/authz-rules.yaml"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %v: %v", file, err)
+		}
+
+		configMap := struct {
+			Data map[string]string `yaml:"data"`
+		}{}
+		if err := yaml.Unmarshal(data, &configMap); err != nil {
+			t.Fatalf("Failed to parse %v: %v", file, err)
+		}
+
+		if _, err := ParseAuthzRules([]byte(configMap.Data["rules.yaml"])); err != nil {
+			t.Errorf("Invalid rules in %v: %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	// API keys start with this so they can be told apart from JWTs, and found by secret scanners
+	serviceAccountKeyPrefix   = "kic_sa_"
+	serviceAccountKeyBytes    = 32
+	serviceAccountKeyIDBytes  = 6
+	serviceAccountSubject     = "sa:"
+	maxServiceAccountGrace    = 7 * 24 * time.Hour
+	serviceAccountTouchPeriod = time.Minute
+
+	// claims of service account JWTs, which have no uid so other services cannot mistake them for users
+	scopeClaim    = "scope"
+	clientIDClaim = "client_id"
+	apiKeyIDClaim = "key_id"
+)
+
+var (
+	serviceAccountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+	// "/package.Service/Method" or "/package.Service/*"
+	scopePattern = regexp.MustCompile(`^/[A-Za-z0-9_.]+/([A-Za-z0-9_]+|\*)$`)
+
+	errServiceAccountKeyInvalid = errors.New("unknown or retired API key")
+)
+
+// isServiceAccountKey - whether a bearer credential is an API key rather than a JWT
+func isServiceAccountKey(credential string) bool {
+	return strings.HasPrefix(credential, serviceAccountKeyPrefix)
+}
+
+// newServiceAccountKey - a new API key and the ID it is shown as
+func newServiceAccountKey() (string, string, error) {
+	secret, err := newOpaqueToken(serviceAccountKeyBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyID, err := newOpaqueToken(serviceAccountKeyIDBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	return serviceAccountKeyPrefix + secret, keyID, nil
+}
+
+// validateScopes - scopes are stored comma separated and sent space separated, so they are limited to method names
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return status.Errorf(codes.InvalidArgument, "Service accounts need at least one scope")
+	}
+
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return status.Errorf(codes.InvalidArgument, "Invalid scope %q, use a method like /kic.users.Users/GetUserByID or /kic.users.Users/*", scope)
+		}
+	}
+
+	return nil
+}
+
+// keyAccepted - whether the key with the given ID is the current key of the account or a previous one still in
+// its grace period
+func keyAccepted(account *database.ServiceAccountModel, keyID string, now time.Time) bool {
+	if account.RevokedAt != nil || keyID == "" {
+		return false
+	}
+
+	if keyID == account.KeyID {
+		return true
+	}
+
+	return keyID == account.PreviousKeyID && account.PreviousKeyExpiresAt != nil && now.Before(*account.PreviousKeyExpiresAt)
+}
+
+// serviceAccountPrincipal - the caller for a service account, which has the service role and no user ID
+func serviceAccountPrincipal(account *database.ServiceAccountModel) *auth.Principal {
+	return &auth.Principal{
+		Username:         account.Name,
+		Roles:            []string{database.RoleService},
+		ServiceAccountID: int64(account.ID),
+		Scopes:           account.ScopeList(),
+	}
+}
+
+// serviceAccountForKey - the active account an API key belongs to and the ID of the key
+func (s *UsersService) serviceAccountForKey(ctx context.Context, key string) (*database.ServiceAccountModel, string, error) {
+	hash := hashToken(key)
+
+	account, err := s.svcAccounts.GetServiceAccountByKeyHash(ctx, hash)
+
+	if errors.Is(err, database.ErrServiceAccountNotFound) {
+		return nil, "", errServiceAccountKeyInvalid
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyID := account.KeyID
+	if hash != account.KeyHash {
+		keyID = account.PreviousKeyID
+	}
+
+	if !keyAccepted(account, keyID, time.Now()) {
+		return nil, "", errServiceAccountKeyInvalid
+	}
+
+	s.touchServiceAccount(ctx, account)
+
+	return account, keyID, nil
+}
+
+// touchServiceAccount - keep track of when the account was last used, at most once per serviceAccountTouchPeriod
+func (s *UsersService) touchServiceAccount(ctx context.Context, account *database.ServiceAccountModel) {
+	now := time.Now()
+
+	if account.LastUsedAt != nil && now.Sub(*account.LastUsedAt) < serviceAccountTouchPeriod {
+		return
+	}
+
+	if err := s.svcAccounts.TouchServiceAccount(ctx, account.ID, now); err != nil {
+		s.logger.Errorf("Failed to update last use of service account %v: %v", account.ID, err)
+	}
+}
+
+// isServiceAccountToken - whether a verified JWT was issued to a service account
+func isServiceAccountToken(tok jwt.Token) bool {
+	return strings.HasPrefix(tok.Subject(), serviceAccountSubject)
+}
+
+// serviceAccountFromToken - the caller of a verified service account JWT, which stops working as soon as the
+// account is revoked or the key it was exchanged for retired
+func (s *UsersService) serviceAccountFromToken(ctx context.Context, tok jwt.Token) (*auth.Principal, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(tok.Subject(), serviceAccountSubject), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if jti := tok.JwtID(); jti != "" {
+		revoked, err := s.revocations.IsTokenRevoked(ctx, jti)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, errTokenRevoked
+		}
+	}
+
+	account, err := s.svcAccounts.GetServiceAccount(ctx, uint(id))
+	if err != nil {
+		return nil, err
+	}
+
+	claim, _ := tok.Get(apiKeyIDClaim)
+	keyID, _ := claim.(string)
+
+	if !keyAccepted(account, keyID, time.Now()) {
+		return nil, errTokenRevoked
+	}
+
+	s.touchServiceAccount(ctx, account)
+
+	principal := serviceAccountPrincipal(account)
+	principal.TokenID = tok.JwtID()
+	principal.IssuedAt = tok.IssuedAt()
+	principal.ExpiresAt = tok.Expiration()
+
+	return principal, nil
+}
+
+// checkServiceAccountRevocation - whether the account of a verified service account caller has been revoked
+func (s *UsersService) checkServiceAccountRevocation(ctx context.Context, caller *auth.Principal) error {
+	account, err := s.svcAccounts.GetServiceAccount(ctx, uint(caller.ServiceAccountID))
+	if err != nil {
+		return err
+	}
+
+	if account.RevokedAt != nil {
+		return errTokenRevoked
+	}
+
+	return nil
+}
+
+// generateServiceAccountJWT - a JWT for the account, tied to the key it was exchanged for
+func (s *UsersService) generateServiceAccountJWT(account *database.ServiceAccountModel, keyID string) (string, error) {
+	tokenID, err := newOpaqueToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := map[string]interface{}{
+		jwt.SubjectKey:    fmt.Sprintf("%v%v", serviceAccountSubject, account.ID),
+		jwt.IssuerKey:     s.tokens.Issuer,
+		jwt.AudienceKey:   s.tokens.Audiences,
+		jwt.IssuedAtKey:   now,
+		jwt.NotBeforeKey:  now,
+		jwt.ExpirationKey: now.Add(tokenLifetime),
+		jwt.JwtIDKey:      tokenID,
+		rolesClaim:        []string{database.RoleService},
+		scopeClaim:        strings.Join(account.ScopeList(), " "),
+		clientIDClaim:     account.Name,
+		apiKeyIDClaim:     keyID,
+	}
+
+	t := jwt.New()
+	for name, value := range claims {
+		if err := t.Set(name, value); err != nil {
+			return "", err
+		}
+	}
+
+	key := s.keys.signer()
+
+	signed, err := jwt.Sign(t, key.alg, key.private)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(signed), nil
+}
+
+func serviceAccountToProto(account *database.ServiceAccountModel) *pbusers.ServiceAccount {
+	res := &pbusers.ServiceAccount{
+		ServiceAccountID: int64(account.ID),
+		Name:             account.Name,
+		Description:      account.Description,
+		Scopes:           account.ScopeList(),
+		KeyID:            account.KeyID,
+		CreatedAt:        account.CreatedAt.Unix(),
+	}
+
+	if account.LastUsedAt != nil {
+		res.LastUsedAt = account.LastUsedAt.Unix()
+	}
+
+	if account.RevokedAt != nil {
+		res.RevokedAt = account.RevokedAt.Unix()
+	}
+
+	return res
+}
+
+func (s *UsersService) CreateServiceAccount(ctx context.Context, req *pbusers.CreateServiceAccountRequest) (*pbusers.CreateServiceAccountResponse, error) {
+	caller, err := s.authorizeAdmin(ctx)
+
+	if err != nil {
+		return &pbusers.CreateServiceAccountResponse{
+			Success: false,
+		}, err
+	}
+
+	if !serviceAccountNamePattern.MatchString(req.Name) {
+		return &pbusers.CreateServiceAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Service account names are lowercase letters, digits and dashes")
+	}
+
+	if err := validateScopes(req.Scopes); err != nil {
+		return &pbusers.CreateServiceAccountResponse{
+			Success: false,
+		}, err
+	}
+
+	key, keyID, err := newServiceAccountKey()
+
+	if err != nil {
+		s.logger.Errorf("Failed to create API key: %v", err)
+		return &pbusers.CreateServiceAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not create API key")
+	}
+
+	account := &database.ServiceAccountModel{
+		Name:        req.Name,
+		Description: req.Description,
+		Scopes:      strings.Join(req.Scopes, ","),
+		CreatedBy:   uint(caller.UserID),
+		KeyID:       keyID,
+		KeyHash:     hashToken(key),
+	}
+
+	err = s.svcAccounts.AddServiceAccount(ctx, account)
+
+	if errors.Is(err, database.ErrServiceAccountExists) {
+		return &pbusers.CreateServiceAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.AlreadyExists, "Service account name taken")
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to add service account %v: %v", req.Name, err)
+		return &pbusers.CreateServiceAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not create service account")
+	}
+
+	s.logger.Infof("User %v created service account %v with scopes %v", caller.UserID, account.Name, req.Scopes)
+
+	return &pbusers.CreateServiceAccountResponse{
+		Success:        true,
+		ServiceAccount: serviceAccountToProto(account),
+		ApiKey:         key,
+	}, nil
+}
+
+func (s *UsersService) ListServiceAccounts(ctx context.Context, req *pbusers.ListServiceAccountsRequest) (*pbusers.ListServiceAccountsResponse, error) {
+	if _, err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	accounts, err := s.svcAccounts.ListServiceAccounts(ctx)
+
+	if err != nil {
+		s.logger.Errorf("Failed to list service accounts: %v", err)
+		return nil, status.Errorf(codes.Internal, "Could not list service accounts")
+	}
+
+	res := &pbusers.ListServiceAccountsResponse{}
+
+	for _, account := range accounts {
+		res.ServiceAccounts = append(res.ServiceAccounts, serviceAccountToProto(account))
+	}
+
+	return res, nil
+}
+
+func (s *UsersService) RotateServiceAccountKey(ctx context.Context, req *pbusers.RotateServiceAccountKeyRequest) (*pbusers.RotateServiceAccountKeyResponse, error) {
+	caller, err := s.authorizeAdmin(ctx)
+
+	if err != nil {
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, err
+	}
+
+	grace := time.Duration(req.GracePeriodSeconds) * time.Second
+
+	if grace < 0 || grace > maxServiceAccountGrace {
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, status.Errorf(codes.InvalidArgument, "Grace period must be between 0 and %v", maxServiceAccountGrace)
+	}
+
+	account, err := s.svcAccounts.GetServiceAccount(ctx, uint(req.ServiceAccountID))
+
+	if err != nil {
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "Service account not found")
+	}
+
+	if account.RevokedAt != nil {
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, status.Errorf(codes.FailedPrecondition, "Service account is revoked")
+	}
+
+	key, keyID, err := newServiceAccountKey()
+
+	if err != nil {
+		s.logger.Errorf("Failed to create API key: %v", err)
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not create API key")
+	}
+
+	previousExpiresAt := time.Now().Add(grace)
+
+	err = s.svcAccounts.RotateServiceAccountKey(ctx, account, keyID, hashToken(key), previousExpiresAt)
+
+	if errors.Is(err, database.ErrServiceAccountNotFound) {
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, status.Errorf(codes.FailedPrecondition, "Service account was revoked or rotated meanwhile")
+	}
+
+	if err != nil {
+		s.logger.Errorf("Failed to rotate key of service account %v: %v", account.ID, err)
+		return &pbusers.RotateServiceAccountKeyResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not rotate API key")
+	}
+
+	// decisions about the old key would outlive a rotation without a grace period
+	s.decisions.purgeServiceAccount(int64(account.ID))
+
+	s.logger.Infof("User %v rotated the key of service account %v", caller.UserID, account.Name)
+
+	account.PreviousKeyID = account.KeyID
+	account.KeyID = keyID
+
+	return &pbusers.RotateServiceAccountKeyResponse{
+		Success:        true,
+		ServiceAccount: serviceAccountToProto(account),
+		ApiKey:         key,
+	}, nil
+}
+
+func (s *UsersService) RevokeServiceAccount(ctx context.Context, req *pbusers.RevokeServiceAccountRequest) (*pbusers.RevokeServiceAccountResponse, error) {
+	caller, err := s.authorizeAdmin(ctx)
+
+	if err != nil {
+		return &pbusers.RevokeServiceAccountResponse{
+			Success: false,
+		}, err
+	}
+
+	account, err := s.svcAccounts.GetServiceAccount(ctx, uint(req.ServiceAccountID))
+
+	if err != nil {
+		return &pbusers.RevokeServiceAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "Service account not found")
+	}
+
+	err = s.svcAccounts.RevokeServiceAccount(ctx, account.ID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke service account %v: %v", account.ID, err)
+		return &pbusers.RevokeServiceAccountResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke service account")
+	}
+
+	s.decisions.purgeServiceAccount(int64(account.ID))
+
+	s.logger.Infof("User %v revoked service account %v", caller.UserID, account.Name)
+
+	return &pbusers.RevokeServiceAccountResponse{
+		Success: true,
+	}, nil
+}
+
+func (s *UsersService) GetServiceAccountToken(ctx context.Context, req *pbusers.GetServiceAccountTokenRequest) (*pbusers.GetServiceAccountTokenResponse, error) {
+	// keys are too long to guess, so failures are not counted towards a lockout
+	account, keyID, err := s.serviceAccountForKey(ctx, req.ApiKey)
+
+	if err != nil {
+		s.logger.Debugf("Refusing service account token: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid API key")
+	}
+
+	token, err := s.generateServiceAccountJWT(account, keyID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to generate token for service account %v: %v", account.ID, err)
+		return nil, status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	return &pbusers.GetServiceAccountTokenResponse{
+		Token:     token,
+		ExpiresIn: int64(tokenLifetime / time.Second),
+	}, nil
+}
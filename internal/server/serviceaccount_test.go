@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const getUserByIDMethod = "/kic.users.Users/GetUserByID"
+
+func createServiceAccount(t *testing.T, name string, scopes ...string) *pbusers.CreateServiceAccountResponse {
+	admin := loginTestUser(t, "admin")
+
+	res, err := service.CreateServiceAccount(authContext(admin.Token), &pbusers.CreateServiceAccountRequest{
+		Name:   name,
+		Scopes: scopes,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create service account %v: %v", name, err)
+	}
+	return res
+}
+
+func bearerContext(credential string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(authHeader, "Bearer "+credential))
+}
+
+// callAs - authenticate and authorize a call to the method with the credential, as the interceptors do
+func callAs(credential, method string) error {
+	ctx, err := service.authenticateMethod(bearerContext(credential), method)
+	if err != nil {
+		return err
+	}
+	return service.authorize(ctx, method)
+}
+
+func Test_ShouldAuthenticateServiceAccountKey(t *testing.T) {
+	created := createServiceAccount(t, "feed-generator", getUserByIDMethod)
+
+	caller, err := authenticateCall(service, bearerContext(created.ApiKey), getUserByIDMethod)
+	if err != nil {
+		t.Fatalf("Failed to authenticate API key: %v", err)
+	}
+	if !caller.IsServiceAccount() || caller.UserID != 0 || caller.Username != "feed-generator" || !caller.HasRole(database.RoleService) {
+		t.Errorf("Unexpected principal %v for service account", caller)
+	}
+
+	if err := callAs(created.ApiKey, getUserByIDMethod); err != nil {
+		t.Errorf("Service account was denied a method in its scopes: %v", err)
+	}
+	for _, method := range []string{"/kic.users.Users/DeleteUserByID", "/kic.users.Users/UpdateUserRoles"} {
+		if err := callAs(created.ApiKey, method); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Service account called %v outside of its scopes: %v", method, err)
+		}
+	}
+
+	if err := callAs("kic_sa_not-a-key", getUserByIDMethod); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Unknown API key was accepted: %v", err)
+	}
+}
+
+func Test_ShouldCheckServiceAccountScopes(t *testing.T) {
+	created := createServiceAccount(t, "scoped-checker", "/kic.users.Users/*")
+
+	headers := upstreamHeaders(t, checkRequest(service, "", "POST", getUserByIDMethod, created.ApiKey))
+	if headers[serviceAccountHeader] != "scoped-checker" || headers[rolesHeader] != database.RoleService {
+		t.Errorf("Unexpected identity headers %v", headers)
+	}
+	if _, ok := headers[userIDHeader]; ok {
+		t.Errorf("Set %v header for a service account", userIDHeader)
+	}
+
+	narrow := createServiceAccount(t, "narrow-checker", getUserByIDMethod)
+
+	if checkRequest(service, "", "POST", getUserByIDMethod, narrow.ApiKey).GetOkResponse() == nil {
+		t.Fatalf("Service account was denied a method in its scopes")
+	}
+
+	// the decision for the first path is cached, the scope must still be checked
+	check := checkRequest(service, "", "POST", "/kic.users.Users/DeleteUserByID", narrow.ApiKey)
+	if check.GetDeniedResponse().GetStatus().GetCode() != typev3.StatusCode_Forbidden {
+		t.Errorf("Service account was not denied a method outside of its scopes: %v", check)
+	}
+}
+
+func Test_ShouldExchangeServiceAccountKeyForToken(t *testing.T) {
+	created := createServiceAccount(t, "token-exchanger", getUserByIDMethod)
+
+	res, err := service.GetServiceAccountToken(context.Background(), &pbusers.GetServiceAccountTokenRequest{ApiKey: created.ApiKey})
+	if err != nil || res.Token == "" {
+		t.Fatalf("Failed to exchange API key: %v", err)
+	}
+
+	if err := callAs(res.Token, getUserByIDMethod); err != nil {
+		t.Errorf("Service account token was denied: %v", err)
+	}
+	if checkRequest(service, "", "POST", getUserByIDMethod, res.Token).GetOkResponse() == nil {
+		t.Errorf("Check denied a service account token")
+	}
+	if _, err := service.DecodeJWT(res.Token); err == nil {
+		t.Errorf("Service account token was decoded as a user token")
+	}
+
+	_, err = service.GetServiceAccountToken(context.Background(), &pbusers.GetServiceAccountTokenRequest{ApiKey: "kic_sa_guess"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Unknown API key was exchanged: %v", err)
+	}
+}
+
+func Test_ShouldRotateServiceAccountKey(t *testing.T) {
+	created := createServiceAccount(t, "rotating-job", getUserByIDMethod)
+	oldToken, _ := service.GetServiceAccountToken(context.Background(), &pbusers.GetServiceAccountTokenRequest{ApiKey: created.ApiKey})
+	admin := authContext(loginTestUser(t, "admin").Token)
+
+	graceful, err := service.RotateServiceAccountKey(admin, &pbusers.RotateServiceAccountKeyRequest{
+		ServiceAccountID:   created.ServiceAccount.ServiceAccountID,
+		GracePeriodSeconds: 3600,
+	})
+	if err != nil || graceful.ApiKey == created.ApiKey {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+
+	for _, credential := range []string{created.ApiKey, oldToken.Token, graceful.ApiKey} {
+		if err := callAs(credential, getUserByIDMethod); err != nil {
+			t.Errorf("Credential was refused during the grace period: %v", err)
+		}
+	}
+
+	immediate, err := service.RotateServiceAccountKey(admin, &pbusers.RotateServiceAccountKeyRequest{
+		ServiceAccountID: created.ServiceAccount.ServiceAccountID,
+	})
+	if err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+
+	for _, credential := range []string{created.ApiKey, oldToken.Token, graceful.ApiKey} {
+		if err := callAs(credential, getUserByIDMethod); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Retired credential still works: %v", err)
+		}
+	}
+	if err := callAs(immediate.ApiKey, getUserByIDMethod); err != nil {
+		t.Errorf("New key was refused: %v", err)
+	}
+}
+
+func Test_ShouldRevokeServiceAccount(t *testing.T) {
+	created := createServiceAccount(t, "retired-job", getUserByIDMethod)
+	token, _ := service.GetServiceAccountToken(context.Background(), &pbusers.GetServiceAccountTokenRequest{ApiKey: created.ApiKey})
+
+	// cache a decision about the key
+	if checkRequest(service, "", "POST", getUserByIDMethod, created.ApiKey).GetOkResponse() == nil {
+		t.Fatalf("Check denied a valid API key")
+	}
+
+	res, err := service.RevokeServiceAccount(authContext(loginTestUser(t, "admin").Token), &pbusers.RevokeServiceAccountRequest{
+		ServiceAccountID: created.ServiceAccount.ServiceAccountID,
+	})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to revoke service account: %v", err)
+	}
+
+	for _, credential := range []string{created.ApiKey, token.Token} {
+		if checkRequest(service, "", "POST", getUserByIDMethod, credential).GetOkResponse() != nil {
+			t.Errorf("Check accepted a credential of a revoked service account")
+		}
+		if err := callAs(credential, getUserByIDMethod); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Revoked service account was authenticated: %v", err)
+		}
+	}
+}
+
+func Test_ShouldOnlyLetAdminsManageServiceAccounts(t *testing.T) {
+	addTestUser(t, "accountlessuser")
+	user := authContext(loginTestUser(t, "accountlessuser").Token)
+
+	_, err := service.CreateServiceAccount(user, &pbusers.CreateServiceAccountRequest{Name: "sneaky", Scopes: []string{getUserByIDMethod}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("User created a service account: %v", err)
+	}
+	if _, err := service.ListServiceAccounts(user, &pbusers.ListServiceAccountsRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("User listed service accounts: %v", err)
+	}
+
+	created := createServiceAccount(t, "listed-job", getUserByIDMethod)
+
+	// service accounts are no admins either, and have no account of their own to act on
+	if err := callAs(created.ApiKey, "/kic.users.Users/CreateServiceAccount"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Service account was allowed to create service accounts: %v", err)
+	}
+	if _, err := service.Logout(auth.NewContext(context.Background(), serviceAccountPrincipal(&database.ServiceAccountModel{Name: "x"})), &pbusers.LogoutRequest{}); err == nil {
+		t.Errorf("Service account called a method acting on its own user")
+	}
+
+	admin := authContext(loginTestUser(t, "admin").Token)
+
+	for _, req := range []*pbusers.CreateServiceAccountRequest{
+		{Name: "listed-job", Scopes: []string{getUserByIDMethod}},
+		{Name: "No Spaces", Scopes: []string{getUserByIDMethod}},
+		{Name: "no-scopes"},
+		{Name: "bad-scope", Scopes: []string{"/kic.users.Users/Get,Delete"}},
+	} {
+		if _, err := service.CreateServiceAccount(admin, req); err == nil {
+			t.Errorf("Created invalid service account %v", req)
+		}
+	}
+
+	list, err := service.ListServiceAccounts(admin, &pbusers.ListServiceAccountsRequest{})
+	if err != nil {
+		t.Fatalf("Failed to list service accounts: %v", err)
+	}
+
+	found := false
+	for _, account := range list.ServiceAccounts {
+		if account.Name == "listed-job" {
+			found = account.KeyID == created.ServiceAccount.KeyID && account.RevokedAt == 0
+		}
+	}
+	if !found {
+		t.Errorf("Created service account is not listed: %v", list.ServiceAccounts)
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/kic/users/pkg/auth"
+	"github.com/kic/users/pkg/database"
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+const (
+	userAgentHeader = "user-agent"
+	// the longest user agent kept, the column is no wider
+	maxUserAgentLength = 255
+	// how stale the last-seen time of a session may get, so not every request writes to the store
+	sessionTouchInterval = time.Minute
+)
+
+// userAgent - the user agent of the client making a call
+func userAgent(ctx context.Context) string {
+	headers, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	agents := headers.Get(userAgentHeader)
+	if len(agents) == 0 {
+		return ""
+	}
+
+	agent := agents[0]
+	if len(agent) > maxUserAgentLength {
+		agent = agent[:maxUserAgentLength]
+	}
+	return agent
+}
+
+// startSession - record a new login of the user, tokenID is the jti of the first JWT issued to it
+func (s *UsersService) startSession(ctx context.Context, userID uint, sessionID, tokenID string) error {
+	now := s.clock()
+
+	return s.sessions.AddSession(ctx, &database.SessionModel{
+		ID:         sessionID,
+		UserID:     userID,
+		UserAgent:  userAgent(ctx),
+		ClientIP:   clientIP(ctx),
+		TokenID:    tokenID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	})
+}
+
+// touchSession - record that a new JWT was issued to the session, failures are only logged since the session is
+// still usable
+func (s *UsersService) touchSession(ctx context.Context, sessionID, tokenID string) {
+	if sessionID == "" {
+		return
+	}
+
+	if err := s.sessions.TouchSession(ctx, sessionID, s.clock(), tokenID); err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+		s.logger.Errorf("Failed to update session %v: %v", sessionID, err)
+	}
+}
+
+// checkSession - reject tokens of revoked sessions and keep track of when sessions were last used
+func (s *UsersService) checkSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	session, err := s.sessions.GetSession(ctx, sessionID)
+
+	// sessions started before they were recorded can still be revoked through their refresh tokens and jti
+	if errors.Is(err, database.ErrSessionNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if session.RevokedAt != nil {
+		return errTokenRevoked
+	}
+
+	if s.clock().Sub(session.LastSeenAt) > sessionTouchInterval {
+		s.touchSession(ctx, sessionID, "")
+	}
+
+	return nil
+}
+
+// revokeSession - revoke a session of the user along with its refresh tokens and the JWTs issued to it
+func (s *UsersService) revokeSession(ctx context.Context, session *database.SessionModel) error {
+	err := s.sessions.RevokeSession(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+
+	s.decisions.purgeUser(int64(session.UserID))
+
+	err = s.refreshTokens.RevokeRefreshTokenSession(ctx, session.ID)
+	if err != nil {
+		return err
+	}
+
+	if session.TokenID == "" {
+		return nil
+	}
+
+	// also rejected by checkSession, but a revoked jti does not depend on the session record
+	return s.revocations.RevokeToken(ctx, &database.RevokedTokenModel{
+		TokenID:   session.TokenID,
+		UserID:    session.UserID,
+		ExpiresAt: s.clock().Add(tokenLifetime),
+	})
+}
+
+func (s *UsersService) ListSessions(ctx context.Context, req *pbusers.ListSessionsRequest) (*pbusers.ListSessionsResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.sessions.ListUserSessions(ctx, uint(caller.UserID))
+
+	if err != nil {
+		s.logger.Errorf("Failed to list sessions of user %v: %v", caller.UserID, err)
+		return nil, status.Errorf(codes.Internal, "Could not list sessions")
+	}
+
+	res := &pbusers.ListSessionsResponse{}
+
+	for _, session := range sessions {
+		// refresh tokens of sessions unused for longer have expired, so they cannot be used again
+		if s.clock().Sub(session.LastSeenAt) > refreshTokenLifetime {
+			continue
+		}
+
+		res.Sessions = append(res.Sessions, sessionToProto(session, caller))
+	}
+
+	return res, nil
+}
+
+func (s *UsersService) RevokeSession(ctx context.Context, req *pbusers.RevokeSessionRequest) (*pbusers.RevokeSessionResponse, error) {
+	caller, err := callerFromContext(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessions.GetSession(ctx, req.SessionID)
+
+	// sessions of other users are not found either, so their IDs cannot be probed
+	if err != nil || int64(session.UserID) != caller.UserID {
+		return &pbusers.RevokeSessionResponse{
+			Success: false,
+		}, status.Errorf(codes.NotFound, "Session not found")
+	}
+
+	err = s.revokeSession(ctx, session)
+
+	if err != nil {
+		s.logger.Errorf("Failed to revoke session %v of user %v: %v", session.ID, caller.UserID, err)
+		return &pbusers.RevokeSessionResponse{
+			Success: false,
+		}, status.Errorf(codes.Internal, "Could not revoke session")
+	}
+
+	return &pbusers.RevokeSessionResponse{
+		Success: true,
+	}, nil
+}
+
+func sessionToProto(session *database.SessionModel, caller *auth.Principal) *pbusers.Session {
+	return &pbusers.Session{
+		SessionID:  session.ID,
+		UserAgent:  session.UserAgent,
+		ClientIP:   session.ClientIP,
+		CreatedAt:  session.CreatedAt.Unix(),
+		LastSeenAt: session.LastSeenAt.Unix(),
+		Current:    session.ID == caller.SessionID,
+	}
+}
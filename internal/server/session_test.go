@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pbusers "github.com/kic/users/pkg/proto/users"
+)
+
+// loginFrom - log in the test user with the given user agent through a proxy that saw the given client IP
+func loginFrom(t *testing.T, username, agent, ip string) *pbusers.GetJWTTokenResponse {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(userAgentHeader, agent, forwardedForHeader, ip))
+
+	login, err := service.GetJWTToken(ctx, &pbusers.GetJWTTokenRequest{
+		Username: username,
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in test user %v: %v", username, err)
+	}
+	return login
+}
+
+func listSessions(t *testing.T, token string) []*pbusers.Session {
+	res, err := service.ListSessions(authContext(token), &pbusers.ListSessionsRequest{})
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	return res.Sessions
+}
+
+func Test_ShouldRecordSessionOnLogin(t *testing.T) {
+	addTestUser(t, "devicelist")
+	phone := loginFrom(t, "devicelist", "kic-ios/2.1", "203.0.113.7")
+	laptop := loginFrom(t, "devicelist", "Mozilla/5.0", "198.51.100.20")
+
+	phoneSession, _ := tokenSession(t, phone.Token)
+
+	sessions := listSessions(t, phone.Token)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %v", len(sessions))
+	}
+
+	for _, session := range sessions {
+		if session.Current != (session.SessionID == phoneSession) {
+			t.Errorf("Session %v marked current %v", session.SessionID, session.Current)
+		}
+		if time.Since(time.Unix(session.CreatedAt, 0)) > time.Minute || session.LastSeenAt < session.CreatedAt {
+			t.Errorf("Session %v has unexpected times %v and %v", session.SessionID, session.CreatedAt, session.LastSeenAt)
+		}
+
+		if session.SessionID == phoneSession && (session.UserAgent != "kic-ios/2.1" || session.ClientIP != "203.0.113.7") {
+			t.Errorf("Phone session has user agent %q and IP %q", session.UserAgent, session.ClientIP)
+		}
+	}
+
+	if _, err := service.Logout(authContext(laptop.Token), &pbusers.LogoutRequest{RefreshToken: laptop.RefreshToken}); err != nil {
+		t.Fatalf("Failed to log out: %v", err)
+	}
+
+	if sessions := listSessions(t, phone.Token); len(sessions) != 1 || sessions[0].SessionID != phoneSession {
+		t.Errorf("Logged out session is still listed: %v", sessions)
+	}
+}
+
+func Test_ShouldRevokeSession(t *testing.T) {
+	id := addTestUser(t, "revokedevice")
+	kept := loginTestUser(t, "revokedevice")
+	lost := loginTestUser(t, "revokedevice")
+
+	lostSession, _ := tokenSession(t, lost.Token)
+	// a token of the session whose jti the session does not know about, only rejected by its sid
+	unrecorded := staleToken(t, id, "revokedevice", lost.Token)
+
+	res, err := service.RevokeSession(authContext(kept.Token), &pbusers.RevokeSessionRequest{SessionID: lostSession})
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to revoke session: %v", err)
+	}
+
+	for _, token := range []string{lost.Token, unrecorded} {
+		if _, err := service.DecodeJWT(token); err == nil {
+			t.Errorf("Token of the revoked session is still valid")
+		}
+		if checkToken(token).GetOkResponse() != nil {
+			t.Errorf("Got OkResponse with a token of the revoked session")
+		}
+	}
+
+	if _, err := service.RefreshJWTToken(context.Background(), &pbusers.RefreshJWTTokenRequest{RefreshToken: lost.RefreshToken}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Refresh token of the revoked session still works: %v", err)
+	}
+
+	if _, err := service.DecodeJWT(kept.Token); err != nil {
+		t.Errorf("Other session was revoked: %v", err)
+	}
+	if sessions := listSessions(t, kept.Token); len(sessions) != 1 {
+		t.Errorf("Expected only the kept session to be listed, got %v", sessions)
+	}
+}
+
+func Test_ShouldNotRevokeSessionOfOtherUser(t *testing.T) {
+	addTestUser(t, "sessionowner")
+	addTestUser(t, "sessionthief")
+	owner := loginTestUser(t, "sessionowner")
+	thief := loginTestUser(t, "sessionthief")
+
+	ownerSession, _ := tokenSession(t, owner.Token)
+
+	_, err := service.RevokeSession(authContext(thief.Token), &pbusers.RevokeSessionRequest{SessionID: ownerSession})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound revoking the session of another user, got %v", err)
+	}
+
+	if _, err := service.DecodeJWT(owner.Token); err != nil {
+		t.Errorf("Session was revoked by another user: %v", err)
+	}
+}
+
+func Test_ShouldRevokeSessionsOnLogoutEverywhere(t *testing.T) {
+	addTestUser(t, "leaveall")
+	first := loginTestUser(t, "leaveall")
+	second := loginTestUser(t, "leaveall")
+	sessionID, _ := tokenSession(t, second.Token)
+
+	if _, err := service.LogoutEverywhere(authContext(first.Token), &pbusers.LogoutEverywhereRequest{}); err != nil {
+		t.Fatalf("Failed to log out everywhere: %v", err)
+	}
+
+	// checked on the store since every token of the user was revoked and cannot list sessions any more
+	session, err := service.sessions.GetSession(context.Background(), sessionID)
+	if err != nil || session.RevokedAt == nil {
+		t.Errorf("Session was not revoked: %v", err)
+	}
+}
+
+func Test_ShouldTimeSessionsWithServiceClock(t *testing.T) {
+	clock := newTestClock()
+	s := newTestService(t, WithClock(clock.Now))
+	addTestUser(t, "sessionclock")
+
+	login, err := s.GetJWTToken(context.Background(), &pbusers.GetJWTTokenRequest{
+		Username: "sessionclock",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("Failed to log in: %v", err)
+	}
+
+	res, err := s.ListSessions(authContext(login.Token), &pbusers.ListSessionsRequest{})
+	if err != nil || len(res.Sessions) != 1 {
+		t.Fatalf("Expected 1 session, got %v: %v", res.GetSessions(), err)
+	}
+	if res.Sessions[0].CreatedAt != clock.Now().Unix() || res.Sessions[0].LastSeenAt != clock.Now().Unix() {
+		t.Errorf("Session was not started at the service clock: %v", res.Sessions[0])
+	}
+
+	// refresh tokens of the session have expired by then
+	clock.advance(refreshTokenLifetime + time.Hour)
+
+	if res, err := s.ListSessions(authContext(login.Token), &pbusers.ListSessionsRequest{}); err != nil || len(res.Sessions) != 0 {
+		t.Errorf("Session unused for longer than a refresh token lives is still listed: %v, %v", res.GetSessions(), err)
+	}
+}
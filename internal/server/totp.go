@@ -0,0 +1,93 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP codes as described in RFC 6238, with the defaults every authenticator app supports
+const (
+	totpDigits      = 6
+	totpPeriod      = 30 * time.Second
+	totpSecretBytes = 20
+	// codes from this many periods before or after the current one are accepted, for phones with a drifting clock
+	totpSkew = 1
+	// shown as the account's issuer in authenticator apps
+	totpIssuer = "KIC"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret - generate a random base32 encoded shared secret
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+// totpStep - the number of periods since the unix epoch at the given time
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode - the HOTP value (RFC 4226) of the secret for a time step
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, the low nibble of the last byte picks which 4 bytes make up the code
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// matchTOTP - the time step a code is valid for around the given time, false if it is not valid
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// otpauthURI - the key URI authenticator apps import the secret from, usually as a QR code
+func otpauthURI(secret, account string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+
+	label := url.PathEscape(totpIssuer + ":" + account)
+
+	return fmt.Sprintf("otpauth://totp/%v?%v", label, params.Encode())
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ShouldMatchRFC6238TestVectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	// the 8 digit SHA1 vectors from RFC 6238 appendix B, cut to our 6 digits
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		if got := totpCode(secret, totpStep(time.Unix(unix, 0))); got != want {
+			t.Errorf("Expected code %v at %v, got %v", want, unix, got)
+		}
+	}
+}
+
+func Test_ShouldAcceptCodesWithinSkew(t *testing.T) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+
+	now := time.Now()
+	step := totpStep(now)
+
+	if got, ok := matchTOTP(secret, totpCode(key, step-1), now); !ok || got != step-1 {
+		t.Errorf("Code from the previous period was not accepted")
+	}
+	if _, ok := matchTOTP(secret, totpCode(key, step+2), now); ok {
+		t.Errorf("Code from two periods ahead was accepted")
+	}
+	if _, ok := matchTOTP(secret, "12345", now); ok {
+		t.Errorf("Code with too few digits was accepted")
+	}
+}
+
+func Test_ShouldBuildOtpauthURI(t *testing.T) {
+	uri, err := url.Parse(otpauthURI("JBSWY3DPEHPK3PXP", "qdn123"))
+	if err != nil {
+		t.Fatalf("Invalid otpauth URI: %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || !strings.HasSuffix(uri.Path, "KIC:qdn123") {
+		t.Errorf("Unexpected otpauth URI %v", uri)
+	}
+	if uri.Query().Get("secret") != "JBSWY3DPEHPK3PXP" || uri.Query().Get("issuer") != totpIssuer {
+		t.Errorf("otpauth URI is missing the secret or issuer: %v", uri)
+	}
+}
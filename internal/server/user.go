@@ -3,17 +3,16 @@ package server
 import (
 	"context"
 	"os"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/lestrrat-go/jwx/jwk"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/kic/users/pkg/database"
+	"github.com/kic/users/pkg/mail"
+	"github.com/kic/users/pkg/password"
 	pbcommon "github.com/kic/users/pkg/proto/common"
 	pbusers "github.com/kic/users/pkg/proto/users"
 )
@@ -21,41 +20,274 @@ import (
 type UsersService struct {
 	pbusers.UnimplementedUsersServer
 
-	db     database.Repository
-	keyset jwk.Set
+	db            database.Repository
+	refreshTokens database.RefreshTokenRepository
+	revocations   database.RevocationRepository
+	loginAttempts database.LoginAttemptRepository
+	mfa           database.MFARepository
+	oneTimeTokens database.OneTimeTokenRepository
+	sessions      database.SessionRepository
+	svcAccounts   database.ServiceAccountRepository
+	mailer        mail.Mailer
+	hasher        password.Hasher
+	passwordRules password.Policy
+	notifier      Notifier
+	appURL        string
+	emailPolicy   EmailVerificationPolicy
+	lockout       LockoutPolicy
+	clock         func() time.Time
+	dummyHash     dummyPasswordHash
+	keys          *KeyRing
+	tokens        *TokenConfig
+	publicMethods map[string]bool
+	authzRules    *AuthzRules
+	decisions     *decisionCache
+	shadowMode    bool
+	nginxAuth     bool
 
 	logger *zap.SugaredLogger
 }
 
-func NewUsersService(db database.Repository, logger *zap.SugaredLogger) *UsersService {
-	secretKey := os.Getenv("SECRET_KEY")
-	raw := []byte(secretKey)
+// ServiceOption - configures one of the optional stores used by the UsersService
+type ServiceOption func(*UsersService)
 
-	jkey, _ := jwk.New(raw)
+// WithRefreshTokenRepository - store refresh tokens in the given repository instead of in memory
+func WithRefreshTokenRepository(repo database.RefreshTokenRepository) ServiceOption {
+	return func(s *UsersService) {
+		s.refreshTokens = repo
+	}
+}
+
+// WithRevocationRepository - store revoked tokens in the given repository instead of in memory
+func WithRevocationRepository(repo database.RevocationRepository) ServiceOption {
+	return func(s *UsersService) {
+		s.revocations = repo
+	}
+}
+
+// WithLoginAttemptRepository - track failed logins in the given repository instead of in memory
+func WithLoginAttemptRepository(repo database.LoginAttemptRepository) ServiceOption {
+	return func(s *UsersService) {
+		s.loginAttempts = repo
+	}
+}
+
+// WithMFARepository - store second factors and recovery codes in the given repository instead of in memory
+func WithMFARepository(repo database.MFARepository) ServiceOption {
+	return func(s *UsersService) {
+		s.mfa = repo
+	}
+}
+
+// WithOneTimeTokenRepository - store password reset tokens in the given repository instead of in memory
+func WithOneTimeTokenRepository(repo database.OneTimeTokenRepository) ServiceOption {
+	return func(s *UsersService) {
+		s.oneTimeTokens = repo
+	}
+}
+
+// WithSessionRepository - keep track of where users are logged in with the given repository instead of in memory
+func WithSessionRepository(repo database.SessionRepository) ServiceOption {
+	return func(s *UsersService) {
+		s.sessions = repo
+	}
+}
+
+// WithServiceAccountRepository - store service accounts in the given repository instead of in memory
+func WithServiceAccountRepository(repo database.ServiceAccountRepository) ServiceOption {
+	return func(s *UsersService) {
+		s.svcAccounts = repo
+	}
+}
+
+// WithMailer - send account emails through the given mailer instead of keeping them in memory
+func WithMailer(mailer mail.Mailer) ServiceOption {
+	return func(s *UsersService) {
+		s.mailer = mailer
+	}
+}
+
+// WithPasswordHasher - hash new passwords with the given hasher instead of Argon2id with DefaultArgon2idParams,
+// stored hashes of other algorithms or costs are replaced the next time their user logs in
+func WithPasswordHasher(hasher password.Hasher) ServiceOption {
+	return func(s *UsersService) {
+		s.hasher = hasher
+	}
+}
+
+// WithPasswordPolicy - the rules new passwords must follow instead of password.DefaultPolicy
+func WithPasswordPolicy(policy password.Policy) ServiceOption {
+	return func(s *UsersService) {
+		s.passwordRules = policy
+	}
+}
+
+// WithNotifier - deliver login codes through the given notifier instead of emailing them with the mailer
+func WithNotifier(notifier Notifier) ServiceOption {
+	return func(s *UsersService) {
+		s.notifier = notifier
+	}
+}
+
+// WithAppURL - the address of the web app the links in account emails point to instead of DefaultAppURL
+func WithAppURL(appURL string) ServiceOption {
+	return func(s *UsersService) {
+		s.appURL = strings.TrimSuffix(appURL, "/")
+	}
+}
+
+// WithEmailVerificationPolicy - decide whether unverified users can log in instead of EmailVerificationOptional
+func WithEmailVerificationPolicy(policy EmailVerificationPolicy) ServiceOption {
+	return func(s *UsersService) {
+		s.emailPolicy = policy
+	}
+}
+
+// WithLockoutPolicy - slow down and lock out failed logins following the given policy instead of DefaultLockoutPolicy
+func WithLockoutPolicy(policy LockoutPolicy) ServiceOption {
+	return func(s *UsersService) {
+		s.lockout = policy
+	}
+}
+
+// WithClock - measure login backoffs, lockouts and session activity with the given clock instead of time.Now
+func WithClock(now func() time.Time) ServiceOption {
+	return func(s *UsersService) {
+		s.clock = now
+	}
+}
+
+// WithKeyRing - sign and verify tokens with the keys in the given key ring instead of the symmetric SECRET_KEY
+func WithKeyRing(keys *KeyRing) ServiceOption {
+	return func(s *UsersService) {
+		s.keys = keys
+	}
+}
+
+// WithTokenConfig - issue and check tokens with the given claims instead of reading them from the environment
+func WithTokenConfig(config TokenConfig) ServiceOption {
+	return func(s *UsersService) {
+		s.tokens = &config
+	}
+}
+
+// WithPublicMethods - the full gRPC method names that can be called without a token, replacing DefaultPublicMethods
+func WithPublicMethods(methods ...string) ServiceOption {
+	return func(s *UsersService) {
+		s.publicMethods = make(map[string]bool, len(methods))
+		for _, method := range methods {
+			s.publicMethods[method] = true
+		}
+	}
+}
+
+// WithAuthzRules - decide ext_authz checks with the given path rules instead of accepting any valid token
+func WithAuthzRules(rules *AuthzRules) ServiceOption {
+	return func(s *UsersService) {
+		s.authzRules = rules
+	}
+}
 
-	keyset := jwk.NewSet()
-	keyset.Add(jkey)
+// WithDecisionCache - cache up to size Check decisions for at most ttl, a size of 0 disables the cache. Revoked
+// tokens, sessions and service accounts are denied as soon as they are revoked on any replica, since cached
+// decisions are checked against the revocation state. Changes that need the token verified again, like retiring an
+// API key by rotating it without a grace period, reach other replicas within ttl.
+func WithDecisionCache(size int, ttl time.Duration) ServiceOption {
+	return func(s *UsersService) {
+		s.decisions = newDecisionCache(size, ttl)
+	}
+}
 
-	return &UsersService{
-		db:     db,
-		keyset: keyset,
-		logger: logger,
+// WithShadowMode - log the requests the authorization rules deny but allow them anyway, to try out new rules
+func WithShadowMode(enabled bool) ServiceOption {
+	return func(s *UsersService) {
+		s.shadowMode = enabled
 	}
 }
 
+// WithNginxAuthRequest - decide ServeAuthz requests by the x-original-method and x-original-uri headers of nginx
+// auth_request subrequests. Only enable it when nothing but nginx can reach the authorization port, otherwise the
+// headers are dropped so a client cannot have another request than its own authorized.
+func WithNginxAuthRequest(enabled bool) ServiceOption {
+	return func(s *UsersService) {
+		s.nginxAuth = enabled
+	}
+}
+
+// NewUsersService - stores that are not supplied through options are kept in memory, which is only
+// suitable for tests since they are lost on restart and not shared between replicas. Without a key ring
+// tokens are signed with SECRET_KEY, which must be set to a strong secret.
+func NewUsersService(db database.Repository, logger *zap.SugaredLogger, opts ...ServiceOption) (*UsersService, error) {
+	s := &UsersService{
+		db:            db,
+		refreshTokens: database.NewMockRefreshTokenRepository(map[string]*database.RefreshTokenModel{}, logger),
+		revocations:   database.NewMockRevocationRepository(map[string]*database.RevokedTokenModel{}, logger),
+		loginAttempts: database.NewMockLoginAttemptRepository(map[string]*database.LoginAttemptModel{}, logger),
+		mfa:           database.NewMockMFARepository(map[uint]*database.MFAModel{}, logger),
+		oneTimeTokens: database.NewMockOneTimeTokenRepository(map[string]*database.OneTimeTokenModel{}, logger),
+		sessions:      database.NewMockSessionRepository(map[string]*database.SessionModel{}, logger),
+		svcAccounts:   database.NewMockServiceAccountRepository(map[uint]*database.ServiceAccountModel{}, logger),
+		mailer:        mail.NewMemoryMailer(),
+		hasher:        password.NewArgon2idHasher(password.DefaultArgon2idParams),
+		passwordRules: password.DefaultPolicy,
+		appURL:        DefaultAppURL,
+		emailPolicy:   EmailVerificationOptional,
+		lockout:       DefaultLockoutPolicy,
+		clock:         time.Now,
+		decisions:     newDecisionCache(DefaultDecisionCacheSize, DefaultDecisionCacheTTL),
+		logger:        logger,
+	}
+
+	WithPublicMethods(DefaultPublicMethods...)(s)
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.notifier == nil {
+		s.notifier = &mailNotifier{mailer: s.mailer}
+	}
+
+	if s.keys == nil {
+		keys, err := secretKeyRing(os.Getenv("SECRET_KEY"), os.Getenv("PRODUCTION") != "")
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.keys = keys
+	}
+
+	if s.tokens == nil {
+		config, err := TokenConfigFromEnv()
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.tokens = &config
+	}
+
+	return s, nil
+}
+
 func (s *UsersService) GetJWTToken(ctx context.Context, req *pbusers.GetJWTTokenRequest) (*pbusers.GetJWTTokenResponse, error) {
 	s.logger.Debug("Getting JWT token")
 
-	valid, err := s.ValidateUser(req.Username, req.Password)
+	subjects := loginSubjects(ctx, req.Username)
 
-	if err != nil {
-		s.logger.Debugf("User %v is invalid: %v", req.Username, err)
+	if err := s.checkLoginAllowed(ctx, subjects); err != nil {
+		s.logger.Debugf("Refusing login of %v: %v", req.Username, err)
 		return nil, err
 	}
 
-	if !valid {
-		s.logger.Debugf("User %v is invalid", req.Username)
-		return nil, status.Errorf(codes.InvalidArgument, "Password incorrect")
+	valid, err := s.ValidateUser(req.Username, req.Password)
+
+	// unknown usernames and wrong passwords get the same error, so it does not tell which usernames exist
+	if err != nil || !valid {
+		s.logger.Debugf("User %v is invalid: %v", req.Username, err)
+		s.recordLoginFailure(ctx, subjects)
+		return nil, status.Errorf(codes.Unauthenticated, "Invalid username or password")
 	}
 
 	s.logger.Debugf("User %v is valid", req.Username)
@@ -66,23 +298,104 @@ func (s *UsersService) GetJWTToken(ctx context.Context, req *pbusers.GetJWTToken
 		return nil, status.Errorf(codes.Internal, "Could not access user")
 	}
 
-	token, err := s.GenerateJWT(int64(userData.ID))
+	return s.finishLogin(ctx, userData)
+}
 
-	s.logger.Debugf("Generated token: %v", token)
+// finishLogin - the tokens of a user who proved who they are with a password or login code, or the MFA
+// challenge they need to pass first
+func (s *UsersService) finishLogin(ctx context.Context, userData *database.UserModel) (*pbusers.GetJWTTokenResponse, error) {
+	if s.emailPolicy == EmailVerificationRequired && !userData.EmailVerified {
+		s.logger.Debugf("User %v has not verified their email", userData.Username)
+		return nil, status.Errorf(codes.FailedPrecondition, "Email address is not verified")
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, userData.ID)
 
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "Could not generate token")
+		s.logger.Errorf("Failed to get MFA of user %v: %v", userData.ID, err)
+		return nil, status.Errorf(codes.Internal, "Could not log in")
+	}
+
+	// the failed logins are kept until the code is sent too, so a correct password does not reset the
+	// count of wrong codes
+	if mfa.Enabled {
+		challenge, err := s.issueMFAChallenge(int64(userData.ID), userData.Username)
+
+		if err != nil {
+			s.logger.Errorf("Failed to issue MFA challenge: %v", err)
+			return nil, status.Errorf(codes.Internal, "Could not generate token")
+		}
+
+		return &pbusers.GetJWTTokenResponse{
+			MfaRequired:       true,
+			MfaChallengeToken: challenge,
+		}, nil
+	}
+
+	token, refreshToken, err := s.completeLogin(ctx, userData)
+
+	if err != nil {
+		return nil, err
 	}
 
 	resp := &pbusers.GetJWTTokenResponse{
-		Token: token,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 
 	return resp, nil
 }
 
+// completeLogin - issue the tokens of a user who passed every login step
+func (s *UsersService) completeLogin(ctx context.Context, userData *database.UserModel) (string, string, error) {
+	// failures from the client IP are kept, logging into one account must not allow guessing at others
+	err := s.loginAttempts.ResetLoginAttempts(ctx, userSubject(userData.Username))
+
+	if err != nil {
+		s.logger.Errorf("Failed to reset login attempts of %v: %v", userData.Username, err)
+	}
+
+	sessionID, err := newOpaqueToken(16)
+
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	authTime := s.clock()
+
+	token, tokenID, err := s.generateSessionJWT(ctx, int64(userData.ID), userData.Username, userData.RoleList(), sessionID, authTime)
+
+	s.logger.Debugf("Generated token: %v", token)
+
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	err = s.startSession(ctx, userData.ID, sessionID, tokenID)
+
+	if err != nil {
+		s.logger.Errorf("Failed to record session of user %v: %v", userData.ID, err)
+		return "", "", status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	refreshToken, err := s.issueRefreshToken(ctx, userData.ID, sessionID, authTime)
+
+	if err != nil {
+		s.logger.Errorf("Failed to issue refresh token: %v", err)
+		return "", "", status.Errorf(codes.Internal, "Could not generate token")
+	}
+
+	return token, refreshToken, nil
+}
+
 func (s *UsersService) AddUser(ctx context.Context, req *pbusers.AddUserRequest) (*pbusers.AddUserResponse, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.DesiredPassword), bcrypt.DefaultCost)
+	if err := s.checkPasswordPolicy("desiredPassword", req.DesiredPassword, req.DesiredUsername, req.Email); err != nil {
+		return &pbusers.AddUserResponse{
+			Success: false,
+		}, err
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.DesiredPassword)
 
 	if err != nil {
 		s.logger.Errorf("Failed to hash password: %v", err)
@@ -92,7 +405,7 @@ func (s *UsersService) AddUser(ctx context.Context, req *pbusers.AddUserRequest)
 	model := database.NewUserModel(
 		req.DesiredUsername,
 		req.Email,
-		string(hashedPassword),
+		hashedPassword,
 		req.City,
 		"",
 		req.Birthday,
@@ -103,6 +416,11 @@ func (s *UsersService) AddUser(ctx context.Context, req *pbusers.AddUserRequest)
 	id, err := s.db.AddUser(context.TODO(), model)
 
 	if id != -1 {
+		// the account exists either way, a lost email can be sent again with ResendVerificationEmail
+		if err := s.sendEmailVerification(ctx, uint(id), model.Username, model.Email); err != nil {
+			s.logger.Errorf("Failed to send verification email to user %v: %v", id, err)
+		}
+
 		return &pbusers.AddUserResponse{
 			Success: true,
 			CreatedUser: &pbcommon.User{
@@ -215,39 +533,25 @@ func (s *UsersService) GetUserNameByID(ctx context.Context, req *pbusers.GetUser
 }
 
 func (s *UsersService) DeleteUserByID(ctx context.Context, req *pbusers.DeleteUserByIDRequest) (*pbusers.DeleteUserByIDResponse, error) {
-	headers, ok := metadata.FromIncomingContext(ctx)
-
-	if !ok {
-		s.logger.Debugf("Failed to get headers from incoming call in DeleteUserByID")
-		return nil, status.Errorf(codes.Unauthenticated, "Send token along with request")
-	}
-
-	header := headers[authHeader][0]
-
-	s.logger.Debugf("Incoming header: %v", header)
-
-	tokString, err := parseCredentialsFromHeader(header)
-
-	tok, err := s.DecodeJWT(tokString)
+	_, err := s.authorizeUser(ctx, req.UserID)
 
 	if err != nil {
-		s.logger.Debugf("Failed to decode token")
-		return nil, status.Errorf(codes.Internal, "Failed to decode token")
+		return nil, err
 	}
 
-	strID, _ := tok.Get("uid")
-	tokID, err := strconv.Atoi(strID.(string))
+	err = s.db.DeleteUserByID(ctx, req.UserID)
 
-	if int64(tokID) != req.UserID || err != nil {
+	if err != nil {
+		s.logger.Errorf("Failed to delete user %v: %v", req.UserID, err)
 		return &pbusers.DeleteUserByIDResponse{
 			Success: false,
-		}, status.Errorf(codes.Unauthenticated, "Cannot delete another user's account")
+		}, status.Errorf(codes.Internal, "Could not delete user")
 	}
 
-	err = s.db.DeleteUserByID(context.TODO(), req.UserID)
+	err = s.revokeAllUserTokens(ctx, uint(req.UserID))
 
 	if err != nil {
-		s.logger.Debugf("Failed to get headers from incoming call in DeleteUserByID")
+		s.logger.Errorf("Failed to revoke tokens of deleted user %v: %v", req.UserID, err)
 	}
 
 	return &pbusers.DeleteUserByIDResponse{
@@ -263,26 +567,49 @@ func (s *UsersService) UpdateUserInfo(ctx context.Context, req *pbusers.UpdateUs
 		UpdatedUser: nil,
 	}
 
-	s.logger.Debugf("Starting UpdateUserInfo with req: %v", req)
+	// the request is not logged as a whole since it can carry the current password
+	s.logger.Debugf("Starting UpdateUserInfo of user %v", req.UserID)
 
-	var hashedPassword []byte // declaring hashedPassword to potentially be filled in
-	var err error             // declaring err variable to hold potential errors
+	// only the owner of the account or an admin may change it
+	caller, err := s.authorizeUser(ctx, req.UserID)
 
-	if req.DesiredPassword != "" { // if password change is requested
-		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(req.DesiredPassword), bcrypt.DefaultCost) // hash the password
+	if err != nil {
+		return failureResponse, err
+	}
+
+	if req.DesiredPassword != "" {
+		return failureResponse, status.Errorf(codes.InvalidArgument, "Use ChangePassword to change the password")
 	}
 
-	// if error, log and return and failure
+	current, err := s.db.GetUserByID(ctx, req.UserID)
+
 	if err != nil {
-		s.logger.Errorf("Failed to hash password: %v", err)
-		return failureResponse, status.Errorf(codes.InvalidArgument, "Password cannot be encrypted")
+		s.logger.Errorf("Failed to get user %v to update: %v", req.UserID, err)
+		return failureResponse, status.Errorf(codes.NotFound, "User not found")
+	}
+
+	// a new email only replaces the current one once the user follows the link sent to it
+	pendingEmail := ""
+	if req.Email != "" && req.Email != current.Email {
+		pendingEmail = req.Email
+	}
+
+	var authTime time.Time
+
+	// the email is where password resets go, so changing it takes more than holding a token
+	if pendingEmail != "" {
+		authTime, err = s.reauthenticate(ctx, caller, req.CurrentPassword)
+
+		if err != nil {
+			return failureResponse, err
+		}
 	}
 
 	// create UserModel from updated fields
 	model := database.NewUserModel(
 		req.DesiredUsername,
-		req.Email,
-		string(hashedPassword),
+		"",
+		"",
 		req.City,
 		req.Bio,
 		req.Birthday,
@@ -291,11 +618,12 @@ func (s *UsersService) UpdateUserInfo(ctx context.Context, req *pbusers.UpdateUs
 	)
 
 	model.ID = uint(req.UserID)
+	model.PendingEmail = pendingEmail
 
 	s.logger.Debugf("Created new user model: %v", model)
 
 	// attempt to update db with model containing updated information
-	err = s.db.UpdateUserInfo(context.TODO(), model)
+	err = s.db.UpdateUserInfo(ctx, model)
 
 	// if error, log and return failure
 	if err != nil {
@@ -303,11 +631,28 @@ func (s *UsersService) UpdateUserInfo(ctx context.Context, req *pbusers.UpdateUs
 		return failureResponse, err
 	}
 
+	token := ""
+
+	if pendingEmail != "" {
+		err = s.sendEmailVerification(ctx, model.ID, current.Username, pendingEmail)
+
+		if err != nil {
+			s.logger.Errorf("Failed to send verification email to user %v: %v", req.UserID, err)
+			return failureResponse, status.Errorf(codes.Internal, "Could not send verification email")
+		}
+
+		token, err = s.revokeSessionsAfterEmailChange(ctx, caller, current, authTime)
+
+		if err != nil {
+			s.logger.Errorf("Failed to revoke sessions after email change of user %v: %v", req.UserID, err)
+			return failureResponse, status.Errorf(codes.Internal, "Could not revoke existing sessions")
+		}
+	}
 
 	usr, _ := s.db.GetUserByID(context.TODO(), req.GetUserID())
 
 	// creating success response
-	resp := &pbusers.UpdateUserInfoResponse{Success: true, UpdatedUser: &pbcommon.User{
+	resp := &pbusers.UpdateUserInfoResponse{Success: true, Token: token, UpdatedUser: &pbcommon.User{
 		UserID:   int64(usr.ID),
 		UserName: usr.Username,
 		Email:    usr.Email,
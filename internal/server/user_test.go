@@ -2,10 +2,11 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"github.com/kic/users/pkg/password"
 	pbcommon "github.com/kic/users/pkg/proto/common"
 	proto "github.com/kic/users/pkg/proto/users"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"testing"
 )
 
@@ -122,15 +123,55 @@ func Test_ShouldFailGetJWT(t *testing.T) {
 	}
 }
 
+// countingHasher - the test hasher, recording the hashes passwords are verified against
+type countingHasher struct {
+	password.Hasher
+	verified []string
+}
+
+func (h *countingHasher) Verify(pass, hash string) (bool, bool, error) {
+	h.verified = append(h.verified, hash)
+	return h.Hasher.Verify(pass, hash)
+}
+
+func Test_ShouldNotRevealWhichUsernamesExist(t *testing.T) {
+	hasher := &countingHasher{Hasher: testHasher}
+	s := newTestService(t, WithPasswordHasher(hasher))
+	addTestUser(t, "enumerated")
+
+	_, wrongPassword := s.GetJWTToken(context.Background(), &proto.GetJWTTokenRequest{
+		Username: "enumerated",
+		Password: "notthepassword",
+	})
+	if len(hasher.verified) != 1 {
+		t.Fatalf("Expected a wrong password to be verified once, got %v", len(hasher.verified))
+	}
+
+	_, unknownUser := s.GetJWTToken(context.Background(), &proto.GetJWTTokenRequest{
+		Username: "neverregistered",
+		Password: "notthepassword",
+	})
+
+	// an unknown user takes as long by checking the password against a hash made by the same hasher
+	if len(hasher.verified) != 2 || hasher.verified[1] != s.dummyHash.get(hasher) || hasher.verified[1] == "" {
+		t.Errorf("Unknown user was not verified against the dummy hash: %q", hasher.verified)
+	}
+
+	if status.Code(wrongPassword) != codes.Unauthenticated || status.Code(unknownUser) != codes.Unauthenticated {
+		t.Errorf("Expected Unauthenticated for both, got %v and %v", wrongPassword, unknownUser)
+	}
+
+	if status.Convert(wrongPassword).Message() != status.Convert(unknownUser).Message() {
+		t.Errorf("Wrong password and unknown user give different errors: %q and %q", wrongPassword, unknownUser)
+	}
+}
+
 func Test_ShouldDeleteUserByID(t *testing.T) {
 	token, _ := service.GetJWTToken(context.Background(), &proto.GetJWTTokenRequest{
 		Username: "deleteme",
 		Password: "password",
 	})
-	ctx := metadata.NewIncomingContext(
-		context.Background(),
-		metadata.Pairs(authHeader, fmt.Sprintf("Bearer %v", token.Token)),
-	)
+	ctx := authContext(token.Token)
 	resp, err := service.DeleteUserByID(ctx, &proto.DeleteUserByIDRequest{
 		UserID: 1,
 	})
@@ -167,7 +208,13 @@ func Test_ShouldUpdateUserInfo(t *testing.T) {
 		City: "tester",
 	})
 
-	resp, err := service.UpdateUserInfo(context.Background(), &proto.UpdateUserInfoRequest{
+	token, _ := service.GetJWTToken(context.Background(), &proto.GetJWTTokenRequest{
+		Username: "updateme",
+		Password: "updateme",
+	})
+	ctx := authContext(token.Token)
+
+	resp, err := service.UpdateUserInfo(ctx, &proto.UpdateUserInfoRequest{
 		UserID:          res.CreatedUser.UserID,
 		Email:           "iamupdated@gmail.com",
 		DesiredUsername: "iamupdated",
@@ -178,13 +225,20 @@ func Test_ShouldUpdateUserInfo(t *testing.T) {
 		t.Errorf("Got an error updating a user with valid items")
 	}
 	usr := resp.GetUpdatedUser()
-	if resp.Success != true || usr.City != "updated" || usr.Bio != "BIObioBIO" || usr.Email != "iamupdated@gmail.com" {
+	// the new email stays pending until it is verified
+	if resp.Success != true || usr.City != "updated" || usr.Bio != "BIObioBIO" || usr.Email != "updateme@gmail.com" {
 		t.Errorf("Updated user does not have expected information")
 	}
 }
 
 func Test_ShouldFailUpdateUserInfo(t *testing.T) {
-	resp, err := service.UpdateUserInfo(context.Background(), &proto.UpdateUserInfoRequest{
+	token, _ := service.GetJWTToken(context.Background(), &proto.GetJWTTokenRequest{
+		Username: "admin",
+		Password: "password",
+	})
+	ctx := authContext(token.Token)
+
+	resp, err := service.UpdateUserInfo(ctx, &proto.UpdateUserInfoRequest{
 		UserID:          1000,
 		Email:           "iamupdated@gmail.com",
 		DesiredUsername: "iamupdated",
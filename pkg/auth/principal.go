@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Principal - the verified caller of an RPC, taken from its access token or API key
+type Principal struct {
+	UserID    int64
+	Username  string
+	Roles     []string
+	TokenID   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// the login the token descends from, empty for tokens issued before sessions were recorded
+	SessionID string
+	// when the caller last logged in, zero when unknown
+	AuthTime time.Time
+	// set for service accounts instead of UserID, which is then 0, Username is the name of the account
+	ServiceAccountID int64
+	// the RPCs a service account may call, unused for users
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// NewContext - attach the caller to the context of a call
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext - get the caller attached by NewContext, false for unauthenticated calls
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// HasRole - whether the caller has at least one of the roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range p.Roles {
+		for _, want := range roles {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsServiceAccount - whether the caller is a machine caller rather than a user
+func (p *Principal) IsServiceAccount() bool {
+	return p.ServiceAccountID != 0
+}
+
+// HasScope - whether a service account may call the method, either named by one of its scopes or matched by a
+// scope ending in /* like "/kic.users.Users/*"
+func (p *Principal) HasScope(method string) bool {
+	for _, scope := range p.Scopes {
+		if scope == method {
+			return true
+		}
+		if strings.HasSuffix(scope, "/*") && strings.HasPrefix(method, strings.TrimSuffix(scope, "*")) {
+			return true
+		}
+	}
+	return false
+}
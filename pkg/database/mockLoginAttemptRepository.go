@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockLoginAttemptRepository struct {
+	mu sync.Mutex
+	db map[string]*LoginAttemptModel
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockLoginAttemptRepository(db map[string]*LoginAttemptModel, logger *zap.SugaredLogger) *MockLoginAttemptRepository {
+	return &MockLoginAttemptRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *MockLoginAttemptRepository) GetLoginAttempts(ctx context.Context, subject string) (*LoginAttemptModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if attempts, ok := m.db[subject]; ok {
+		copied := *attempts
+		return &copied, nil
+	}
+	return &LoginAttemptModel{Subject: subject}, nil
+}
+
+func (m *MockLoginAttemptRepository) RecordLoginFailure(ctx context.Context, subject string, at time.Time, resetBefore time.Time) (*LoginAttemptModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.db[subject]
+	if !ok {
+		attempts = &LoginAttemptModel{Subject: subject}
+		m.db[subject] = attempts
+	}
+
+	if attempts.LastFailure.Before(resetBefore) {
+		attempts.Failures = 0
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = at
+	attempts.UpdatedAt = at
+
+	copied := *attempts
+	return &copied, nil
+}
+
+func (m *MockLoginAttemptRepository) LockLogin(ctx context.Context, subject string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, ok := m.db[subject]
+	if !ok {
+		attempts = &LoginAttemptModel{Subject: subject}
+		m.db[subject] = attempts
+	}
+
+	attempts.LockedUntil = until
+	return nil
+}
+
+func (m *MockLoginAttemptRepository) ResetLoginAttempts(ctx context.Context, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.db, subject)
+	return nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockMFARepository struct {
+	mu    sync.Mutex
+	db    map[uint]*MFAModel
+	codes map[uint][]*RecoveryCodeModel
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockMFARepository(db map[uint]*MFAModel, logger *zap.SugaredLogger) *MockMFARepository {
+	return &MockMFARepository{
+		db:     db,
+		codes:  make(map[uint][]*RecoveryCodeModel),
+		logger: logger,
+	}
+}
+
+func (m *MockMFARepository) GetMFA(ctx context.Context, userID uint) (*MFAModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mfa, ok := m.db[userID]; ok {
+		copied := *mfa
+		return &copied, nil
+	}
+	return &MFAModel{UserID: userID}, nil
+}
+
+func (m *MockMFARepository) SaveMFA(ctx context.Context, mfa *MFAModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *mfa
+	copied.UpdatedAt = time.Now()
+	m.db[mfa.UserID] = &copied
+	return nil
+}
+
+func (m *MockMFARepository) DeleteMFA(ctx context.Context, userID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.db, userID)
+	delete(m.codes, userID)
+	return nil
+}
+
+func (m *MockMFARepository) UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mfa, ok := m.db[userID]
+	if !ok || mfa.LastUsedStep >= step {
+		return false, nil
+	}
+
+	mfa.LastUsedStep = step
+	return true, nil
+}
+
+func (m *MockMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	codes := make([]*RecoveryCodeModel, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, &RecoveryCodeModel{
+			UserID:   userID,
+			CodeHash: hash,
+		})
+	}
+
+	m.codes[userID] = codes
+	return nil
+}
+
+func (m *MockMFARepository) UseRecoveryCode(ctx context.Context, userID uint, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, code := range m.codes[userID] {
+		if code.CodeHash == hash && code.UsedAt == nil {
+			now := time.Now()
+			code.UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockOneTimeTokenRepository struct {
+	mu sync.Mutex
+	db map[string]*OneTimeTokenModel
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockOneTimeTokenRepository(db map[string]*OneTimeTokenModel, logger *zap.SugaredLogger) *MockOneTimeTokenRepository {
+	return &MockOneTimeTokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *MockOneTimeTokenRepository) AddOneTimeToken(ctx context.Context, token *OneTimeTokenModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *token
+	m.db[token.TokenHash] = &copied
+	return nil
+}
+
+func (m *MockOneTimeTokenRepository) GetOneTimeToken(ctx context.Context, purpose string, hash string) (*OneTimeTokenModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.db[hash]
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !time.Now().Before(token.ExpiresAt) {
+		return nil, ErrOneTimeTokenInvalid
+	}
+
+	copied := *token
+	return &copied, nil
+}
+
+func (m *MockOneTimeTokenRepository) UseOneTimeToken(ctx context.Context, purpose string, hash string) (*OneTimeTokenModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.db[hash]
+	now := time.Now()
+	if !ok || token.Purpose != purpose || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return nil, ErrOneTimeTokenInvalid
+	}
+
+	token.UsedAt = &now
+
+	copied := *token
+	return &copied, nil
+}
+
+func (m *MockOneTimeTokenRepository) RevokeUserOneTimeTokens(ctx context.Context, userID uint, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range m.db {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MockOneTimeTokenRepository) RecordOneTimeTokenFailure(ctx context.Context, userID uint, purpose string, maxAttempts int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range m.db {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.Attempts++
+			if token.Attempts >= maxAttempts {
+				token.UsedAt = &now
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockRefreshTokenRepository struct {
+	mu sync.Mutex
+	db map[string]*RefreshTokenModel
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockRefreshTokenRepository(db map[string]*RefreshTokenModel, logger *zap.SugaredLogger) *MockRefreshTokenRepository {
+	return &MockRefreshTokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *MockRefreshTokenRepository) AddRefreshToken(ctx context.Context, token *RefreshTokenModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.db[token.TokenHash]; ok {
+		return errors.New("refresh token already exists")
+	}
+	m.db[token.TokenHash] = token
+	return nil
+}
+
+func (m *MockRefreshTokenRepository) GetRefreshToken(ctx context.Context, hash string) (*RefreshTokenModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if val, ok := m.db[hash]; ok {
+		copied := *val
+		return &copied, nil
+	}
+	return nil, errors.New("refresh token not found")
+}
+
+func (m *MockRefreshTokenRepository) RotateRefreshToken(ctx context.Context, hash string, next *RefreshTokenModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	val, ok := m.db[hash]
+	if !ok {
+		return errors.New("refresh token not found")
+	}
+	if val.RotatedAt != nil || val.RevokedAt != nil {
+		return ErrRefreshTokenUsed
+	}
+	now := time.Now()
+	val.RotatedAt = &now
+	m.db[next.TokenHash] = next
+	return nil
+}
+
+func (m *MockRefreshTokenRepository) RevokeRefreshTokenSession(ctx context.Context, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, val := range m.db {
+		if val.SessionID == sessionID && val.RevokedAt == nil {
+			val.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MockRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, val := range m.db {
+		if val.UserID == userID && val.RevokedAt == nil {
+			val.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *MockRefreshTokenRepository) RevokeOtherRefreshTokenSessions(ctx context.Context, userID uint, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, val := range m.db {
+		if val.UserID == userID && val.SessionID != sessionID && val.RevokedAt == nil {
+			val.RevokedAt = &now
+		}
+	}
+	return nil
+}
@@ -49,6 +49,15 @@ func (m *MockRepository) GetUserByID(ctx context.Context, id int64) (*UserModel,
 	return nil, errors.New("user not found")
 }
 
+func (m *MockRepository) GetUserByEmail(ctx context.Context, email string) (*UserModel, error) {
+	for _, val := range m.db {
+		if val.Email == email {
+			return val, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
 func (m *MockRepository) DeleteUserByID(ctx context.Context, id int64) error {
 	if _, ok := m.db[uint(id)]; ok {
 		delete(m.db, uint(id))
@@ -58,9 +67,64 @@ func (m *MockRepository) DeleteUserByID(ctx context.Context, id int64) error {
 }
 
 func (m *MockRepository) UpdateUserInfo(ctx context.Context, user *UserModel) error {
-	if _, ok := m.db[user.ID]; !ok {
+	existing, ok := m.db[user.ID]
+	if !ok {
 		return errors.New("update user not found")
 	}
-	m.db[user.ID] = user
+	// mirror the SQL repository, which only updates the fields that were set
+	if user.Email != "" {
+		existing.Email = user.Email
+	}
+	if user.Username != "" {
+		existing.Username = user.Username
+	}
+	if user.Password != "" {
+		existing.Password = user.Password
+	}
+	if !user.Birthday.IsZero() {
+		existing.Birthday = user.Birthday
+	}
+	if user.City != "" {
+		existing.City = user.City
+	}
+	if user.Bio != "" {
+		existing.Bio = user.Bio
+	}
+	if user.Triggers != "" {
+		existing.Triggers = user.Triggers
+	}
+	if user.Private != "" {
+		existing.Private = user.Private
+	}
+	if user.Roles != "" {
+		existing.Roles = user.Roles
+	}
+	if user.PendingEmail != "" {
+		for id, val := range m.db {
+			if id != user.ID && val.Email == user.PendingEmail {
+				return errors.New("email taken")
+			}
+		}
+		existing.PendingEmail = user.PendingEmail
+	}
+	return nil
+}
+
+func (m *MockRepository) ConfirmEmail(ctx context.Context, userID int64, email string) error {
+	existing, ok := m.db[uint(userID)]
+	if !ok {
+		return errors.New("user not found")
+	}
+	for id, val := range m.db {
+		if id != uint(userID) && val.Email == email {
+			return errors.New("email taken")
+		}
+	}
+	if existing.Email != email && existing.PendingEmail != email {
+		return errors.New("email is no longer the current or pending email of the user")
+	}
+	existing.Email = email
+	existing.EmailVerified = true
+	existing.PendingEmail = ""
 	return nil
 }
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockRevocationRepository struct {
+	mu    sync.Mutex
+	db    map[string]*RevokedTokenModel
+	users map[uint]time.Time
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockRevocationRepository(db map[string]*RevokedTokenModel, logger *zap.SugaredLogger) *MockRevocationRepository {
+	return &MockRevocationRepository{
+		db:     db,
+		users:  map[uint]time.Time{},
+		logger: logger,
+	}
+}
+
+func (m *MockRevocationRepository) RevokeToken(ctx context.Context, token *RevokedTokenModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.db[token.TokenID]; !ok {
+		m.db[token.TokenID] = token
+	}
+	return nil
+}
+
+func (m *MockRevocationRepository) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.db[tokenID]
+	return ok, nil
+}
+
+func (m *MockRevocationRepository) RevokeUserTokens(ctx context.Context, userID uint, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[userID] = before
+	return nil
+}
+
+func (m *MockRevocationRepository) GetUserRevocation(ctx context.Context, userID uint) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.users[userID], nil
+}
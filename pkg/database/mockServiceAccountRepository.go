@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockServiceAccountRepository struct {
+	mu sync.Mutex
+	db map[uint]*ServiceAccountModel
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockServiceAccountRepository(db map[uint]*ServiceAccountModel, logger *zap.SugaredLogger) *MockServiceAccountRepository {
+	return &MockServiceAccountRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *MockServiceAccountRepository) AddServiceAccount(ctx context.Context, account *ServiceAccountModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lastID uint
+	for id, existing := range m.db {
+		if existing.Name == account.Name {
+			return ErrServiceAccountExists
+		}
+		if id > lastID {
+			lastID = id
+		}
+	}
+
+	account.ID = lastID + 1
+	account.CreatedAt = time.Now()
+
+	copied := *account
+	m.db[account.ID] = &copied
+	return nil
+}
+
+func (m *MockServiceAccountRepository) GetServiceAccount(ctx context.Context, id uint) (*ServiceAccountModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.db[id]
+	if !ok {
+		return nil, ErrServiceAccountNotFound
+	}
+
+	copied := *account
+	return &copied, nil
+}
+
+func (m *MockServiceAccountRepository) GetServiceAccountByKeyHash(ctx context.Context, hash string) (*ServiceAccountModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, account := range m.db {
+		if account.KeyHash == hash || (account.PreviousKeyHash != "" && account.PreviousKeyHash == hash) {
+			copied := *account
+			return &copied, nil
+		}
+	}
+	return nil, ErrServiceAccountNotFound
+}
+
+func (m *MockServiceAccountRepository) ListServiceAccounts(ctx context.Context) ([]*ServiceAccountModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accounts := make([]*ServiceAccountModel, 0, len(m.db))
+	for _, account := range m.db {
+		copied := *account
+		accounts = append(accounts, &copied)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID < accounts[j].ID
+	})
+	return accounts, nil
+}
+
+func (m *MockServiceAccountRepository) RotateServiceAccountKey(ctx context.Context, account *ServiceAccountModel, keyID, keyHash string, previousExpiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.db[account.ID]
+	if !ok || stored.RevokedAt != nil || stored.KeyHash != account.KeyHash {
+		return ErrServiceAccountNotFound
+	}
+
+	stored.PreviousKeyID = stored.KeyID
+	stored.PreviousKeyHash = stored.KeyHash
+	stored.PreviousKeyExpiresAt = &previousExpiresAt
+	stored.KeyID = keyID
+	stored.KeyHash = keyHash
+	return nil
+}
+
+func (m *MockServiceAccountRepository) RevokeServiceAccount(ctx context.Context, id uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.db[id]
+	if !ok {
+		return ErrServiceAccountNotFound
+	}
+
+	if account.RevokedAt == nil {
+		now := time.Now()
+		account.RevokedAt = &now
+	}
+	return nil
+}
+
+func (m *MockServiceAccountRepository) TouchServiceAccount(ctx context.Context, id uint, lastUsed time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	account, ok := m.db[id]
+	if !ok {
+		return ErrServiceAccountNotFound
+	}
+
+	account.LastUsedAt = &lastUsed
+	return nil
+}
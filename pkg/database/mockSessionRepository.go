@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type MockSessionRepository struct {
+	mu sync.Mutex
+	db map[string]*SessionModel
+
+	logger *zap.SugaredLogger
+}
+
+func NewMockSessionRepository(db map[string]*SessionModel, logger *zap.SugaredLogger) *MockSessionRepository {
+	return &MockSessionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (m *MockSessionRepository) AddSession(ctx context.Context, session *SessionModel) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *session
+	m.db[session.ID] = &copied
+	return nil
+}
+
+func (m *MockSessionRepository) GetSession(ctx context.Context, id string) (*SessionModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.db[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	copied := *session
+	return &copied, nil
+}
+
+func (m *MockSessionRepository) ListUserSessions(ctx context.Context, userID uint) ([]*SessionModel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []*SessionModel
+	for _, session := range m.db {
+		if session.UserID == userID && session.RevokedAt == nil {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (m *MockSessionRepository) TouchSession(ctx context.Context, id string, lastSeen time.Time, tokenID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.db[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	session.LastSeenAt = lastSeen
+	if tokenID != "" {
+		session.TokenID = tokenID
+	}
+	return nil
+}
+
+func (m *MockSessionRepository) RevokeSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.db[id]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (m *MockSessionRepository) RevokeUserSessions(ctx context.Context, userID uint, except string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range m.db {
+		if session.UserID == userID && id != except && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
@@ -3,9 +3,27 @@ package database
 import (
 	pbcommon "github.com/kic/users/pkg/proto/common"
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
+// Roles a user can have, stored comma separated in UserModel.Roles
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+	RoleService   = "service"
+)
+
+// IsValidRole - whether the role is one of the roles above
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleUser, RoleModerator, RoleAdmin, RoleService:
+		return true
+	}
+	return false
+}
+
 type UserModel struct {
 	gorm.Model
 	Email    string
@@ -16,6 +34,25 @@ type UserModel struct {
 	Bio      string
 	Triggers string
 	Private  string
+	Roles    string `gorm:"size:255;default:user"`
+	// Set once the user followed a link sent to Email
+	EmailVerified bool `gorm:"default:false"`
+	// Address the user asked to change Email to, it only replaces Email once verified
+	PendingEmail string
+}
+
+// RoleList - the roles of the user, users created before roles existed are regular users
+func (u *UserModel) RoleList() []string {
+	var roles []string
+	for _, role := range strings.Split(u.Roles, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return []string{RoleUser}
+	}
+	return roles
 }
 
 func NewUserModel(
@@ -41,3 +78,131 @@ func NewUserModel(
 		Private:  private,
 	}
 }
+
+// RefreshTokenModel - a refresh token handed out alongside a JWT, only the hash of the token is stored
+type RefreshTokenModel struct {
+	gorm.Model
+	UserID    uint
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	// Every token produced by rotating a refresh token shares the session ID of the login that created the first one
+	SessionID string `gorm:"size:64;index"`
+	// When the user last proved who they are in the session, carried over when the token is rotated
+	AuthTime  time.Time
+	ExpiresAt time.Time
+	// Set once the token has been exchanged for a new one, presenting it again means it was stolen
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+// SessionModel - a login of a user on a device, shared by the refresh tokens rotated from it and the JWTs issued to
+// it through their sid claim
+type SessionModel struct {
+	ID        string `gorm:"size:64;primaryKey"`
+	UserID    uint   `gorm:"index"`
+	UserAgent string `gorm:"size:255"`
+	ClientIP  string `gorm:"size:64"`
+	// jti of the latest JWT issued to the session
+	TokenID    string `gorm:"size:64"`
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	RevokedAt  *time.Time
+}
+
+// ServiceAccountModel - a machine caller like an internal job, which authenticates with an API key instead of
+// logging in as a user. Only the hashes of its keys are stored.
+type ServiceAccountModel struct {
+	gorm.Model
+	// names stay taken after the account is revoked
+	Name        string `gorm:"size:64;uniqueIndex"`
+	Description string
+	// the RPCs the account may call, stored comma separated
+	Scopes string `gorm:"size:1024"`
+	// the admin who created the account
+	CreatedBy uint
+	// identifies the current key without revealing it, JWTs exchanged for the key carry it
+	KeyID   string `gorm:"size:16"`
+	KeyHash string `gorm:"size:64;uniqueIndex"`
+	// the key replaced by the last rotation, still accepted until PreviousKeyExpiresAt so callers can switch over
+	PreviousKeyID        string `gorm:"size:16"`
+	PreviousKeyHash      string `gorm:"size:64;index"`
+	PreviousKeyExpiresAt *time.Time
+	LastUsedAt           *time.Time
+	RevokedAt            *time.Time
+}
+
+// ScopeList - the RPCs the service account may call
+func (a *ServiceAccountModel) ScopeList() []string {
+	var scopes []string
+	for _, scope := range strings.Split(a.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// RevokedTokenModel - the ID of a JWT that must no longer be accepted even though it has not expired yet
+type RevokedTokenModel struct {
+	gorm.Model
+	TokenID   string `gorm:"size:64;uniqueIndex"`
+	UserID    uint
+	ExpiresAt time.Time
+}
+
+// UserRevocationModel - every JWT issued to the user before RevokedBefore must no longer be accepted
+type UserRevocationModel struct {
+	UserID        uint `gorm:"primaryKey;autoIncrement:false"`
+	RevokedBefore time.Time
+	UpdatedAt     time.Time
+}
+
+// LoginAttemptModel - recent failed logins of a username or client IP, like "user:qdn123" or "ip:10.0.0.1"
+type LoginAttemptModel struct {
+	Subject     string `gorm:"size:255;primaryKey"`
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
+
+// MFAModel - the TOTP second factor of a user, logins only ask for a code once it is Enabled
+type MFAModel struct {
+	UserID uint `gorm:"primaryKey;autoIncrement:false"`
+	// base32 encoded shared secret the authenticator app generates codes from
+	Secret  string `gorm:"size:64"`
+	Enabled bool
+	// the last time step a code was accepted for, so an intercepted code cannot be used again
+	LastUsedStep int64
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// RecoveryCodeModel - a single use code that stands in for a TOTP code, only the hash of the code is stored
+type RecoveryCodeModel struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"size:64;index"`
+	UsedAt   *time.Time
+}
+
+// What a one time token can be used for
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeLoginCode         = "login_code"
+)
+
+// OneTimeTokenModel - a single use token sent to a user out of band, like a password reset link, only the hash of
+// the token is stored
+type OneTimeTokenModel struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	Purpose   string `gorm:"size:32"`
+	TokenHash string `gorm:"size:64;uniqueIndex"`
+	// the address an email verification token was sent to
+	Email string
+	// wrong guesses at short codes, which are given up on after too many
+	Attempts  int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
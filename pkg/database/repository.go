@@ -2,8 +2,25 @@ package database
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
+// ErrRefreshTokenUsed - returned when rotating a refresh token that was already rotated or revoked
+var ErrRefreshTokenUsed = errors.New("refresh token already used")
+
+// ErrOneTimeTokenInvalid - returned when using a one time token that does not exist, expired or was already used
+var ErrOneTimeTokenInvalid = errors.New("one time token invalid or already used")
+
+// ErrSessionNotFound - returned when getting a session that does not exist
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrServiceAccountNotFound - returned when getting or changing a service account that does not exist
+var ErrServiceAccountNotFound = errors.New("service account not found")
+
+// ErrServiceAccountExists - returned when adding a service account with a name that is already taken
+var ErrServiceAccountExists = errors.New("service account name taken")
+
 // Repository - interface for a data provider that interfaces between the database backend and the grpc server
 // enables the repository pattern so that we can swap out the database backend easily
 type Repository interface {
@@ -11,6 +28,112 @@ type Repository interface {
 	// Provide any info you can to get a user
 	GetUser(context.Context, *UserModel) (*UserModel, error)
 	GetUserByID(context.Context, int64) (*UserModel, error)
+	GetUserByEmail(context.Context, string) (*UserModel, error)
 	DeleteUserByID(context.Context, int64) error
 	UpdateUserInfo(context.Context, *UserModel) error
+	// Mark the address as verified, replacing Email with it if it was the pending email of the user
+	ConfirmEmail(ctx context.Context, userID int64, email string) error
+}
+
+// RefreshTokenRepository - interface for storing the refresh tokens handed out by the users service
+type RefreshTokenRepository interface {
+	AddRefreshToken(context.Context, *RefreshTokenModel) error
+	// Look up a refresh token by the hash of the token
+	GetRefreshToken(context.Context, string) (*RefreshTokenModel, error)
+	// Mark the token with the given hash as rotated and store its replacement, returns ErrRefreshTokenUsed
+	// if the token was rotated or revoked in the meantime
+	RotateRefreshToken(context.Context, string, *RefreshTokenModel) error
+	// Revoke every refresh token belonging to the given session
+	RevokeRefreshTokenSession(context.Context, string) error
+	// Revoke every refresh token belonging to the given user
+	RevokeUserRefreshTokens(context.Context, uint) error
+	// Revoke every refresh token belonging to the given user outside of the given session
+	RevokeOtherRefreshTokenSessions(context.Context, uint, string) error
+}
+
+// SessionRepository - interface for keeping track of where users are logged in
+type SessionRepository interface {
+	AddSession(context.Context, *SessionModel) error
+	// Get a session by ID, returns ErrSessionNotFound if there is no such session
+	GetSession(context.Context, string) (*SessionModel, error)
+	// Every session of the user that was not revoked, most recently seen first
+	ListUserSessions(context.Context, uint) ([]*SessionModel, error)
+	// Record that the session was used at the given time, along with the jti of its latest JWT if it got a new one
+	TouchSession(ctx context.Context, id string, lastSeen time.Time, tokenID string) error
+	RevokeSession(context.Context, string) error
+	// Revoke every session of the user except the one with the given ID, which may be empty to revoke them all
+	RevokeUserSessions(ctx context.Context, userID uint, except string) error
+}
+
+// RevocationRepository - interface for keeping track of JWTs that were revoked before they expired
+type RevocationRepository interface {
+	RevokeToken(context.Context, *RevokedTokenModel) error
+	IsTokenRevoked(context.Context, string) (bool, error)
+	// Revoke every token issued to the user before the given time
+	RevokeUserTokens(context.Context, uint, time.Time) error
+	// Get the time before which tokens issued to the user are revoked, the zero time if there is none
+	GetUserRevocation(context.Context, uint) (time.Time, error)
+}
+
+// LoginAttemptRepository - interface for tracking failed logins to slow down password guessing
+type LoginAttemptRepository interface {
+	// Get the failed logins of a subject, a model without failures if there are none
+	GetLoginAttempts(context.Context, string) (*LoginAttemptModel, error)
+	// Count a failed login at the given time, starting over when the last failure is older than the reset time
+	RecordLoginFailure(ctx context.Context, subject string, at time.Time, resetBefore time.Time) (*LoginAttemptModel, error)
+	// Refuse logins of the subject until the given time
+	LockLogin(context.Context, string, time.Time) error
+	// Forget the failed logins of a subject and lift its lock
+	ResetLoginAttempts(context.Context, string) error
+}
+
+// MFARepository - interface for storing the TOTP second factors and recovery codes of users
+type MFARepository interface {
+	// Get the second factor of a user, a model that is not enabled if they have none
+	GetMFA(context.Context, uint) (*MFAModel, error)
+	// Store the second factor of a user, replacing the one they had
+	SaveMFA(context.Context, *MFAModel) error
+	// Remove the second factor and recovery codes of a user
+	DeleteMFA(context.Context, uint) error
+	// Record a code being accepted for a time step, false if a code for the same or a later step was already used
+	UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error)
+	// Replace the recovery codes of a user with the given hashes
+	ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error
+	// Mark an unused recovery code of the user as used, false if they have no such unused code
+	UseRecoveryCode(ctx context.Context, userID uint, hash string) (bool, error)
+}
+
+// OneTimeTokenRepository - interface for storing single use tokens like password reset links
+type OneTimeTokenRepository interface {
+	AddOneTimeToken(context.Context, *OneTimeTokenModel) error
+	// The unexpired, unused token with the given purpose and hash without using it up, returns
+	// ErrOneTimeTokenInvalid if there is no such token
+	GetOneTimeToken(ctx context.Context, purpose string, hash string) (*OneTimeTokenModel, error)
+	// Mark the unexpired, unused token with the given purpose and hash as used and return it, returns
+	// ErrOneTimeTokenInvalid if there is no such token
+	UseOneTimeToken(ctx context.Context, purpose string, hash string) (*OneTimeTokenModel, error)
+	// Revoke every unused token of the user with the given purpose
+	RevokeUserOneTimeTokens(ctx context.Context, userID uint, purpose string) error
+	// Count a wrong guess against every unused token of the user with the given purpose, revoking those that
+	// reach maxAttempts
+	RecordOneTimeTokenFailure(ctx context.Context, userID uint, purpose string, maxAttempts int) error
+}
+
+// ServiceAccountRepository - interface for storing service accounts and the hashes of their API keys
+type ServiceAccountRepository interface {
+	// Add the account and set its ID, returns ErrServiceAccountExists if the name is taken
+	AddServiceAccount(context.Context, *ServiceAccountModel) error
+	// Get an account by ID, returns ErrServiceAccountNotFound if there is no such account
+	GetServiceAccount(context.Context, uint) (*ServiceAccountModel, error)
+	// Get the account whose current or previous key has the given hash, returns ErrServiceAccountNotFound if
+	// there is none
+	GetServiceAccountByKeyHash(context.Context, string) (*ServiceAccountModel, error)
+	// Every account, revoked ones included, oldest first
+	ListServiceAccounts(context.Context) ([]*ServiceAccountModel, error)
+	// Replace the current key of the account with a new one and keep the current key until previousExpiresAt,
+	// returns ErrServiceAccountNotFound if the account was revoked or its key rotated in the meantime
+	RotateServiceAccountKey(ctx context.Context, account *ServiceAccountModel, keyID, keyHash string, previousExpiresAt time.Time) error
+	RevokeServiceAccount(context.Context, uint) error
+	// Record that the account authenticated at the given time
+	TouchServiceAccount(ctx context.Context, id uint, lastUsed time.Time) error
 }
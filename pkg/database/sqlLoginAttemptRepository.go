@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SQLLoginAttemptRepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLLoginAttemptRepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLLoginAttemptRepository {
+	return &SQLLoginAttemptRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLLoginAttemptRepository) GetLoginAttempts(ctx context.Context, subject string) (*LoginAttemptModel, error) {
+	attempts := &LoginAttemptModel{}
+	transaction := s.db.WithContext(ctx).Where("subject = ?", subject).First(attempts)
+
+	if errors.Is(transaction.Error, gorm.ErrRecordNotFound) {
+		return &LoginAttemptModel{Subject: subject}, nil
+	}
+
+	return attempts, transaction.Error
+}
+
+func (s *SQLLoginAttemptRepository) RecordLoginFailure(ctx context.Context, subject string, at time.Time, resetBefore time.Time) (*LoginAttemptModel, error) {
+	attempts := &LoginAttemptModel{}
+
+	// lock the row so concurrent failures are all counted
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("subject = ?", subject).First(attempts).Error
+
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			attempts = &LoginAttemptModel{Subject: subject}
+		} else if err != nil {
+			return err
+		}
+
+		if attempts.LastFailure.Before(resetBefore) {
+			attempts.Failures = 0
+		}
+
+		attempts.Failures++
+		attempts.LastFailure = at
+
+		return tx.Save(attempts).Error
+	})
+
+	return attempts, err
+}
+
+func (s *SQLLoginAttemptRepository) LockLogin(ctx context.Context, subject string, until time.Time) error {
+	transaction := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"locked_until", "updated_at"}),
+	}).Create(&LoginAttemptModel{
+		Subject:     subject,
+		LockedUntil: until,
+	})
+
+	return transaction.Error
+}
+
+func (s *SQLLoginAttemptRepository) ResetLoginAttempts(ctx context.Context, subject string) error {
+	transaction := s.db.WithContext(ctx).Where("subject = ?", subject).Delete(&LoginAttemptModel{})
+
+	return transaction.Error
+}
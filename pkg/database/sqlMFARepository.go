@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SQLMFARepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLMFARepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLMFARepository {
+	return &SQLMFARepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLMFARepository) GetMFA(ctx context.Context, userID uint) (*MFAModel, error) {
+	mfa := &MFAModel{}
+	transaction := s.db.WithContext(ctx).Where("user_id = ?", userID).First(mfa)
+
+	if errors.Is(transaction.Error, gorm.ErrRecordNotFound) {
+		return &MFAModel{UserID: userID}, nil
+	}
+
+	return mfa, transaction.Error
+}
+
+func (s *SQLMFARepository) SaveMFA(ctx context.Context, mfa *MFAModel) error {
+	return s.db.WithContext(ctx).Save(mfa).Error
+}
+
+func (s *SQLMFARepository) DeleteMFA(ctx context.Context, userID uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCodeModel{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", userID).Delete(&MFAModel{}).Error
+	})
+}
+
+func (s *SQLMFARepository) UseTOTPStep(ctx context.Context, userID uint, step int64) (bool, error) {
+	// only one caller can move the step forward, a replayed code sees zero rows affected
+	transaction := s.db.WithContext(ctx).Model(&MFAModel{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+
+	return transaction.RowsAffected == 1, transaction.Error
+}
+
+func (s *SQLMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, hashes []string) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCodeModel{}).Error; err != nil {
+			return err
+		}
+
+		codes := make([]RecoveryCodeModel, 0, len(hashes))
+		for _, hash := range hashes {
+			codes = append(codes, RecoveryCodeModel{
+				UserID:   userID,
+				CodeHash: hash,
+			})
+		}
+
+		if len(codes) == 0 {
+			return nil
+		}
+
+		return tx.Create(&codes).Error
+	})
+}
+
+func (s *SQLMFARepository) UseRecoveryCode(ctx context.Context, userID uint, hash string) (bool, error) {
+	transaction := s.db.WithContext(ctx).Model(&RecoveryCodeModel{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Limit(1).
+		Update("used_at", time.Now())
+
+	return transaction.RowsAffected == 1, transaction.Error
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SQLOneTimeTokenRepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLOneTimeTokenRepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLOneTimeTokenRepository {
+	return &SQLOneTimeTokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLOneTimeTokenRepository) AddOneTimeToken(ctx context.Context, token *OneTimeTokenModel) error {
+	return s.db.WithContext(ctx).Create(token).Error
+}
+
+func (s *SQLOneTimeTokenRepository) GetOneTimeToken(ctx context.Context, purpose string, hash string) (*OneTimeTokenModel, error) {
+	token := &OneTimeTokenModel{}
+
+	err := s.db.WithContext(ctx).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, time.Now()).
+		First(token).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOneTimeTokenInvalid
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *SQLOneTimeTokenRepository) UseOneTimeToken(ctx context.Context, purpose string, hash string) (*OneTimeTokenModel, error) {
+	token := &OneTimeTokenModel{}
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// only one caller can win the update, anyone else presenting the same token sees zero rows affected
+		res := tx.Model(&OneTimeTokenModel{}).
+			Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return ErrOneTimeTokenInvalid
+		}
+
+		return tx.Where("token_hash = ?", hash).First(token).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *SQLOneTimeTokenRepository) RevokeUserOneTimeTokens(ctx context.Context, userID uint, purpose string) error {
+	transaction := s.db.WithContext(ctx).Model(&OneTimeTokenModel{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now())
+
+	return transaction.Error
+}
+
+func (s *SQLOneTimeTokenRepository) RecordOneTimeTokenFailure(ctx context.Context, userID uint, purpose string, maxAttempts int) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&OneTimeTokenModel{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("attempts", gorm.Expr("attempts + 1")).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&OneTimeTokenModel{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL AND attempts >= ?", userID, purpose, maxAttempts).
+			Update("used_at", time.Now()).Error
+	})
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SQLRefreshTokenRepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLRefreshTokenRepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLRefreshTokenRepository {
+	return &SQLRefreshTokenRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLRefreshTokenRepository) AddRefreshToken(ctx context.Context, token *RefreshTokenModel) error {
+	return s.db.WithContext(ctx).Create(token).Error
+}
+
+func (s *SQLRefreshTokenRepository) GetRefreshToken(ctx context.Context, hash string) (*RefreshTokenModel, error) {
+	toReturn := &RefreshTokenModel{}
+	transaction := s.db.WithContext(ctx).Where("token_hash = ?", hash).First(toReturn)
+
+	return toReturn, transaction.Error
+}
+
+func (s *SQLRefreshTokenRepository) RotateRefreshToken(ctx context.Context, hash string, next *RefreshTokenModel) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// only one caller can win the update, anyone else presenting the same token sees zero rows affected
+		res := tx.Model(&RefreshTokenModel{}).
+			Where("token_hash = ? AND rotated_at IS NULL AND revoked_at IS NULL", hash).
+			Update("rotated_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			s.logger.Debugf("Refresh token %v was already rotated or revoked", hash)
+			return ErrRefreshTokenUsed
+		}
+
+		return tx.Create(next).Error
+	})
+}
+
+func (s *SQLRefreshTokenRepository) RevokeRefreshTokenSession(ctx context.Context, sessionID string) error {
+	transaction := s.db.WithContext(ctx).Model(&RefreshTokenModel{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now())
+
+	return transaction.Error
+}
+
+func (s *SQLRefreshTokenRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	transaction := s.db.WithContext(ctx).Model(&RefreshTokenModel{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+
+	return transaction.Error
+}
+
+func (s *SQLRefreshTokenRepository) RevokeOtherRefreshTokenSessions(ctx context.Context, userID uint, sessionID string) error {
+	transaction := s.db.WithContext(ctx).Model(&RefreshTokenModel{}).
+		Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userID, sessionID).
+		Update("revoked_at", time.Now())
+
+	return transaction.Error
+}
@@ -3,6 +3,8 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/kic/users/pkg/auth"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
@@ -82,12 +84,60 @@ func (s *SQLRepository) GetUserByID(ctx context.Context, id int64) (*UserModel,
 	return toReturn, transaction.Error
 }
 
+func (s *SQLRepository) GetUserByEmail(ctx context.Context, email string) (*UserModel, error) {
+	toReturn := &UserModel{}
+	transaction := s.db.WithContext(ctx).Where("email = ?", email).First(&toReturn)
+
+	return toReturn, transaction.Error
+}
+
+func (s *SQLRepository) ConfirmEmail(ctx context.Context, userID int64, email string) error {
+	s.logger.Infof("Confirming email of user %v", userID)
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var taken int64
+		err := tx.Model(&UserModel{}).Where("email = ? AND id <> ?", email, userID).Count(&taken).Error
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return errors.New("email taken")
+		}
+
+		res := tx.Model(&UserModel{}).
+			Where("id = ? AND (email = ? OR pending_email = ?)", userID, email, email).
+			Updates(map[string]interface{}{
+				"email":          email,
+				"email_verified": true,
+				"pending_email":  "",
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			return errors.New("email is no longer the current or pending email of the user")
+		}
+		return nil
+	})
+}
+
+// actor - describe the caller the server attached to the context, for the audit log of changes
+func actor(ctx context.Context) string {
+	if caller, ok := auth.FromContext(ctx); ok {
+		return fmt.Sprintf("user %v", caller.UserID)
+	}
+	return "unauthenticated caller"
+}
+
 func (s *SQLRepository) DeleteUserByID(ctx context.Context, userID int64) error {
+	s.logger.Infof("Deleting user %v on behalf of %v", userID, actor(ctx))
 	transaction := s.db.Delete(&UserModel{}, userID)
 	return transaction.Error
 }
 
 func (s *SQLRepository) UpdateUserInfo(ctx context.Context, user *UserModel) error {
+	s.logger.Infof("Updating user %v on behalf of %v", user.ID, actor(ctx))
+
 	ok := true
 	var tx *gorm.DB // declaring response variable DB, which will be returned form s.db.Update()
 
@@ -172,5 +222,24 @@ func (s *SQLRepository) UpdateUserInfo(ctx context.Context, user *UserModel) err
 		}
 	}
 
+	if user.Roles != "" {
+		tx = s.db.Model(&UserModel{}).Where("id = ?", user.ID).Update("Roles", user.Roles)
+		if tx.Error != nil { // return error if there is one
+			return tx.Error
+		}
+	}
+
+	if user.PendingEmail != "" { // the new address waits for verification before replacing Email
+		if !s.checkIfEmailAvailable(user.PendingEmail) {
+			s.logger.Debug("Pending email not available")
+			return errors.New("email taken")
+		}
+
+		tx = s.db.Model(&UserModel{}).Where("id = ?", user.ID).Update("PendingEmail", user.PendingEmail)
+		if tx.Error != nil { // return error if there is one
+			return tx.Error
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SQLRevocationRepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLRevocationRepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLRevocationRepository {
+	return &SQLRevocationRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLRevocationRepository) RevokeToken(ctx context.Context, token *RevokedTokenModel) error {
+	// revoking the same token twice is not an error
+	transaction := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(token)
+
+	return transaction.Error
+}
+
+func (s *SQLRevocationRepository) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var count int64
+	transaction := s.db.WithContext(ctx).Model(&RevokedTokenModel{}).Where("token_id = ?", tokenID).Count(&count)
+
+	return count > 0, transaction.Error
+}
+
+func (s *SQLRevocationRepository) RevokeUserTokens(ctx context.Context, userID uint, before time.Time) error {
+	transaction := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"revoked_before", "updated_at"}),
+	}).Create(&UserRevocationModel{
+		UserID:        userID,
+		RevokedBefore: before,
+	})
+
+	return transaction.Error
+}
+
+func (s *SQLRevocationRepository) GetUserRevocation(ctx context.Context, userID uint) (time.Time, error) {
+	revocation := &UserRevocationModel{}
+	transaction := s.db.WithContext(ctx).Where("user_id = ?", userID).First(revocation)
+
+	if errors.Is(transaction.Error, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+
+	return revocation.RevokedBefore, transaction.Error
+}
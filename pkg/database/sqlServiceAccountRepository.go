@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SQLServiceAccountRepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLServiceAccountRepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLServiceAccountRepository {
+	return &SQLServiceAccountRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLServiceAccountRepository) AddServiceAccount(ctx context.Context, account *ServiceAccountModel) error {
+	var taken int64
+	err := s.db.WithContext(ctx).Model(&ServiceAccountModel{}).Where("name = ?", account.Name).Count(&taken).Error
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrServiceAccountExists
+	}
+
+	return s.db.WithContext(ctx).Create(account).Error
+}
+
+func (s *SQLServiceAccountRepository) GetServiceAccount(ctx context.Context, id uint) (*ServiceAccountModel, error) {
+	account := &ServiceAccountModel{}
+	err := s.db.WithContext(ctx).First(account, id).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrServiceAccountNotFound
+	}
+
+	return account, err
+}
+
+func (s *SQLServiceAccountRepository) GetServiceAccountByKeyHash(ctx context.Context, hash string) (*ServiceAccountModel, error) {
+	account := &ServiceAccountModel{}
+	err := s.db.WithContext(ctx).Where("key_hash = ? OR previous_key_hash = ?", hash, hash).First(account).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrServiceAccountNotFound
+	}
+
+	return account, err
+}
+
+func (s *SQLServiceAccountRepository) ListServiceAccounts(ctx context.Context) ([]*ServiceAccountModel, error) {
+	var accounts []*ServiceAccountModel
+	err := s.db.WithContext(ctx).Order("id").Find(&accounts).Error
+
+	return accounts, err
+}
+
+func (s *SQLServiceAccountRepository) RotateServiceAccountKey(ctx context.Context, account *ServiceAccountModel, keyID, keyHash string, previousExpiresAt time.Time) error {
+	// the old key comes from the account rather than the row, so the update does not depend on the order the
+	// columns are assigned in
+	res := s.db.WithContext(ctx).Model(&ServiceAccountModel{}).
+		Where("id = ? AND key_hash = ? AND revoked_at IS NULL", account.ID, account.KeyHash).
+		Updates(map[string]interface{}{
+			"previous_key_id":         account.KeyID,
+			"previous_key_hash":       account.KeyHash,
+			"previous_key_expires_at": previousExpiresAt,
+			"key_id":                  keyID,
+			"key_hash":                keyHash,
+		})
+
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return ErrServiceAccountNotFound
+	}
+	return nil
+}
+
+func (s *SQLServiceAccountRepository) RevokeServiceAccount(ctx context.Context, id uint) error {
+	res := s.db.WithContext(ctx).Model(&ServiceAccountModel{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+
+	return res.Error
+}
+
+func (s *SQLServiceAccountRepository) TouchServiceAccount(ctx context.Context, id uint, lastUsed time.Time) error {
+	return s.db.WithContext(ctx).Model(&ServiceAccountModel{}).
+		Where("id = ?", id).
+		Update("last_used_at", lastUsed).Error
+}
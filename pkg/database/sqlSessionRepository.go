@@ -0,0 +1,82 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type SQLSessionRepository struct {
+	db *gorm.DB
+
+	logger *zap.SugaredLogger
+}
+
+func NewSQLSessionRepository(db *gorm.DB, logger *zap.SugaredLogger) *SQLSessionRepository {
+	return &SQLSessionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (s *SQLSessionRepository) AddSession(ctx context.Context, session *SessionModel) error {
+	return s.db.WithContext(ctx).Create(session).Error
+}
+
+func (s *SQLSessionRepository) GetSession(ctx context.Context, id string) (*SessionModel, error) {
+	session := &SessionModel{}
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(session).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+
+	return session, err
+}
+
+func (s *SQLSessionRepository) ListUserSessions(ctx context.Context, userID uint) ([]*SessionModel, error) {
+	var sessions []*SessionModel
+	transaction := s.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+
+	return sessions, transaction.Error
+}
+
+func (s *SQLSessionRepository) TouchSession(ctx context.Context, id string, lastSeen time.Time, tokenID string) error {
+	updates := map[string]interface{}{
+		"last_seen_at": lastSeen,
+	}
+	if tokenID != "" {
+		updates["token_id"] = tokenID
+	}
+
+	res := s.db.WithContext(ctx).Model(&SessionModel{}).Where("id = ?", id).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected != 1 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *SQLSessionRepository) RevokeSession(ctx context.Context, id string) error {
+	transaction := s.db.WithContext(ctx).Model(&SessionModel{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+
+	return transaction.Error
+}
+
+func (s *SQLSessionRepository) RevokeUserSessions(ctx context.Context, userID uint, except string) error {
+	transaction := s.db.WithContext(ctx).Model(&SessionModel{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, except).
+		Update("revoked_at", time.Now())
+
+	return transaction.Error
+}
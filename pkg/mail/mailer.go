@@ -0,0 +1,58 @@
+package mail
+
+import (
+	"context"
+	"sync"
+)
+
+// Message - a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer - interface for sending the emails of account flows like password resets, so the delivery
+// mechanism can be swapped out the same way the database backend can
+type Mailer interface {
+	Send(context.Context, *Message) error
+}
+
+// MemoryMailer - keeps every message instead of sending it, for tests and local development
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *msg)
+	return nil
+}
+
+// Sent - every message sent so far, oldest first
+func (m *MemoryMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// Last - the most recent message sent to the address, false if there is none
+func (m *MemoryMailer) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return Message{}, false
+}
@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer - sends messages through an SMTP relay, upgrading to TLS when the relay supports STARTTLS
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer - a mailer sending from the given address through the relay at addr (host:port), username
+// and password may be empty for relays that do not need authentication
+func NewSMTPMailer(addr, from, username, password string) (*SMTPMailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %v: %w", addr, err)
+	}
+
+	m := &SMTPMailer{
+		addr: addr,
+		from: from,
+	}
+
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	// a header injected through the address or subject could add recipients
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid message header")
+	}
+
+	headers := []string{
+		"From: " + m.from,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(msg.Body, "\n", "\r\n")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(body))
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,204 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// breachPrefixLength - hashes are looked up by the first 5 hex characters of their SHA-1, like the k-anonymity
+// range API of Have I Been Pwned, so the corpus can be swapped for a remote one without sending passwords anywhere
+const breachPrefixLength = 5
+
+// BreachCorpus - SHA-1 hashes of passwords that appeared in public breaches, sorted by hash and looked up with a
+// binary search where they are stored, so a corpus on disk takes no memory however many hashes it holds. The
+// corpus only holds hashes, never the passwords themselves.
+type BreachCorpus struct {
+	data io.ReaderAt
+	size int64
+	len  int
+}
+
+// LoadBreachCorpus - open a corpus file with one uppercase or lowercase hex SHA-1 per line, optionally followed by
+// :count, sorted by hash as in the "ordered by hash" Pwned Passwords download. Blank lines and lines starting with #
+// are skipped. The file is read through once to check it, then stays open for lookups that read a few dozen lines
+// each, so it must not change while it is in use.
+func LoadBreachCorpus(path string) (*BreachCorpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	corpus := &BreachCorpus{
+		data: f,
+		size: info.Size(),
+	}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	previous := ""
+	for scanner.Scan() {
+		line++
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		hash, ok := breachHash(entry)
+		if !ok {
+			f.Close()
+			return nil, fmt.Errorf("%v:%v: not a SHA-1 hash", path, line)
+		}
+		if hash < previous {
+			f.Close()
+			return nil, fmt.Errorf("%v:%v: hashes are not sorted", path, line)
+		}
+
+		previous = hash
+		corpus.len++
+	}
+
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return corpus, nil
+}
+
+// NewBreachCorpus - a corpus of the given passwords, for tests
+func NewBreachCorpus(passwords ...string) *BreachCorpus {
+	hashes := make([]string, 0, len(passwords))
+	seen := map[string]bool{}
+
+	for _, password := range passwords {
+		hash := hashPassword(password)
+		if !seen[hash] {
+			seen[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+
+	sort.Strings(hashes)
+
+	var buf bytes.Buffer
+	for _, hash := range hashes {
+		buf.WriteString(hash + "\n")
+	}
+
+	return &BreachCorpus{
+		data: bytes.NewReader(buf.Bytes()),
+		size: int64(buf.Len()),
+		len:  len(hashes),
+	}
+}
+
+// breachHash - the uppercase hash of a corpus line
+func breachHash(entry string) (string, bool) {
+	if i := strings.IndexByte(entry, ':'); i >= 0 {
+		entry = entry[:i]
+	}
+
+	if _, err := hex.DecodeString(entry); err != nil || len(entry) != 2*sha1.Size {
+		return "", false
+	}
+
+	return strings.ToUpper(entry), true
+}
+
+// lines - read the corpus line by line from the first line starting at or after off
+func (c *BreachCorpus) lines(off int64) *bufio.Reader {
+	if off > 0 {
+		// start at the byte before, so a line starting exactly at off is not skipped
+		off--
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(c.data, off, c.size-off))
+	if off > 0 {
+		r.ReadString('\n')
+	}
+	return r
+}
+
+// nextHash - the next hash, skipping blank lines and comments, io.EOF after the last
+func nextHash(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if line == "" && err != nil {
+			return "", err
+		}
+
+		if hash, ok := breachHash(strings.TrimSpace(line)); ok {
+			return hash, nil
+		}
+	}
+}
+
+// search - an offset from which the first line read is the first hash not below key
+func (c *BreachCorpus) search(key string) int64 {
+	lo, hi := int64(0), c.size
+
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		hash, err := nextHash(c.lines(mid))
+		if err != nil || hash >= key {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return lo
+}
+
+// Range - the hash suffixes of breached passwords whose SHA-1 starts with the prefix
+func (c *BreachCorpus) Range(prefix string) []string {
+	prefix = strings.ToUpper(prefix)
+
+	var suffixes []string
+	r := c.lines(c.search(prefix))
+	for {
+		hash, err := nextHash(r)
+		if err != nil || !strings.HasPrefix(hash, prefix) {
+			return suffixes
+		}
+		suffixes = append(suffixes, hash[len(prefix):])
+	}
+}
+
+// Contains - whether the password appeared in a breach
+func (c *BreachCorpus) Contains(password string) bool {
+	hash := hashPassword(password)
+	prefix, suffix := hash[:breachPrefixLength], hash[breachPrefixLength:]
+
+	for _, candidate := range c.Range(prefix) {
+		if candidate == suffix {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Len - the number of hashes in the corpus
+func (c *BreachCorpus) Len() int {
+	return c.len
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
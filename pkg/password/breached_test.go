@@ -0,0 +1,114 @@
+package password
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeCorpus - a corpus file with the given contents, removed when the test ends
+func writeCorpus(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "breaches")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "breaches.txt")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write corpus: %v", err)
+	}
+	return path
+}
+
+func Test_ShouldLoadBreachCorpus(t *testing.T) {
+	// SHA-1 of "password" in lowercase with a count, and of "letmein" in uppercase without one
+	corpus := "# pwned passwords sample\n" +
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:9545824\n" +
+		"\n" +
+		"B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3\n"
+
+	breaches, err := LoadBreachCorpus(writeCorpus(t, corpus))
+	if err != nil {
+		t.Fatalf("Failed to load corpus: %v", err)
+	}
+
+	if breaches.Len() != 2 || !breaches.Contains("password") || !breaches.Contains("letmein") {
+		t.Errorf("Corpus is missing hashes, has %v", breaches.Len())
+	}
+	if breaches.Contains("violet kettle orbit 42") {
+		t.Errorf("Corpus contains a password that was not in the file")
+	}
+	if suffixes := breaches.Range("5baa6"); len(suffixes) != 1 || suffixes[0] != "1E4C9B93F3F0682250B6CF8331B7EE68FD8" {
+		t.Errorf("Unexpected range for prefix 5BAA6: %v", suffixes)
+	}
+	if suffixes := breaches.Range("00000"); len(suffixes) != 0 {
+		t.Errorf("Unexpected range for prefix 00000: %v", suffixes)
+	}
+}
+
+func Test_ShouldRejectMalformedBreachCorpus(t *testing.T) {
+	for name, corpus := range map[string]string{
+		"plaintext password": "password\n",
+		"truncated hash":     "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD\n",
+		"unsorted hashes":    "B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3\n5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n",
+	} {
+		if _, err := LoadBreachCorpus(writeCorpus(t, corpus)); err == nil {
+			t.Errorf("Corpus with a %v was loaded", name)
+		}
+	}
+
+	if _, err := LoadBreachCorpus(filepath.Join(os.TempDir(), "no-such-breaches.txt")); err == nil {
+		t.Errorf("Missing corpus was loaded")
+	}
+}
+
+func Test_ShouldSearchLargeBreachCorpus(t *testing.T) {
+	var breached, hashes []string
+	for i := 0; i < 2000; i++ {
+		pass := fmt.Sprintf("breached%v", i)
+		breached = append(breached, pass)
+		sum := sha1.Sum([]byte(pass))
+		hashes = append(hashes, fmt.Sprintf("%X:%v", sum, i+1))
+	}
+	sort.Strings(hashes)
+
+	// the Pwned Passwords download ends lines with CRLF
+	breaches, err := LoadBreachCorpus(writeCorpus(t, "# ordered by hash\r\n"+strings.Join(hashes, "\r\n")))
+	if err != nil {
+		t.Fatalf("Failed to load corpus: %v", err)
+	}
+
+	if breaches.Len() != len(breached) {
+		t.Errorf("Expected %v hashes, got %v", len(breached), breaches.Len())
+	}
+	for _, pass := range breached {
+		if !breaches.Contains(pass) {
+			t.Fatalf("Corpus is missing %q", pass)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if pass := fmt.Sprintf("unbreached%v", i); breaches.Contains(pass) {
+			t.Fatalf("Corpus contains %q", pass)
+		}
+	}
+}
+
+func Test_ShouldBuildBreachCorpusOfPasswords(t *testing.T) {
+	breaches := NewBreachCorpus("letmein", "password", "letmein")
+
+	if breaches.Len() != 2 || !breaches.Contains("password") || !breaches.Contains("letmein") {
+		t.Errorf("Corpus is missing passwords, has %v", breaches.Len())
+	}
+	if breaches.Contains("Password") {
+		t.Errorf("Corpus contains a password it was not built with")
+	}
+	if NewBreachCorpus().Contains("password") {
+		t.Errorf("Empty corpus contains a password")
+	}
+}
@@ -0,0 +1,107 @@
+package password
+
+import (
+	"strings"
+	"unicode"
+)
+
+// dictionary - the words attackers try first with how soon they try them, the user inputs of the password being
+// checked followed by the lists below
+type dictionary map[string]int
+
+func newDictionary(userInputs []string) dictionary {
+	dict := dictionary{}
+	rank := 1
+
+	for _, input := range userInputs {
+		// both the whole input and its parts, so qdn.smith@gmail.com adds qdn and smith
+		words := append([]string{input}, strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+
+		for _, word := range words {
+			word = strings.ToLower(word)
+			if _, ok := dict[word]; !ok && len([]rune(word)) >= 3 {
+				dict[word] = rank
+				rank++
+			}
+		}
+	}
+
+	return dict
+}
+
+// rank - how many guesses in the word comes, user inputs first
+func (d dictionary) rank(word string) (int, bool) {
+	if rank, ok := d[word]; ok {
+		return rank, true
+	}
+
+	if rank, ok := commonPasswordRanks[word]; ok {
+		return rank, true
+	}
+
+	rank, ok := commonWordRanks[word]
+	return rank, ok
+}
+
+var (
+	commonPasswordRanks = ranks(commonPasswords)
+	commonWordRanks     = ranks(commonWords)
+)
+
+func ranks(words []string) map[string]int {
+	ranked := make(map[string]int, len(words))
+	for i, word := range words {
+		if _, ok := ranked[word]; !ok {
+			ranked[word] = i + 1
+		}
+	}
+	return ranked
+}
+
+// commonPasswords - the most used passwords from public leaks, most used first
+var commonPasswords = []string{
+	"123456", "password", "12345678", "qwerty", "123456789", "12345", "1234", "111111", "1234567", "dragon",
+	"123123", "baseball", "abc123", "football", "monkey", "letmein", "696969", "shadow", "master", "666666",
+	"qwertyuiop", "123321", "mustang", "1234567890", "michael", "654321", "superman", "1qaz2wsx", "7777777",
+	"121212", "000000", "qazwsx", "123qwe", "killer", "trustno1", "jordan", "jennifer", "zxcvbnm", "asdfgh",
+	"hunter", "buster", "soccer", "harley", "batman", "andrew", "tigger", "sunshine", "iloveyou", "2000",
+	"charlie", "robert", "thomas", "hockey", "ranger", "daniel", "starwars", "klaster", "112233", "george",
+	"computer", "michelle", "jessica", "pepper", "1111", "zxcvbn", "555555", "11111111", "131313", "freedom",
+	"777777", "pass", "maggie", "159753", "aaaaaa", "ginger", "princess", "joshua", "cheese", "amanda",
+	"summer", "love", "ashley", "nicole", "chelsea", "biteme", "matthew", "access", "yankees", "987654321",
+	"dallas", "austin", "thunder", "taylor", "matrix", "minecraft", "welcome", "admin", "login", "passw0rd",
+	"password1", "password123", "qwerty123", "1q2w3e4r", "1q2w3e", "qwe123", "zaq12wsx", "abcdef", "abcd1234",
+	"changeme", "secret", "default", "letmein1", "welcome1", "administrator", "root", "toor", "guest", "test",
+	"test123", "hello", "hello123", "whatever", "nothing", "starwars1", "football1", "baseball1", "iloveyou1",
+	"princess1", "sunshine1", "monkey1", "dragon1", "shadow1", "master1", "superman1", "batman1", "qwertyu",
+	"asdfghjkl", "asdf", "zxcv", "azerty", "q1w2e3r4", "1qazxsw2", "qweasdzxc", "mypassword", "mypass",
+	"passpass", "pass123", "pass1234", "p@ssw0rd", "letmeinnow", "trustme", "secret123", "internet", "samsung",
+	"google", "facebook", "instagram", "linkedin", "twitter", "apple", "banana", "orange", "chocolate",
+	"pokemon", "naruto", "liverpool", "arsenal", "chelsea1", "barcelona", "superstar", "lovely", "angel",
+	"angels", "babygirl", "family", "flower", "friends", "forever", "loveme", "lovers", "rockyou", "daniel1",
+	"jesus", "jesus1", "blessed", "heaven", "purple", "butterfly", "snoopy", "cookie", "pussy", "fuckyou",
+	"qwerty1", "killer1", "asshole", "hunter2", "whatever1", "casual", "keepingitcasual",
+}
+
+// commonWords - frequent English words and names, most frequent first
+var commonWords = []string{
+	"the", "and", "you", "that", "was", "for", "are", "with", "his", "they", "this", "have", "from", "one", "had",
+	"word", "but", "not", "what", "all", "were", "when", "your", "can", "said", "there", "use", "each", "which",
+	"she", "how", "their", "will", "other", "about", "out", "many", "then", "them", "these", "some", "her",
+	"would", "make", "like", "him", "into", "time", "has", "look", "two", "more", "write", "see", "number",
+	"way", "could", "people", "than", "first", "water", "been", "call", "who", "oil", "its", "now", "find",
+	"long", "down", "day", "did", "get", "come", "made", "may", "part", "new", "old", "good", "great", "little",
+	"big", "best", "life", "love", "home", "house", "world", "school", "work", "money", "music", "game", "play",
+	"happy", "summer", "winter", "spring", "autumn", "sunday", "monday", "friday", "january", "june", "july",
+	"december", "red", "blue", "green", "black", "white", "yellow", "orange", "silver", "gold", "dog", "cat",
+	"horse", "tiger", "lion", "bear", "eagle", "dragon", "fish", "bird", "apple", "cherry", "coffee", "pizza",
+	"beer", "star", "moon", "sun", "sky", "fire", "ice", "rock", "stone", "tree", "river", "ocean", "mountain",
+	"city", "country", "king", "queen", "prince", "princess", "angel", "devil", "god", "heart", "baby", "girl",
+	"boy", "man", "woman", "mother", "father", "sister", "brother", "friend", "secret", "magic", "power",
+	"master", "super", "hello", "welcome", "letter", "open", "door", "key", "lock", "pass", "word", "user",
+	"name", "admin", "login", "correct", "horse", "battery", "staple", "john", "david", "james", "mary",
+	"michael", "sarah", "chris", "alex", "sam", "max", "ben", "emma", "anna", "lisa", "kate", "mike", "tom",
+	"keeping", "it", "casual",
+}
@@ -0,0 +1,190 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnknownHash - returned when checking a password against a hash in a format no hasher understands
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher - interface for hashing passwords into PHC strings (like $argon2id$v=19$m=65536,t=3,p=2$salt$hash)
+// and checking passwords against them. Every hasher can check the hashes of the others, so stored hashes keep
+// working when the hasher is changed.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Check the password against a stored hash, and whether the hash uses an outdated algorithm or cost
+	// and should be replaced by a new hash of the password
+	Verify(password, hash string) (ok bool, needsRehash bool, err error)
+}
+
+// Argon2idParams - the cost of Argon2id hashes, see RFC 9106 for choosing them
+type Argon2idParams struct {
+	// memory used in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams - the second recommended option of RFC 9106 with a little more memory, around 50ms
+// per hash on our nodes
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idHasher - hashes passwords with Argon2id
+type Argon2idHasher struct {
+	params Argon2idParams
+}
+
+func NewArgon2idHasher(params Argon2idParams) *Argon2idHasher {
+	return &Argon2idHasher{
+		params: params,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.params.Memory,
+		h.params.Iterations,
+		h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, hash string) (bool, bool, error) {
+	ok, err := verify(password, hash)
+	if err != nil || !ok {
+		return ok, false, err
+	}
+
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		// a bcrypt hash
+		return true, true, nil
+	}
+
+	return true, params != h.params, nil
+}
+
+// BcryptHasher - hashes passwords with bcrypt, for deployments that cannot spare the memory Argon2id needs
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{
+		cost: cost,
+	}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, hash string) (bool, bool, error) {
+	ok, err := verify(password, hash)
+	if err != nil || !ok {
+		return ok, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		// an Argon2id hash
+		return true, true, nil
+	}
+
+	return true, cost < h.cost, nil
+}
+
+// isBcrypt - bcrypt hashes predate PHC strings but use the same $id$ prefix
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// verify - check a password against a hash of any supported format
+func verify(password, hash string) (bool, error) {
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+// decodeArgon2id - the parameters, salt and key of an Argon2id PHC string
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q: %w", parts[3], err)
+	}
+
+	// argon2 panics rather than hashing with no passes or threads
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+
+	if len(key) == 0 {
+		return params, nil, nil, errors.New("argon2id hash has no key")
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
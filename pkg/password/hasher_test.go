@@ -0,0 +1,133 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheapParams - fast enough that tests can hash freely
+var cheapParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func Test_ShouldHashWithArgon2id(t *testing.T) {
+	hasher := NewArgon2idHasher(cheapParams)
+
+	hash, err := hasher.Hash("password")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Hash is not an Argon2id PHC string with the given parameters: %v", hash)
+	}
+
+	if other, _ := hasher.Hash("password"); other == hash {
+		t.Errorf("Two hashes of the same password share a salt")
+	}
+
+	ok, needsRehash, err := hasher.Verify("password", hash)
+	if !ok || needsRehash || err != nil {
+		t.Errorf("Failed to verify own hash: ok %v, rehash %v, err %v", ok, needsRehash, err)
+	}
+
+	if ok, needsRehash, err := hasher.Verify("wrong", hash); ok || needsRehash || err != nil {
+		t.Errorf("Wrong password was accepted: ok %v, rehash %v, err %v", ok, needsRehash, err)
+	}
+}
+
+func Test_ShouldRehashArgon2idWithOtherParameters(t *testing.T) {
+	hash, _ := NewArgon2idHasher(cheapParams).Hash("password")
+
+	for name, change := range map[string]func(*Argon2idParams){
+		"memory":      func(p *Argon2idParams) { p.Memory = 2048 },
+		"iterations":  func(p *Argon2idParams) { p.Iterations = 2 },
+		"parallelism": func(p *Argon2idParams) { p.Parallelism = 2 },
+		"salt length": func(p *Argon2idParams) { p.SaltLength = 32 },
+		"key length":  func(p *Argon2idParams) { p.KeyLength = 64 },
+	} {
+		params := cheapParams
+		change(&params)
+
+		ok, needsRehash, err := NewArgon2idHasher(params).Verify("password", hash)
+		if !ok || err != nil {
+			t.Errorf("Hash with another %v was not verified: %v", name, err)
+		}
+		if !needsRehash {
+			t.Errorf("Hash with another %v was not rehashed", name)
+		}
+	}
+
+	// only a correct password is worth rehashing
+	if _, needsRehash, _ := NewArgon2idHasher(DefaultArgon2idParams).Verify("wrong", hash); needsRehash {
+		t.Errorf("Rehash was asked for a wrong password")
+	}
+}
+
+func Test_ShouldVerifyAcrossHashers(t *testing.T) {
+	argon, _ := NewArgon2idHasher(cheapParams).Hash("password")
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	ok, needsRehash, err := NewBcryptHasher(bcrypt.MinCost).Verify("password", argon)
+	if !ok || !needsRehash || err != nil {
+		t.Errorf("bcrypt hasher did not upgrade an Argon2id hash: ok %v, rehash %v, err %v", ok, needsRehash, err)
+	}
+
+	ok, needsRehash, err = NewArgon2idHasher(cheapParams).Verify("password", string(legacy))
+	if !ok || !needsRehash || err != nil {
+		t.Errorf("Argon2id hasher did not upgrade a bcrypt hash: ok %v, rehash %v, err %v", ok, needsRehash, err)
+	}
+
+	if ok, _, err := NewArgon2idHasher(cheapParams).Verify("wrong", string(legacy)); ok || err != nil {
+		t.Errorf("Wrong password was accepted against a bcrypt hash: %v", err)
+	}
+}
+
+func Test_ShouldRehashBcryptAtHigherCost(t *testing.T) {
+	hash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("password")
+
+	if _, needsRehash, _ := NewBcryptHasher(bcrypt.MinCost).Verify("password", hash); needsRehash {
+		t.Errorf("Hash at the current cost was rehashed")
+	}
+	if _, needsRehash, _ := NewBcryptHasher(bcrypt.MinCost+1).Verify("password", hash); !needsRehash {
+		t.Errorf("Hash at a lower cost was not rehashed")
+	}
+	if _, needsRehash, _ := NewBcryptHasher(bcrypt.MinCost-1).Verify("password", hash); needsRehash {
+		t.Errorf("Hash at a higher cost was rehashed")
+	}
+}
+
+func Test_ShouldRejectMalformedHashes(t *testing.T) {
+	for _, hasher := range []Hasher{NewArgon2idHasher(cheapParams), NewBcryptHasher(bcrypt.MinCost)} {
+		for _, hash := range []string{
+			"",
+			"plaintext",
+			"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+			"$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=0,p=1$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=1,p=0$c2FsdA$a2V5",
+			"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$",
+			"$argon2id$v=19$m=1024,t=1,p=1$not base64!$a2V5",
+			"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$not base64!",
+			"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+			"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$a2V5$extra",
+			"$2a$04$short",
+		} {
+			ok, needsRehash, err := hasher.Verify("password", hash)
+			if ok || needsRehash || err == nil {
+				t.Errorf("Malformed hash %q was not rejected: ok %v, rehash %v, err %v", hash, ok, needsRehash, err)
+			}
+		}
+	}
+
+	if _, _, err := NewArgon2idHasher(cheapParams).Verify("password", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("Expected ErrUnknownHash for another algorithm, got %v", err)
+	}
+}
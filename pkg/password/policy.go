@@ -0,0 +1,116 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules a password can fail, reported in Violation.Rule
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleStrength         = "strength"
+	RuleContainsUsername = "contains_username"
+	RuleContainsEmail    = "contains_email"
+	RuleBreached         = "breached"
+)
+
+// usernames and email addresses shorter than this are too likely to turn up in passwords by chance to reject them
+const minUserInputLength = 3
+
+// Violation - a rule of the policy the password does not follow
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+// Policy - the rules new passwords must follow, the zero Policy accepts any password
+type Policy struct {
+	// in characters, not bytes
+	MinLength int
+	// 0 for no limit, a limit keeps hashing and strength estimation cheap
+	MaxLength int
+	// the lowest Strength score accepted
+	MinScore int
+	// reject passwords that contain the username or email address of the user
+	RejectUserInputs bool
+	// reject passwords that appeared in a breach, nil to skip the check
+	Breaches *BreachCorpus
+}
+
+// DefaultPolicy - the policy of new passwords unless configured otherwise, without a breach corpus since one has to
+// be downloaded separately
+var DefaultPolicy = Policy{
+	MinLength:        8,
+	MaxLength:        128,
+	MinScore:         ScoreSomewhatSecure,
+	RejectUserInputs: true,
+}
+
+// Check - every rule the password of the user with the given username and email breaks, none if it is accepted
+func (p Policy) Check(password, username, email string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMinLength,
+			Description: fmt.Sprintf("Password must be at least %v characters long", p.MinLength),
+		})
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMaxLength,
+			Description: fmt.Sprintf("Password must be at most %v characters long", p.MaxLength),
+		})
+		// too long to spend time estimating
+		return violations
+	}
+
+	if p.RejectUserInputs {
+		lower := strings.ToLower(password)
+
+		if containsInput(lower, username) {
+			violations = append(violations, Violation{
+				Rule:        RuleContainsUsername,
+				Description: "Password must not contain the username",
+			})
+		}
+
+		local := email
+		if at := strings.LastIndexByte(email, '@'); at >= 0 {
+			local = email[:at]
+		}
+
+		if containsInput(lower, email) || containsInput(lower, local) {
+			violations = append(violations, Violation{
+				Rule:        RuleContainsEmail,
+				Description: "Password must not contain the email address",
+			})
+		}
+	}
+
+	if p.MinScore > ScoreTooGuessable && Strength(password, username, email) < p.MinScore {
+		violations = append(violations, Violation{
+			Rule:        RuleStrength,
+			Description: "Password is too easy to guess, try a longer password or a few unrelated words",
+		})
+	}
+
+	if p.Breaches != nil && p.Breaches.Contains(password) {
+		violations = append(violations, Violation{
+			Rule:        RuleBreached,
+			Description: "Password appeared in a data breach and must not be used",
+		})
+	}
+
+	return violations
+}
+
+func containsInput(lowerPassword, input string) bool {
+	input = strings.ToLower(strings.TrimSpace(input))
+	return utf8.RuneCountInString(input) >= minUserInputLength && strings.Contains(lowerPassword, input)
+}
@@ -0,0 +1,64 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+// rules - the rules the violations are of, in order
+func rules(violations []Violation) []string {
+	broken := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		broken = append(broken, violation.Rule)
+	}
+	return broken
+}
+
+func Test_ShouldCheckEveryPolicyRule(t *testing.T) {
+	policy := DefaultPolicy
+	policy.Breaches = NewBreachCorpus("correct horse battery staple")
+
+	for _, tc := range []struct {
+		password string
+		want     []string
+	}{
+		{"violet kettle orbit 42", nil},
+		{"x7#Q", []string{RuleMinLength, RuleStrength}},
+		{strings.Repeat("violet kettle ", 10), []string{RuleMaxLength}},
+		{"weakling", []string{RuleContainsUsername, RuleContainsEmail, RuleStrength}},
+		{"violet WEAKLING kettle", []string{RuleContainsUsername, RuleContainsEmail}},
+		{"correct horse battery staple", []string{RuleBreached}},
+	} {
+		got := rules(policy.Check(tc.password, "weakling", "weakling@gmail.com"))
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Password %q broke %v, expected %v", tc.password, got, tc.want)
+		}
+	}
+}
+
+func Test_ShouldIgnoreShortUserInputs(t *testing.T) {
+	policy := Policy{RejectUserInputs: true}
+
+	if violations := policy.Check("violet jo kettle", "jo", "jo@gmail.com"); len(violations) != 0 {
+		t.Errorf("Password was rejected for containing a two letter username: %v", rules(violations))
+	}
+	if violations := policy.Check("violet mail kettle", "violetuser", "mail@gmail.com"); len(violations) != 1 || violations[0].Rule != RuleContainsEmail {
+		t.Errorf("Password containing the local part of the email was not rejected: %v", rules(violations))
+	}
+}
+
+func Test_ShouldAcceptAnyPasswordWithZeroPolicy(t *testing.T) {
+	for _, pass := range []string{"", "password", strings.Repeat("x", 1000)} {
+		if violations := (Policy{}).Check(pass, "password", "password@gmail.com"); len(violations) != 0 {
+			t.Errorf("Zero policy rejected %q: %v", pass, rules(violations))
+		}
+	}
+}
+
+func Test_ShouldDescribeEveryViolation(t *testing.T) {
+	for _, violation := range DefaultPolicy.Check("", "", "") {
+		if violation.Description == "" {
+			t.Errorf("Violation of %v has no description", violation.Rule)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Strength scores, following zxcvbn: the estimated number of guesses an attacker needs is below 10^3, 10^6, 10^8
+// and 10^10 for scores 0 to 3, and above that for a score of 4
+const (
+	ScoreTooGuessable   = 0
+	ScoreVeryGuessable  = 1
+	ScoreSomewhatSecure = 2
+	ScoreSafelySecure   = 3
+	ScoreVerySecure     = 4
+)
+
+var scoreThresholds = []float64{1e3, 1e6, 1e8, 1e10}
+
+const (
+	// guesses per character that is not part of a recognised pattern
+	bruteforceCardinality = 10
+	// recent years are guessed first, but at least this many are tried
+	minYearSpace = 20
+
+	maxEstimatedLength = 64
+)
+
+// Strength - a zxcvbn style score of how hard the password is to guess, from ScoreTooGuessable to ScoreVerySecure.
+// The password is split into the sequence of dictionary words, repeats, sequences, keyboard runs and years that is
+// cheapest to guess, anything else is brute forced. userInputs like the username are guessed before any word.
+func Strength(password string, userInputs ...string) int {
+	guesses := estimateGuesses(password, userInputs)
+
+	for score, threshold := range scoreThresholds {
+		if guesses < threshold {
+			return score
+		}
+	}
+
+	return ScoreVerySecure
+}
+
+// estimateGuesses - the fewest guesses of any way to split the password into patterns
+func estimateGuesses(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return 1
+	}
+
+	// longer passwords are at least as strong as their start, and the search below is quadratic
+	if n > maxEstimatedLength {
+		runes = runes[:maxEstimatedLength]
+		n = maxEstimatedLength
+	}
+
+	dict := newDictionary(userInputs)
+
+	// best[j] - the fewest guesses of runes[:j] in log10, with tokens[j] the number of patterns used for it
+	best := make([]float64, n+1)
+	tokens := make([]int, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = math.Inf(1)
+	}
+
+	for j := 1; j <= n; j++ {
+		for i := 0; i < j; i++ {
+			guesses := matchGuesses(runes[i:j], dict)
+			// splitting into more patterns costs a little, so one long match beats several short ones
+			total := best[i] + math.Log10(guesses) + math.Log10(float64(tokens[i]+1))
+			if total < best[j] {
+				best[j] = total
+				tokens[j] = tokens[i] + 1
+			}
+		}
+	}
+
+	return math.Pow(10, best[n])
+}
+
+// matchGuesses - the fewest guesses of the token as a single pattern
+func matchGuesses(token []rune, dict dictionary) float64 {
+	guesses := math.Pow(bruteforceCardinality, float64(len(token)))
+
+	if len(token) == 1 {
+		return guesses
+	}
+
+	for _, estimate := range []func([]rune, dictionary) float64{
+		dictionaryGuesses,
+		repeatGuesses,
+		sequenceGuesses,
+		keyboardGuesses,
+		yearGuesses,
+	} {
+		if g := estimate(token, dict); g > 0 && g < guesses {
+			guesses = g
+		}
+	}
+
+	return guesses
+}
+
+// dictionaryGuesses - the rank of the word, times the ways it could be capitalised or written in l33t speak
+func dictionaryGuesses(token []rune, dict dictionary) float64 {
+	if len(token) < 3 {
+		return 0
+	}
+
+	word := strings.ToLower(string(token))
+
+	best := 0.0
+	for _, candidate := range unleet(word) {
+		rank, ok := dict.rank(candidate)
+		if !ok {
+			continue
+		}
+
+		guesses := float64(rank) * uppercaseVariations(token)
+		if candidate != word {
+			guesses *= 2
+		}
+		if best == 0 || guesses < best {
+			best = guesses
+		}
+	}
+
+	// reversed words are tried too
+	if rank, ok := dict.rank(reverse(word)); ok && len(token) > 3 {
+		guesses := float64(rank) * uppercaseVariations(token) * 2
+		if best == 0 || guesses < best {
+			best = guesses
+		}
+	}
+
+	return best
+}
+
+// uppercaseVariations - how many ways of capitalising a word of this length attackers try before this one
+func uppercaseVariations(token []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+
+	if upper == 0 {
+		return 1
+	}
+
+	// Password, PASSWORD and passworD are the first variations tried
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(token[0]) || unicode.IsUpper(token[len(token)-1]))) {
+		return 2
+	}
+
+	variations := 0.0
+	for i := 1; i <= upper && i <= lower; i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return variations
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// leetSubstitutions - characters commonly standing in for letters, 1 is tried as both i and l
+var leetSubstitutions = map[rune][]rune{
+	'4': {'a'},
+	'@': {'a'},
+	'8': {'b'},
+	'(': {'c'},
+	'3': {'e'},
+	'6': {'g'},
+	'1': {'i', 'l'},
+	'!': {'i'},
+	'|': {'l'},
+	'0': {'o'},
+	'$': {'s'},
+	'5': {'s'},
+	'7': {'t'},
+	'+': {'t'},
+	'2': {'z'},
+}
+
+// unleet - the word itself and the words it could be l33t speak for
+func unleet(word string) []string {
+	words := []string{""}
+
+	for _, r := range word {
+		subs, ok := leetSubstitutions[r]
+		if !ok {
+			subs = []rune{r}
+		} else {
+			subs = append([]rune{r}, subs...)
+		}
+
+		next := make([]string, 0, len(words)*len(subs))
+		for _, prefix := range words {
+			for _, sub := range subs {
+				next = append(next, prefix+string(sub))
+			}
+		}
+
+		// a password that is nothing but digits would otherwise blow up into every combination
+		if len(next) > 64 {
+			next = next[:64]
+		}
+		words = next
+	}
+
+	return words
+}
+
+// repeatGuesses - a single character repeated, like aaaa or 1111
+func repeatGuesses(token []rune, _ dictionary) float64 {
+	if len(token) < 3 {
+		return 0
+	}
+
+	for _, r := range token[1:] {
+		if r != token[0] {
+			return 0
+		}
+	}
+
+	return charsetSize(token[0]) * float64(len(token))
+}
+
+// sequenceGuesses - characters counting up or down by the same step, like abcd, 2468 or 9876
+func sequenceGuesses(token []rune, _ dictionary) float64 {
+	if len(token) < 3 {
+		return 0
+	}
+
+	delta := token[1] - token[0]
+	if delta == 0 || delta > 5 || delta < -5 {
+		return 0
+	}
+
+	for i := 2; i < len(token); i++ {
+		if token[i]-token[i-1] != delta {
+			return 0
+		}
+	}
+
+	// sequences starting at an obvious point are tried first
+	start := 0.0
+	switch unicode.ToLower(token[0]) {
+	case 'a', 'z', '0', '1', '9':
+		start = 4
+	default:
+		start = charsetSize(token[0])
+	}
+
+	guesses := start * float64(len(token))
+	if delta < 0 {
+		guesses *= 2
+	}
+	if delta != 1 && delta != -1 {
+		guesses *= 5
+	}
+	return guesses
+}
+
+// keyboardRows - runs along these are tried like sequences
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"qazwsxedcrfvtgbyhnujmik,ol.p;/",
+	"1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik,9ol.0p;/",
+}
+
+// keyboardGuesses - a run of neighbouring keys, like qwerty or zxcvb
+func keyboardGuesses(token []rune, _ dictionary) float64 {
+	if len(token) < 4 {
+		return 0
+	}
+
+	word := strings.ToLower(string(token))
+
+	for _, row := range keyboardRows {
+		reversed := strings.Contains(reverse(row), word)
+		if strings.Contains(row, word) || reversed {
+			guesses := float64(len(row)) * float64(len(token)) * uppercaseVariations(token)
+			if reversed {
+				guesses *= 2
+			}
+			return guesses
+		}
+	}
+
+	return 0
+}
+
+// yearGuesses - a year from 1900 to 2099, the closer to the current year the sooner it is guessed
+func yearGuesses(token []rune, _ dictionary) float64 {
+	if len(token) != 4 || (string(token[:2]) != "19" && string(token[:2]) != "20") {
+		return 0
+	}
+
+	year := 0
+	for _, r := range token {
+		if r < '0' || r > '9' {
+			return 0
+		}
+		year = year*10 + int(r-'0')
+	}
+
+	return math.Max(math.Abs(float64(year-time.Now().Year())), minYearSpace)
+}
+
+// charsetSize - how many characters of the same kind there are to guess from
+func charsetSize(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return 10
+	case unicode.IsLower(r), unicode.IsUpper(r):
+		return 26
+	default:
+		return 33
+	}
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
@@ -0,0 +1,46 @@
+package password
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_ShouldScorePasswordStrength(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		max      int
+		min      int
+	}{
+		{"", ScoreTooGuessable, ScoreTooGuessable},
+		{"password", ScoreTooGuessable, ScoreTooGuessable},
+		{"P@ssw0rd", ScoreTooGuessable, ScoreTooGuessable},
+		{"qwertyuiop", ScoreTooGuessable, ScoreTooGuessable},
+		{"abcdefgh", ScoreTooGuessable, ScoreTooGuessable},
+		{"11111111", ScoreTooGuessable, ScoreTooGuessable},
+		{"sunshine2020", ScoreVeryGuessable, ScoreTooGuessable},
+		{"sunshine" + strconv.Itoa(time.Now().Year()), ScoreVeryGuessable, ScoreTooGuessable},
+		{"qdn123secret", ScoreTooGuessable, ScoreTooGuessable},
+		{"violet kettle orbit 42", ScoreVerySecure, ScoreSafelySecure},
+		{"7Hq!vz4Lp0r@", ScoreVerySecure, ScoreVerySecure},
+	} {
+		if score := Strength(tc.password, "qdn123", "qdn@gmail.com"); score < tc.min || score > tc.max {
+			t.Errorf("Password %q scored %v, expected %v to %v", tc.password, score, tc.min, tc.max)
+		}
+	}
+}
+
+func Test_ShouldGuessRecentYearsFirst(t *testing.T) {
+	year := time.Now().Year()
+
+	recent := yearGuesses([]rune(strconv.Itoa(year)), nil)
+	if recent != minYearSpace {
+		t.Errorf("The current year takes %v guesses, expected %v", recent, minYearSpace)
+	}
+	if old := yearGuesses([]rune("1901"), nil); old <= recent {
+		t.Errorf("1901 takes %v guesses, no more than the current year", old)
+	}
+	if guesses := yearGuesses([]rune("2l00"), nil); guesses != 0 {
+		t.Errorf("Matched %q as a year", "2l00")
+	}
+}
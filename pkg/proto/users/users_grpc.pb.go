@@ -30,8 +30,69 @@ type UsersClient interface {
 	GetUserNameByID(ctx context.Context, in *GetUserNameByIDRequest, opts ...grpc.CallOption) (*GetUserNameByIDResponse, error)
 	// Delete the user with the given ID, this will need to cascade to other services.
 	DeleteUserByID(ctx context.Context, in *DeleteUserByIDRequest, opts ...grpc.CallOption) (*DeleteUserByIDResponse, error)
-	// Update a user's information to that sent by the client.
+	// Update a user's information to that sent by the client. Changing the email needs the current password or a
+	// login within the last few minutes, and logs the user out of every other session.
 	UpdateUserInfo(ctx context.Context, in *UpdateUserInfoRequest, opts ...grpc.CallOption) (*UpdateUserInfoResponse, error)
+	// Exchange a refresh token for a new JWT. The refresh token is single use, a replacement is returned alongside
+	// the new JWT and presenting an already used refresh token revokes every token descended from the same login.
+	RefreshJWTToken(ctx context.Context, in *RefreshJWTTokenRequest, opts ...grpc.CallOption) (*RefreshJWTTokenResponse, error)
+	// Revoke the JWT sent with the request, along with the refresh token of the same login if one is given.
+	Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*LogoutResponse, error)
+	// Revoke every JWT and refresh token issued to the calling user so far.
+	LogoutEverywhere(ctx context.Context, in *LogoutEverywhereRequest, opts ...grpc.CallOption) (*LogoutEverywhereResponse, error)
+	// Get the public keys JWTs are signed with as a JSON Web Key Set, so other services can verify tokens
+	// without calling the users service.
+	GetJWKS(ctx context.Context, in *GetJWKSRequest, opts ...grpc.CallOption) (*GetJWKSResponse, error)
+	// Replace the roles of a user, only admins may call this.
+	UpdateUserRoles(ctx context.Context, in *UpdateUserRolesRequest, opts ...grpc.CallOption) (*UpdateUserRolesResponse, error)
+	// Lift the lockout of a user after too many failed logins, only admins may call this.
+	UnlockAccount(ctx context.Context, in *UnlockAccountRequest, opts ...grpc.CallOption) (*UnlockAccountResponse, error)
+	// Start setting up TOTP two-factor authentication for the calling user, returning the secret to add to an
+	// authenticator app. Logins do not ask for a code until the setup is confirmed with VerifyTOTP.
+	EnrollTOTP(ctx context.Context, in *EnrollTOTPRequest, opts ...grpc.CallOption) (*EnrollTOTPResponse, error)
+	// Confirm TOTP setup with a code from the authenticator app, turning on two-factor authentication and
+	// returning single use recovery codes.
+	VerifyTOTP(ctx context.Context, in *VerifyTOTPRequest, opts ...grpc.CallOption) (*VerifyTOTPResponse, error)
+	// Turn off two-factor authentication for the calling user, which needs a current code or a recovery code.
+	DisableTOTP(ctx context.Context, in *DisableTOTPRequest, opts ...grpc.CallOption) (*DisableTOTPResponse, error)
+	// Exchange the MFA challenge token GetJWTToken returns for users with two-factor authentication and a TOTP
+	// or recovery code for a JWT.
+	CompleteMFAChallenge(ctx context.Context, in *CompleteMFAChallengeRequest, opts ...grpc.CallOption) (*CompleteMFAChallengeResponse, error)
+	// Email a password reset link to the user with the given email address. The response is the same whether or
+	// not an account uses the address.
+	RequestPasswordReset(ctx context.Context, in *RequestPasswordResetRequest, opts ...grpc.CallOption) (*RequestPasswordResetResponse, error)
+	// Set a new password with the token from a password reset email, logging the user out everywhere.
+	CompletePasswordReset(ctx context.Context, in *CompletePasswordResetRequest, opts ...grpc.CallOption) (*CompletePasswordResetResponse, error)
+	// Confirm an email address with the token from a verification email. Confirming a pending email change
+	// replaces the user's email with the new address.
+	VerifyEmail(ctx context.Context, in *VerifyEmailRequest, opts ...grpc.CallOption) (*VerifyEmailResponse, error)
+	// Send a new verification email to an unverified address. The response is the same whether or not an
+	// account uses the address.
+	ResendVerificationEmail(ctx context.Context, in *ResendVerificationEmailRequest, opts ...grpc.CallOption) (*ResendVerificationEmailResponse, error)
+	// Send a short lived one time login code to the user with the given email address, for logging in without a
+	// password. The response is the same whether or not an account uses the address.
+	RequestLoginCode(ctx context.Context, in *RequestLoginCodeRequest, opts ...grpc.CallOption) (*RequestLoginCodeResponse, error)
+	// Log in with a code from RequestLoginCode, returning the same tokens as GetJWTToken.
+	LoginWithCode(ctx context.Context, in *LoginWithCodeRequest, opts ...grpc.CallOption) (*LoginWithCodeResponse, error)
+	// Change the password of the calling user, which needs the current password or a login within the last few
+	// minutes. Every other session of the user is logged out, the calling session gets a new JWT.
+	ChangePassword(ctx context.Context, in *ChangePasswordRequest, opts ...grpc.CallOption) (*ChangePasswordResponse, error)
+	// List the sessions the calling user is logged in with, most recently used first.
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	// Log out one session of the calling user, its JWTs and refresh tokens stop working right away.
+	RevokeSession(ctx context.Context, in *RevokeSessionRequest, opts ...grpc.CallOption) (*RevokeSessionResponse, error)
+	// Create a service account for a machine caller, scoped to the given RPCs. Admins only. The API key is only
+	// ever returned here and by RotateServiceAccountKey.
+	CreateServiceAccount(ctx context.Context, in *CreateServiceAccountRequest, opts ...grpc.CallOption) (*CreateServiceAccountResponse, error)
+	// List every service account, revoked ones included. Admins only.
+	ListServiceAccounts(ctx context.Context, in *ListServiceAccountsRequest, opts ...grpc.CallOption) (*ListServiceAccountsResponse, error)
+	// Replace the API key of a service account, the old key keeps working for the given grace period. Admins only.
+	RotateServiceAccountKey(ctx context.Context, in *RotateServiceAccountKeyRequest, opts ...grpc.CallOption) (*RotateServiceAccountKeyResponse, error)
+	// Revoke a service account, its API keys and the JWTs exchanged for them stop working right away. Admins only.
+	RevokeServiceAccount(ctx context.Context, in *RevokeServiceAccountRequest, opts ...grpc.CallOption) (*RevokeServiceAccountResponse, error)
+	// Exchange the API key of a service account for a short lived JWT, like an OAuth client credentials grant,
+	// for callers that pass tokens on to services verifying them with GetJWKS.
+	GetServiceAccountToken(ctx context.Context, in *GetServiceAccountTokenRequest, opts ...grpc.CallOption) (*GetServiceAccountTokenResponse, error)
 }
 
 type usersClient struct {
@@ -105,6 +166,222 @@ func (c *usersClient) UpdateUserInfo(ctx context.Context, in *UpdateUserInfoRequ
 	return out, nil
 }
 
+func (c *usersClient) RefreshJWTToken(ctx context.Context, in *RefreshJWTTokenRequest, opts ...grpc.CallOption) (*RefreshJWTTokenResponse, error) {
+	out := new(RefreshJWTTokenResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/RefreshJWTToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) Logout(ctx context.Context, in *LogoutRequest, opts ...grpc.CallOption) (*LogoutResponse, error) {
+	out := new(LogoutResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/Logout", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) LogoutEverywhere(ctx context.Context, in *LogoutEverywhereRequest, opts ...grpc.CallOption) (*LogoutEverywhereResponse, error) {
+	out := new(LogoutEverywhereResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/LogoutEverywhere", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) GetJWKS(ctx context.Context, in *GetJWKSRequest, opts ...grpc.CallOption) (*GetJWKSResponse, error) {
+	out := new(GetJWKSResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/GetJWKS", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) UpdateUserRoles(ctx context.Context, in *UpdateUserRolesRequest, opts ...grpc.CallOption) (*UpdateUserRolesResponse, error) {
+	out := new(UpdateUserRolesResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/UpdateUserRoles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) UnlockAccount(ctx context.Context, in *UnlockAccountRequest, opts ...grpc.CallOption) (*UnlockAccountResponse, error) {
+	out := new(UnlockAccountResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/UnlockAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) EnrollTOTP(ctx context.Context, in *EnrollTOTPRequest, opts ...grpc.CallOption) (*EnrollTOTPResponse, error) {
+	out := new(EnrollTOTPResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/EnrollTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) VerifyTOTP(ctx context.Context, in *VerifyTOTPRequest, opts ...grpc.CallOption) (*VerifyTOTPResponse, error) {
+	out := new(VerifyTOTPResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/VerifyTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) DisableTOTP(ctx context.Context, in *DisableTOTPRequest, opts ...grpc.CallOption) (*DisableTOTPResponse, error) {
+	out := new(DisableTOTPResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/DisableTOTP", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) CompleteMFAChallenge(ctx context.Context, in *CompleteMFAChallengeRequest, opts ...grpc.CallOption) (*CompleteMFAChallengeResponse, error) {
+	out := new(CompleteMFAChallengeResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/CompleteMFAChallenge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) RequestPasswordReset(ctx context.Context, in *RequestPasswordResetRequest, opts ...grpc.CallOption) (*RequestPasswordResetResponse, error) {
+	out := new(RequestPasswordResetResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/RequestPasswordReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) CompletePasswordReset(ctx context.Context, in *CompletePasswordResetRequest, opts ...grpc.CallOption) (*CompletePasswordResetResponse, error) {
+	out := new(CompletePasswordResetResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/CompletePasswordReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) VerifyEmail(ctx context.Context, in *VerifyEmailRequest, opts ...grpc.CallOption) (*VerifyEmailResponse, error) {
+	out := new(VerifyEmailResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/VerifyEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ResendVerificationEmail(ctx context.Context, in *ResendVerificationEmailRequest, opts ...grpc.CallOption) (*ResendVerificationEmailResponse, error) {
+	out := new(ResendVerificationEmailResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/ResendVerificationEmail", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) RequestLoginCode(ctx context.Context, in *RequestLoginCodeRequest, opts ...grpc.CallOption) (*RequestLoginCodeResponse, error) {
+	out := new(RequestLoginCodeResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/RequestLoginCode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) LoginWithCode(ctx context.Context, in *LoginWithCodeRequest, opts ...grpc.CallOption) (*LoginWithCodeResponse, error) {
+	out := new(LoginWithCodeResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/LoginWithCode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ChangePassword(ctx context.Context, in *ChangePasswordRequest, opts ...grpc.CallOption) (*ChangePasswordResponse, error) {
+	out := new(ChangePasswordResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/ChangePassword", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/ListSessions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) RevokeSession(ctx context.Context, in *RevokeSessionRequest, opts ...grpc.CallOption) (*RevokeSessionResponse, error) {
+	out := new(RevokeSessionResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/RevokeSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) CreateServiceAccount(ctx context.Context, in *CreateServiceAccountRequest, opts ...grpc.CallOption) (*CreateServiceAccountResponse, error) {
+	out := new(CreateServiceAccountResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/CreateServiceAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) ListServiceAccounts(ctx context.Context, in *ListServiceAccountsRequest, opts ...grpc.CallOption) (*ListServiceAccountsResponse, error) {
+	out := new(ListServiceAccountsResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/ListServiceAccounts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) RotateServiceAccountKey(ctx context.Context, in *RotateServiceAccountKeyRequest, opts ...grpc.CallOption) (*RotateServiceAccountKeyResponse, error) {
+	out := new(RotateServiceAccountKeyResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/RotateServiceAccountKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) RevokeServiceAccount(ctx context.Context, in *RevokeServiceAccountRequest, opts ...grpc.CallOption) (*RevokeServiceAccountResponse, error) {
+	out := new(RevokeServiceAccountResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/RevokeServiceAccount", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *usersClient) GetServiceAccountToken(ctx context.Context, in *GetServiceAccountTokenRequest, opts ...grpc.CallOption) (*GetServiceAccountTokenResponse, error) {
+	out := new(GetServiceAccountTokenResponse)
+	err := c.cc.Invoke(ctx, "/kic.users.Users/GetServiceAccountToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UsersServer is the server API for Users service.
 // All implementations must embed UnimplementedUsersServer
 // for forward compatibility
@@ -122,8 +399,69 @@ type UsersServer interface {
 	GetUserNameByID(context.Context, *GetUserNameByIDRequest) (*GetUserNameByIDResponse, error)
 	// Delete the user with the given ID, this will need to cascade to other services.
 	DeleteUserByID(context.Context, *DeleteUserByIDRequest) (*DeleteUserByIDResponse, error)
-	// Update a user's information to that sent by the client.
+	// Update a user's information to that sent by the client. Changing the email needs the current password or a
+	// login within the last few minutes, and logs the user out of every other session.
 	UpdateUserInfo(context.Context, *UpdateUserInfoRequest) (*UpdateUserInfoResponse, error)
+	// Exchange a refresh token for a new JWT. The refresh token is single use, a replacement is returned alongside
+	// the new JWT and presenting an already used refresh token revokes every token descended from the same login.
+	RefreshJWTToken(context.Context, *RefreshJWTTokenRequest) (*RefreshJWTTokenResponse, error)
+	// Revoke the JWT sent with the request, along with the refresh token of the same login if one is given.
+	Logout(context.Context, *LogoutRequest) (*LogoutResponse, error)
+	// Revoke every JWT and refresh token issued to the calling user so far.
+	LogoutEverywhere(context.Context, *LogoutEverywhereRequest) (*LogoutEverywhereResponse, error)
+	// Get the public keys JWTs are signed with as a JSON Web Key Set, so other services can verify tokens
+	// without calling the users service.
+	GetJWKS(context.Context, *GetJWKSRequest) (*GetJWKSResponse, error)
+	// Replace the roles of a user, only admins may call this.
+	UpdateUserRoles(context.Context, *UpdateUserRolesRequest) (*UpdateUserRolesResponse, error)
+	// Lift the lockout of a user after too many failed logins, only admins may call this.
+	UnlockAccount(context.Context, *UnlockAccountRequest) (*UnlockAccountResponse, error)
+	// Start setting up TOTP two-factor authentication for the calling user, returning the secret to add to an
+	// authenticator app. Logins do not ask for a code until the setup is confirmed with VerifyTOTP.
+	EnrollTOTP(context.Context, *EnrollTOTPRequest) (*EnrollTOTPResponse, error)
+	// Confirm TOTP setup with a code from the authenticator app, turning on two-factor authentication and
+	// returning single use recovery codes.
+	VerifyTOTP(context.Context, *VerifyTOTPRequest) (*VerifyTOTPResponse, error)
+	// Turn off two-factor authentication for the calling user, which needs a current code or a recovery code.
+	DisableTOTP(context.Context, *DisableTOTPRequest) (*DisableTOTPResponse, error)
+	// Exchange the MFA challenge token GetJWTToken returns for users with two-factor authentication and a TOTP
+	// or recovery code for a JWT.
+	CompleteMFAChallenge(context.Context, *CompleteMFAChallengeRequest) (*CompleteMFAChallengeResponse, error)
+	// Email a password reset link to the user with the given email address. The response is the same whether or
+	// not an account uses the address.
+	RequestPasswordReset(context.Context, *RequestPasswordResetRequest) (*RequestPasswordResetResponse, error)
+	// Set a new password with the token from a password reset email, logging the user out everywhere.
+	CompletePasswordReset(context.Context, *CompletePasswordResetRequest) (*CompletePasswordResetResponse, error)
+	// Confirm an email address with the token from a verification email. Confirming a pending email change
+	// replaces the user's email with the new address.
+	VerifyEmail(context.Context, *VerifyEmailRequest) (*VerifyEmailResponse, error)
+	// Send a new verification email to an unverified address. The response is the same whether or not an
+	// account uses the address.
+	ResendVerificationEmail(context.Context, *ResendVerificationEmailRequest) (*ResendVerificationEmailResponse, error)
+	// Send a short lived one time login code to the user with the given email address, for logging in without a
+	// password. The response is the same whether or not an account uses the address.
+	RequestLoginCode(context.Context, *RequestLoginCodeRequest) (*RequestLoginCodeResponse, error)
+	// Log in with a code from RequestLoginCode, returning the same tokens as GetJWTToken.
+	LoginWithCode(context.Context, *LoginWithCodeRequest) (*LoginWithCodeResponse, error)
+	// Change the password of the calling user, which needs the current password or a login within the last few
+	// minutes. Every other session of the user is logged out, the calling session gets a new JWT.
+	ChangePassword(context.Context, *ChangePasswordRequest) (*ChangePasswordResponse, error)
+	// List the sessions the calling user is logged in with, most recently used first.
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	// Log out one session of the calling user, its JWTs and refresh tokens stop working right away.
+	RevokeSession(context.Context, *RevokeSessionRequest) (*RevokeSessionResponse, error)
+	// Create a service account for a machine caller, scoped to the given RPCs. Admins only. The API key is only
+	// ever returned here and by RotateServiceAccountKey.
+	CreateServiceAccount(context.Context, *CreateServiceAccountRequest) (*CreateServiceAccountResponse, error)
+	// List every service account, revoked ones included. Admins only.
+	ListServiceAccounts(context.Context, *ListServiceAccountsRequest) (*ListServiceAccountsResponse, error)
+	// Replace the API key of a service account, the old key keeps working for the given grace period. Admins only.
+	RotateServiceAccountKey(context.Context, *RotateServiceAccountKeyRequest) (*RotateServiceAccountKeyResponse, error)
+	// Revoke a service account, its API keys and the JWTs exchanged for them stop working right away. Admins only.
+	RevokeServiceAccount(context.Context, *RevokeServiceAccountRequest) (*RevokeServiceAccountResponse, error)
+	// Exchange the API key of a service account for a short lived JWT, like an OAuth client credentials grant,
+	// for callers that pass tokens on to services verifying them with GetJWKS.
+	GetServiceAccountToken(context.Context, *GetServiceAccountTokenRequest) (*GetServiceAccountTokenResponse, error)
 	mustEmbedUnimplementedUsersServer()
 }
 
@@ -152,6 +490,78 @@ func (UnimplementedUsersServer) DeleteUserByID(context.Context, *DeleteUserByIDR
 func (UnimplementedUsersServer) UpdateUserInfo(context.Context, *UpdateUserInfoRequest) (*UpdateUserInfoResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateUserInfo not implemented")
 }
+func (UnimplementedUsersServer) RefreshJWTToken(context.Context, *RefreshJWTTokenRequest) (*RefreshJWTTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshJWTToken not implemented")
+}
+func (UnimplementedUsersServer) Logout(context.Context, *LogoutRequest) (*LogoutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Logout not implemented")
+}
+func (UnimplementedUsersServer) LogoutEverywhere(context.Context, *LogoutEverywhereRequest) (*LogoutEverywhereResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LogoutEverywhere not implemented")
+}
+func (UnimplementedUsersServer) GetJWKS(context.Context, *GetJWKSRequest) (*GetJWKSResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJWKS not implemented")
+}
+func (UnimplementedUsersServer) UpdateUserRoles(context.Context, *UpdateUserRolesRequest) (*UpdateUserRolesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateUserRoles not implemented")
+}
+func (UnimplementedUsersServer) UnlockAccount(context.Context, *UnlockAccountRequest) (*UnlockAccountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnlockAccount not implemented")
+}
+func (UnimplementedUsersServer) EnrollTOTP(context.Context, *EnrollTOTPRequest) (*EnrollTOTPResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnrollTOTP not implemented")
+}
+func (UnimplementedUsersServer) VerifyTOTP(context.Context, *VerifyTOTPRequest) (*VerifyTOTPResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyTOTP not implemented")
+}
+func (UnimplementedUsersServer) DisableTOTP(context.Context, *DisableTOTPRequest) (*DisableTOTPResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableTOTP not implemented")
+}
+func (UnimplementedUsersServer) CompleteMFAChallenge(context.Context, *CompleteMFAChallengeRequest) (*CompleteMFAChallengeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompleteMFAChallenge not implemented")
+}
+func (UnimplementedUsersServer) RequestPasswordReset(context.Context, *RequestPasswordResetRequest) (*RequestPasswordResetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestPasswordReset not implemented")
+}
+func (UnimplementedUsersServer) CompletePasswordReset(context.Context, *CompletePasswordResetRequest) (*CompletePasswordResetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompletePasswordReset not implemented")
+}
+func (UnimplementedUsersServer) VerifyEmail(context.Context, *VerifyEmailRequest) (*VerifyEmailResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyEmail not implemented")
+}
+func (UnimplementedUsersServer) ResendVerificationEmail(context.Context, *ResendVerificationEmailRequest) (*ResendVerificationEmailResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResendVerificationEmail not implemented")
+}
+func (UnimplementedUsersServer) RequestLoginCode(context.Context, *RequestLoginCodeRequest) (*RequestLoginCodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RequestLoginCode not implemented")
+}
+func (UnimplementedUsersServer) LoginWithCode(context.Context, *LoginWithCodeRequest) (*LoginWithCodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LoginWithCode not implemented")
+}
+func (UnimplementedUsersServer) ChangePassword(context.Context, *ChangePasswordRequest) (*ChangePasswordResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangePassword not implemented")
+}
+func (UnimplementedUsersServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedUsersServer) RevokeSession(context.Context, *RevokeSessionRequest) (*RevokeSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeSession not implemented")
+}
+func (UnimplementedUsersServer) CreateServiceAccount(context.Context, *CreateServiceAccountRequest) (*CreateServiceAccountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateServiceAccount not implemented")
+}
+func (UnimplementedUsersServer) ListServiceAccounts(context.Context, *ListServiceAccountsRequest) (*ListServiceAccountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListServiceAccounts not implemented")
+}
+func (UnimplementedUsersServer) RotateServiceAccountKey(context.Context, *RotateServiceAccountKeyRequest) (*RotateServiceAccountKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateServiceAccountKey not implemented")
+}
+func (UnimplementedUsersServer) RevokeServiceAccount(context.Context, *RevokeServiceAccountRequest) (*RevokeServiceAccountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeServiceAccount not implemented")
+}
+func (UnimplementedUsersServer) GetServiceAccountToken(context.Context, *GetServiceAccountTokenRequest) (*GetServiceAccountTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetServiceAccountToken not implemented")
+}
 func (UnimplementedUsersServer) mustEmbedUnimplementedUsersServer() {}
 
 // UnsafeUsersServer may be embedded to opt out of forward compatibility for this service.
@@ -291,37 +701,565 @@ func _Users_UpdateUserInfo_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
-var _Users_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "kic.users.Users",
-	HandlerType: (*UsersServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetJWTToken",
-			Handler:    _Users_GetJWTToken_Handler,
-		},
-		{
-			MethodName: "AddUser",
-			Handler:    _Users_AddUser_Handler,
-		},
-		{
-			MethodName: "GetUserByUsername",
-			Handler:    _Users_GetUserByUsername_Handler,
-		},
-		{
-			MethodName: "GetUserByID",
-			Handler:    _Users_GetUserByID_Handler,
-		},
-		{
-			MethodName: "GetUserNameByID",
-			Handler:    _Users_GetUserNameByID_Handler,
-		},
-		{
-			MethodName: "DeleteUserByID",
-			Handler:    _Users_DeleteUserByID_Handler,
-		},
-		{
-			MethodName: "UpdateUserInfo",
-			Handler:    _Users_UpdateUserInfo_Handler,
+func _Users_RefreshJWTToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshJWTTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).RefreshJWTToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/RefreshJWTToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).RefreshJWTToken(ctx, req.(*RefreshJWTTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_Logout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogoutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).Logout(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/Logout",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).Logout(ctx, req.(*LogoutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_LogoutEverywhere_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogoutEverywhereRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).LogoutEverywhere(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/LogoutEverywhere",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).LogoutEverywhere(ctx, req.(*LogoutEverywhereRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_GetJWKS_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJWKSRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).GetJWKS(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/GetJWKS",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).GetJWKS(ctx, req.(*GetJWKSRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_UpdateUserRoles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateUserRolesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).UpdateUserRoles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/UpdateUserRoles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).UpdateUserRoles(ctx, req.(*UpdateUserRolesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_UnlockAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).UnlockAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/UnlockAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).UnlockAccount(ctx, req.(*UnlockAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_EnrollTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollTOTPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).EnrollTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/EnrollTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).EnrollTOTP(ctx, req.(*EnrollTOTPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_VerifyTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyTOTPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).VerifyTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/VerifyTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).VerifyTOTP(ctx, req.(*VerifyTOTPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_DisableTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableTOTPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).DisableTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/DisableTOTP",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).DisableTOTP(ctx, req.(*DisableTOTPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_CompleteMFAChallenge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompleteMFAChallengeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).CompleteMFAChallenge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/CompleteMFAChallenge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).CompleteMFAChallenge(ctx, req.(*CompleteMFAChallengeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_RequestPasswordReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestPasswordResetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).RequestPasswordReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/RequestPasswordReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).RequestPasswordReset(ctx, req.(*RequestPasswordResetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_CompletePasswordReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompletePasswordResetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).CompletePasswordReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/CompletePasswordReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).CompletePasswordReset(ctx, req.(*CompletePasswordResetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_VerifyEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).VerifyEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/VerifyEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).VerifyEmail(ctx, req.(*VerifyEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ResendVerificationEmail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResendVerificationEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ResendVerificationEmail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/ResendVerificationEmail",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ResendVerificationEmail(ctx, req.(*ResendVerificationEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_RequestLoginCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestLoginCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).RequestLoginCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/RequestLoginCode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).RequestLoginCode(ctx, req.(*RequestLoginCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_LoginWithCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginWithCodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).LoginWithCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/LoginWithCode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).LoginWithCode(ctx, req.(*LoginWithCodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ChangePassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangePasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ChangePassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/ChangePassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ChangePassword(ctx, req.(*ChangePasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/ListSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_RevokeSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).RevokeSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/RevokeSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).RevokeSession(ctx, req.(*RevokeSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_CreateServiceAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateServiceAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).CreateServiceAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/CreateServiceAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).CreateServiceAccount(ctx, req.(*CreateServiceAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_ListServiceAccounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServiceAccountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).ListServiceAccounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/ListServiceAccounts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).ListServiceAccounts(ctx, req.(*ListServiceAccountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_RotateServiceAccountKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateServiceAccountKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).RotateServiceAccountKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/RotateServiceAccountKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).RotateServiceAccountKey(ctx, req.(*RotateServiceAccountKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_RevokeServiceAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeServiceAccountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).RevokeServiceAccount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/RevokeServiceAccount",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).RevokeServiceAccount(ctx, req.(*RevokeServiceAccountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Users_GetServiceAccountToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServiceAccountTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UsersServer).GetServiceAccountToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/kic.users.Users/GetServiceAccountToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UsersServer).GetServiceAccountToken(ctx, req.(*GetServiceAccountTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Users_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "kic.users.Users",
+	HandlerType: (*UsersServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetJWTToken",
+			Handler:    _Users_GetJWTToken_Handler,
+		},
+		{
+			MethodName: "AddUser",
+			Handler:    _Users_AddUser_Handler,
+		},
+		{
+			MethodName: "GetUserByUsername",
+			Handler:    _Users_GetUserByUsername_Handler,
+		},
+		{
+			MethodName: "GetUserByID",
+			Handler:    _Users_GetUserByID_Handler,
+		},
+		{
+			MethodName: "GetUserNameByID",
+			Handler:    _Users_GetUserNameByID_Handler,
+		},
+		{
+			MethodName: "DeleteUserByID",
+			Handler:    _Users_DeleteUserByID_Handler,
+		},
+		{
+			MethodName: "UpdateUserInfo",
+			Handler:    _Users_UpdateUserInfo_Handler,
+		},
+		{
+			MethodName: "RefreshJWTToken",
+			Handler:    _Users_RefreshJWTToken_Handler,
+		},
+		{
+			MethodName: "Logout",
+			Handler:    _Users_Logout_Handler,
+		},
+		{
+			MethodName: "LogoutEverywhere",
+			Handler:    _Users_LogoutEverywhere_Handler,
+		},
+		{
+			MethodName: "GetJWKS",
+			Handler:    _Users_GetJWKS_Handler,
+		},
+		{
+			MethodName: "UpdateUserRoles",
+			Handler:    _Users_UpdateUserRoles_Handler,
+		},
+		{
+			MethodName: "UnlockAccount",
+			Handler:    _Users_UnlockAccount_Handler,
+		},
+		{
+			MethodName: "EnrollTOTP",
+			Handler:    _Users_EnrollTOTP_Handler,
+		},
+		{
+			MethodName: "VerifyTOTP",
+			Handler:    _Users_VerifyTOTP_Handler,
+		},
+		{
+			MethodName: "DisableTOTP",
+			Handler:    _Users_DisableTOTP_Handler,
+		},
+		{
+			MethodName: "CompleteMFAChallenge",
+			Handler:    _Users_CompleteMFAChallenge_Handler,
+		},
+		{
+			MethodName: "RequestPasswordReset",
+			Handler:    _Users_RequestPasswordReset_Handler,
+		},
+		{
+			MethodName: "CompletePasswordReset",
+			Handler:    _Users_CompletePasswordReset_Handler,
+		},
+		{
+			MethodName: "VerifyEmail",
+			Handler:    _Users_VerifyEmail_Handler,
+		},
+		{
+			MethodName: "ResendVerificationEmail",
+			Handler:    _Users_ResendVerificationEmail_Handler,
+		},
+		{
+			MethodName: "RequestLoginCode",
+			Handler:    _Users_RequestLoginCode_Handler,
+		},
+		{
+			MethodName: "LoginWithCode",
+			Handler:    _Users_LoginWithCode_Handler,
+		},
+		{
+			MethodName: "ChangePassword",
+			Handler:    _Users_ChangePassword_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _Users_ListSessions_Handler,
+		},
+		{
+			MethodName: "RevokeSession",
+			Handler:    _Users_RevokeSession_Handler,
+		},
+		{
+			MethodName: "CreateServiceAccount",
+			Handler:    _Users_CreateServiceAccount_Handler,
+		},
+		{
+			MethodName: "ListServiceAccounts",
+			Handler:    _Users_ListServiceAccounts_Handler,
+		},
+		{
+			MethodName: "RotateServiceAccountKey",
+			Handler:    _Users_RotateServiceAccountKey_Handler,
+		},
+		{
+			MethodName: "RevokeServiceAccount",
+			Handler:    _Users_RevokeServiceAccount_Handler,
+		},
+		{
+			MethodName: "GetServiceAccountToken",
+			Handler:    _Users_GetServiceAccountToken_Handler,
 		},
 	},
 	Streams:  []grpc.StreamDesc{},